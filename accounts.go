@@ -7,6 +7,11 @@ package main
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"errors"
+	"flag"
+	"os"
+	"strings"
 
 	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
@@ -228,6 +233,15 @@ func (a *app) newDeleteAccountForm(previous tview.Primitive, address common.Addr
 }
 
 func (a *app) setAccount(address common.Address, password string) error {
+	key, err := a.accountKey(address, password)
+	if err != nil {
+		return err
+	}
+	return a.setClient(context.Background(), key)
+}
+
+// accountKey returns the private key of the keystore account.
+func (a *app) accountKey(address common.Address, password string) (*ecdsa.PrivateKey, error) {
 	var account *accounts.Account
 	for _, a := range a.keystore.Accounts() {
 		if address == a.Address {
@@ -236,19 +250,18 @@ func (a *app) setAccount(address common.Address, password string) error {
 		}
 	}
 	if account == nil {
-		return accounts.ErrUnknownAccount
+		return nil, accounts.ErrUnknownAccount
 	}
 
 	keyJSON, err := a.keystore.Export(*account, password, "")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	key, err := keystore.DecryptKey(keyJSON, "")
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	return a.setClient(context.Background(), key)
+	return key.PrivateKey, nil
 }
 
 func (a *app) deleteAccount(address common.Address, password string) error {
@@ -265,3 +278,71 @@ func (a *app) deleteAccount(address common.Address, password string) error {
 
 	return a.keystore.Delete(*account, password)
 }
+
+// passwordEnvVariable is the name of the environment variable that is used
+// for the account password when the password file is not provided.
+const passwordEnvVariable = "SCHULZEONEAS_PASSWORD"
+
+// accountOptions holds command line flags for unlocking a keystore account
+// without user interaction.
+type accountOptions struct {
+	address      *string
+	passwordFile *string
+}
+
+func newAccountOptions(cli *flag.FlagSet) *accountOptions {
+	return &accountOptions{
+		address:      cli.String("account", "", "Keystore account address, optional if there is only one account"),
+		passwordFile: cli.String("password-file", "", "File with the account password, "+passwordEnvVariable+" environment variable is used if not set"),
+	}
+}
+
+func (o *accountOptions) unlock(a *app) error {
+	key, err := o.key(a)
+	if err != nil {
+		return err
+	}
+	return a.setClient(context.Background(), key)
+}
+
+// key returns the private key of the selected keystore account.
+func (o *accountOptions) key(a *app) (*ecdsa.PrivateKey, error) {
+	address, err := o.selectAccount(a)
+	if err != nil {
+		return nil, err
+	}
+	password, err := o.password()
+	if err != nil {
+		return nil, err
+	}
+	return a.accountKey(address, password)
+}
+
+func (o *accountOptions) selectAccount(a *app) (common.Address, error) {
+	if *o.address != "" {
+		if !common.IsHexAddress(*o.address) {
+			return common.Address{}, errors.New("invalid account address")
+		}
+		return common.HexToAddress(*o.address), nil
+	}
+	accounts := a.keystore.Accounts()
+	switch len(accounts) {
+	case 0:
+		return common.Address{}, errors.New("no accounts in keystore")
+	case 1:
+		return accounts[0].Address, nil
+	default:
+		return common.Address{}, errors.New("multiple accounts in keystore, select one with the account flag")
+	}
+}
+
+func (o *accountOptions) password() (string, error) {
+	if *o.passwordFile == "" {
+		return os.Getenv(passwordEnvVariable), nil
+	}
+	data, err := os.ReadFile(*o.passwordFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
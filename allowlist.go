@@ -0,0 +1,208 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// allowlistTree is the Merkle tree with keccak256 hashes of sorted unique
+// addresses as leaves. Pairs of nodes are hashed in the sorted order and a
+// node without a pair is moved to the next level unchanged.
+type allowlistTree struct {
+	addresses []common.Address
+	// Levels of nodes from the leaves to the root.
+	levels [][]common.Hash
+}
+
+func newAllowlistTree(addresses []common.Address) *allowlistTree {
+	addresses = sortedAddresses(addresses)
+	nodes := make([]common.Hash, 0, len(addresses))
+	for _, a := range addresses {
+		nodes = append(nodes, crypto.Keccak256Hash(a[:]))
+	}
+	levels := [][]common.Hash{nodes}
+	for len(nodes) > 1 {
+		next := make([]common.Hash, 0, (len(nodes)+1)/2)
+		for i := 0; i < len(nodes); i += 2 {
+			if i+1 == len(nodes) {
+				next = append(next, nodes[i])
+				continue
+			}
+			next = append(next, hashAllowlistPair(nodes[i], nodes[i+1]))
+		}
+		levels = append(levels, next)
+		nodes = next
+	}
+	return &allowlistTree{
+		addresses: addresses,
+		levels:    levels,
+	}
+}
+
+// root returns the root of the tree. The root of an empty allowlist is zero.
+func (t *allowlistTree) root() common.Hash {
+	top := t.levels[len(t.levels)-1]
+	if len(top) == 0 {
+		return common.Hash{}
+	}
+	return top[0]
+}
+
+// proof returns sibling nodes on the path from the address leaf to the root,
+// or false if the address is not in the allowlist.
+func (t *allowlistTree) proof(address common.Address) ([]common.Hash, bool) {
+	i, ok := slices.BinarySearchFunc(t.addresses, address, func(a, b common.Address) int {
+		return a.Cmp(b)
+	})
+	if !ok {
+		return nil, false
+	}
+	var proof []common.Hash
+	for _, nodes := range t.levels[:len(t.levels)-1] {
+		if sibling := i ^ 1; sibling < len(nodes) {
+			proof = append(proof, nodes[sibling])
+		}
+		i /= 2
+	}
+	return proof, true
+}
+
+// allowlistRoot returns the root of the allowlist Merkle tree.
+func allowlistRoot(addresses []common.Address) common.Hash {
+	return newAllowlistTree(addresses).root()
+}
+
+// verifyAllowlistProof returns true if the proof links the address to the
+// allowlist Merkle tree root.
+func verifyAllowlistProof(root common.Hash, address common.Address, proof []common.Hash) bool {
+	node := crypto.Keccak256Hash(address[:])
+	for _, sibling := range proof {
+		node = hashAllowlistPair(node, sibling)
+	}
+	return node == root
+}
+
+func hashAllowlistPair(a, b common.Hash) common.Hash {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+	return crypto.Keccak256Hash(a[:], b[:])
+}
+
+func sortedAddresses(addresses []common.Address) []common.Address {
+	s := slices.Clone(addresses)
+	slices.SortFunc(s, func(a, b common.Address) int {
+		return a.Cmp(b)
+	})
+	return slices.Compact(s)
+}
+
+// parseAllowlist parses hex addresses of the allowlist.
+func parseAllowlist(s []string) ([]common.Address, error) {
+	addresses := make([]common.Address, 0, len(s))
+	for i, a := range s {
+		a = strings.TrimSpace(a)
+		if !common.IsHexAddress(a) {
+			return nil, fmt.Errorf("allowlist address %v %q is not valid", i+1, a)
+		}
+		addresses = append(addresses, common.HexToAddress(a))
+	}
+	if len(addresses) == 0 {
+		return nil, errors.New("allowlist has no addresses")
+	}
+	return sortedAddresses(addresses), nil
+}
+
+// readAllowlist reads a file with one address per line. Empty lines and lines
+// starting with # are ignored.
+func readAllowlist(filename string) ([]common.Address, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	addresses, err := parseAllowlist(lines)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return addresses, nil
+}
+
+// addAllowlist stores the allowlist in the local index and returns its root.
+func (a *app) addAllowlist(ctx context.Context, addresses []common.Address) (common.Hash, error) {
+	index, err := a.openIndex(ctx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	root := allowlistRoot(addresses)
+	if _, ok := index.Allowlists[root]; ok {
+		return root, nil
+	}
+	index.Allowlists[root] = sortedAddresses(addresses)
+	return root, index.save()
+}
+
+// importAllowlist reads the allowlist file of the voting and stores it in the
+// local index if its root matches the one in the voting.
+func (a *app) importAllowlist(ctx context.Context, v *voting, filename string) error {
+	if v.AllowlistRoot == (common.Hash{}) {
+		return fmt.Errorf("voting %s does not have an allowlist", v.UID)
+	}
+	addresses, err := readAllowlist(filename)
+	if err != nil {
+		return err
+	}
+	if root := allowlistRoot(addresses); root != v.AllowlistRoot {
+		return fmt.Errorf("allowlist root %s does not match the voting allowlist root %s", root, v.AllowlistRoot)
+	}
+	_, err = a.addAllowlist(ctx, addresses)
+	return err
+}
+
+// errAllowlistUnavailable is returned when the voting has an allowlist that
+// is not stored in the local index.
+var errAllowlistUnavailable = errors.New("voting allowlist is not available, import it from the allowlist file")
+
+// getAllowlist returns the Merkle tree of addresses that are eligible to vote
+// or nil if the voting does not have an allowlist.
+func (a *app) getAllowlist(ctx context.Context, v *voting) (*allowlistTree, error) {
+	if v.AllowlistRoot == (common.Hash{}) {
+		return nil, nil
+	}
+	index, err := a.openIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	addresses, ok := index.Allowlists[v.AllowlistRoot]
+	if !ok {
+		return nil, errAllowlistUnavailable
+	}
+	return newAllowlistTree(addresses), nil
+}
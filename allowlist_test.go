@@ -0,0 +1,121 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestAllowlistRoot(t *testing.T) {
+	a := common.HexToAddress("0x08752c431C3E38b12e94a0F195B166590e764831")
+	b := common.HexToAddress("0x5B38Da6a701c568545dCfcB03FcB875f56beddC4")
+	c := common.HexToAddress("0xAb8483F64d9C6d1EcF9b849Ae677dD3315835cb2")
+
+	ha := crypto.Keccak256Hash(a[:])
+	hb := crypto.Keccak256Hash(b[:])
+	hc := crypto.Keccak256Hash(c[:])
+
+	hashPair := func(x, y common.Hash) common.Hash {
+		if x.Cmp(y) > 0 {
+			x, y = y, x
+		}
+		return crypto.Keccak256Hash(x[:], y[:])
+	}
+
+	for _, tc := range []struct {
+		name      string
+		addresses []common.Address
+		want      common.Hash
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:      "single",
+			addresses: []common.Address{a},
+			want:      ha,
+		},
+		{
+			name:      "pair",
+			addresses: []common.Address{b, a},
+			want:      hashPair(ha, hb),
+		},
+		{
+			name:      "odd",
+			addresses: []common.Address{c, a, b},
+			want:      hashPair(hashPair(ha, hb), hc),
+		},
+		{
+			name:      "duplicates",
+			addresses: []common.Address{a, b, a},
+			want:      hashPair(ha, hb),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assertEqual(t, "root", allowlistRoot(tc.addresses), tc.want)
+		})
+	}
+}
+
+func TestAllowlistProof(t *testing.T) {
+	outsider := common.HexToAddress("0xAb8483F64d9C6d1EcF9b849Ae677dD3315835cb2")
+
+	for n := 1; n <= 9; n++ {
+		addresses := make([]common.Address, 0, n)
+		for i := range n {
+			addresses = append(addresses, common.BigToAddress(big.NewInt(int64(i+1))))
+		}
+		tree := newAllowlistTree(addresses)
+		root := tree.root()
+		assertEqual(t, "root", root, allowlistRoot(addresses))
+
+		for _, a := range addresses {
+			proof, ok := tree.proof(a)
+			if !ok {
+				t.Fatalf("no proof for address %s in allowlist of %v addresses", a, n)
+			}
+			if !verifyAllowlistProof(root, a, proof) {
+				t.Errorf("proof for address %s in allowlist of %v addresses is not valid", a, n)
+			}
+			if verifyAllowlistProof(root, outsider, proof) {
+				t.Errorf("proof for address %s in allowlist of %v addresses is valid for another address", a, n)
+			}
+			if len(proof) > 0 {
+				proof[0][0] ^= 1
+				if verifyAllowlistProof(root, a, proof) {
+					t.Errorf("modified proof for address %s in allowlist of %v addresses is valid", a, n)
+				}
+			}
+		}
+
+		if _, ok := tree.proof(outsider); ok {
+			t.Errorf("proof for address outside of allowlist of %v addresses", n)
+		}
+	}
+}
+
+func TestParseAllowlist(t *testing.T) {
+	got, err := parseAllowlist([]string{
+		" 0x5B38Da6a701c568545dCfcB03FcB875f56beddC4",
+		"0x08752c431c3e38b12e94a0f195b166590e764831 ",
+	})
+	assertNilError(t, err)
+	assertEqual(t, "addresses", got, []common.Address{
+		common.HexToAddress("0x08752c431C3E38b12e94a0F195B166590e764831"),
+		common.HexToAddress("0x5B38Da6a701c568545dCfcB03FcB875f56beddC4"),
+	})
+
+	if _, err := parseAllowlist([]string{"0x1234"}); err == nil {
+		t.Error("invalid address parsed")
+	}
+	if _, err := parseAllowlist(nil); err == nil {
+		t.Error("empty allowlist parsed")
+	}
+}
@@ -6,6 +6,7 @@
 package main
 
 import (
+	"crypto/ecdsa"
 	"os"
 	"path/filepath"
 
@@ -19,13 +20,29 @@ import (
 type app struct {
 	*tview.Application
 
-	ethereumEndpoint   string
+	// directory where the keystore and other local data is stored
+	dataDir string
+
+	// name of the network profile
+	network          string
+	ethereumEndpoint string
+	// chain id that the endpoint must be on, not checked if it is zero
+	chainID uint64
+	// official EAS deployment on the chain is used if it is not set
 	easContractAddress common.Address
 	configUID          eas.UID
+	// backend is used instead of connecting to the ethereumEndpoint if it is
+	// set, as it is in tests with a simulated chain
+	backend eas.Backend
 
 	keystore *keystore.KeyStore
-	client   *eas.Client
-	config   *configSchema
+	// key of the client account that is used to connect to another network
+	key     *ecdsa.PrivateKey
+	client  *eas.Client
+	config  *configSchema
+	index   *attestationIndex
+	secrets *ballotSecrets
+	scanner *blockScanner
 }
 
 func newApp(
@@ -33,29 +50,41 @@ func newApp(
 	ethereumEndpoint string,
 	easContractAddress common.Address,
 	configUID eas.UID,
-) error {
-	keystoreDir := filepath.Join(configDir, "SchulzeOnEAS", "keystore")
+) (*app, error) {
+	dataDir := appDataDir(configDir)
+	keystoreDir := filepath.Join(dataDir, "keystore")
 
 	if err := os.MkdirAll(keystoreDir, 0700); err != nil {
-		return err
+		return nil, err
 	}
 
 	a := &app{
 		Application: tview.NewApplication(),
 
+		dataDir: dataDir,
+
 		ethereumEndpoint:   ethereumEndpoint,
 		easContractAddress: easContractAddress,
 		configUID:          configUID,
 
 		keystore: keystore.NewKeyStore(keystoreDir, keystore.StandardScryptN, keystore.StandardScryptP),
+		scanner:  newBlockScanner(),
 	}
-	a.render(a.newSetAccountOptions())
-	return a.Run()
+	return a, nil
+}
+
+// appDataDir returns the directory in the configuration directory where the
+// keystore and other local data is stored.
+func appDataDir(configDir string) string {
+	return filepath.Join(configDir, "SchulzeOnEAS")
 }
 
 func (a *app) render(primitive tview.Primitive) {
 	frame := tview.NewFrame(primitive)
 	frame.AddText("Schulze on EAS", true, tview.AlignCenter, tcell.ColorWhite)
+	if a.network != "" {
+		frame.AddText(a.network, true, tview.AlignLeft, tcell.ColorWhite)
+	}
 	if a.client != nil {
 		frame.AddText(shortAddress(a.client.Address()), true, tview.AlignRight, tcell.ColorWhite)
 	}
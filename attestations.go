@@ -0,0 +1,171 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"resenje.org/eas"
+)
+
+// callBatchSize is the maximal number of calls in a single JSON-RPC batch
+// request.
+const callBatchSize = 100
+
+// getAttestationABI is the EAS contract getAttestation method definition.
+var getAttestationABI = mustParseABI(`[{"inputs":[{"internalType":"bytes32","name":"uid","type":"bytes32"}],"name":"getAttestation","outputs":[{"components":[{"internalType":"bytes32","name":"uid","type":"bytes32"},{"internalType":"bytes32","name":"schema","type":"bytes32"},{"internalType":"uint64","name":"time","type":"uint64"},{"internalType":"uint64","name":"expirationTime","type":"uint64"},{"internalType":"uint64","name":"revocationTime","type":"uint64"},{"internalType":"bytes32","name":"refUID","type":"bytes32"},{"internalType":"address","name":"recipient","type":"address"},{"internalType":"address","name":"attester","type":"address"},{"internalType":"bool","name":"revocable","type":"bool"},{"internalType":"bytes","name":"data","type":"bytes"}],"internalType":"struct Attestation","name":"","type":"tuple"}],"stateMutability":"view","type":"function"}]`)
+
+func mustParseABI(s string) abi.ABI {
+	a, err := abi.JSON(strings.NewReader(s))
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// attestationTuple is the EAS contract Attestation struct as it is unpacked
+// from the getAttestation method result.
+type attestationTuple struct {
+	Uid            [32]byte
+	Schema         [32]byte
+	Time           uint64
+	ExpirationTime uint64
+	RevocationTime uint64
+	RefUID         [32]byte
+	Recipient      common.Address
+	Attester       common.Address
+	Revocable      bool
+	Data           []byte
+}
+
+func (t attestationTuple) attestation() *eas.Attestation {
+	return &eas.Attestation{
+		UID:            t.Uid,
+		Schema:         t.Schema,
+		Time:           time.Unix(int64(t.Time), 0),
+		ExpirationTime: time.Unix(int64(t.ExpirationTime), 0),
+		RevocationTime: time.Unix(int64(t.RevocationTime), 0),
+		RefUID:         t.RefUID,
+		Recipient:      t.Recipient,
+		Attester:       t.Attester,
+		Revocable:      t.Revocable,
+		Data:           t.Data,
+	}
+}
+
+// getAttestations returns attestations in the same order as their UIDs. They
+// are fetched with batches of eth_call JSON-RPC requests if the backend
+// supports them, or one by one otherwise.
+func (a *app) getAttestations(ctx context.Context, uids []eas.UID) ([]*eas.Attestation, error) {
+	inputs := make([][]byte, 0, len(uids))
+	for _, uid := range uids {
+		data, err := getAttestationABI.Pack("getAttestation", uid)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, data)
+	}
+	results, err := a.callContractBatch(ctx, a.easContractAddress, nil, inputs)
+	if err != nil {
+		return nil, err
+	}
+	attestations := make([]*eas.Attestation, 0, len(uids))
+	for i, r := range results {
+		if r.Err != nil {
+			return nil, fmt.Errorf("get attestation %s: %w", uids[i], r.Err)
+		}
+		values, err := getAttestationABI.Unpack("getAttestation", r.Data)
+		if err != nil {
+			return nil, fmt.Errorf("unpack attestation %s: %w", uids[i], err)
+		}
+		t := *abi.ConvertType(values[0], new(attestationTuple)).(*attestationTuple)
+		attestations = append(attestations, t.attestation())
+	}
+	return attestations, nil
+}
+
+// callResult is the result of a single contract call in a batch.
+type callResult struct {
+	Data []byte
+	Err  error
+}
+
+// callContractBatch calls the contract with every input at the block, or at
+// the latest block if it is nil, and returns results in the same order as
+// the inputs. Calls are sent in batches of eth_call JSON-RPC requests if the
+// backend supports them, or one by one otherwise.
+func (a *app) callContractBatch(ctx context.Context, contract common.Address, block *big.Int, inputs [][]byte) ([]callResult, error) {
+	results := make([]callResult, 0, len(inputs))
+	for len(inputs) > 0 {
+		n := min(len(inputs), callBatchSize)
+		batch, err := a.callContractBatchRequest(ctx, contract, block, inputs[:n])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, batch...)
+		inputs = inputs[n:]
+	}
+	return results, nil
+}
+
+func (a *app) callContractBatchRequest(ctx context.Context, contract common.Address, block *big.Int, inputs [][]byte) ([]callResult, error) {
+	c, ok := a.client.Backend().(interface{ Client() *rpc.Client })
+	if !ok || len(inputs) == 1 {
+		return a.callContractSequentially(ctx, contract, block, inputs), nil
+	}
+
+	blockNumber := "latest"
+	if block != nil {
+		blockNumber = hexutil.EncodeBig(block)
+	}
+	elems := make([]rpc.BatchElem, 0, len(inputs))
+	data := make([]hexutil.Bytes, len(inputs))
+	for i, input := range inputs {
+		elems = append(elems, rpc.BatchElem{
+			Method: "eth_call",
+			Args: []any{
+				map[string]any{
+					"to":    contract,
+					"input": hexutil.Bytes(input),
+				},
+				blockNumber,
+			},
+			Result: &data[i],
+		})
+	}
+
+	if err := c.Client().BatchCallContext(ctx, elems); err != nil {
+		// some providers do not support batch requests
+		return a.callContractSequentially(ctx, contract, block, inputs), nil
+	}
+
+	results := make([]callResult, 0, len(inputs))
+	for i, e := range elems {
+		results = append(results, callResult{Data: data[i], Err: e.Error})
+	}
+	return results, nil
+}
+
+func (a *app) callContractSequentially(ctx context.Context, contract common.Address, block *big.Int, inputs [][]byte) []callResult {
+	results := make([]callResult, 0, len(inputs))
+	for _, input := range inputs {
+		data, err := a.client.Backend().CallContract(ctx, ethereum.CallMsg{
+			To:   &contract,
+			Data: input,
+		}, block)
+		results = append(results, callResult{Data: data, Err: err})
+	}
+	return results
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"resenje.org/eas"
+)
+
+func TestGetAttestations_batch(t *testing.T) {
+	c := newTestChain(t, 2)
+
+	ctx := context.Background()
+
+	organizer := c.newApp(t, c.accounts[0])
+	v := createTestVoting(t, organizer, &voting{
+		Title:   "Board election",
+		Choices: []string{"Alice", "Bob"},
+	})
+	uids := []eas.UID{
+		castTestBallot(t, organizer, v, "1,2"),
+		castTestBallot(t, c.newApp(t, c.accounts[1]), v, "2,1"),
+		v.UID,
+	}
+	want := make([]*eas.Attestation, 0, len(uids))
+	for _, uid := range uids {
+		attestation, err := organizer.client.EAS.GetAttestation(ctx, uid)
+		assertNilError(t, err)
+		want = append(want, attestation)
+	}
+
+	service := &testEthService{rpc: c.backend.rpc}
+	a := c.newBatchApp(t, c.accounts[0], service)
+
+	got, err := a.getAttestations(ctx, uids)
+	assertNilError(t, err)
+	assertEqual(t, "attestations", got, want)
+	// attestations are fetched only with the batch request
+	assertEqual(t, "calls", service.calls.Load(), int64(len(uids)))
+
+	service.fail = uids[1]
+	_, err = a.getAttestations(ctx, uids)
+	if err == nil || !strings.Contains(err.Error(), uids[1].String()) {
+		t.Errorf("got error %v, want error for attestation %s", err, uids[1])
+	}
+}
+
+// newBatchApp returns the app with the client for the account that sends
+// JSON-RPC batch requests to the eth service.
+func (c *testChain) newBatchApp(t testing.TB, account *ecdsa.PrivateKey, service *testEthService) *app {
+	t.Helper()
+
+	server := rpc.NewServer()
+	assertNilError(t, server.RegisterName("eth", service))
+	t.Cleanup(server.Stop)
+
+	a, err := newApp(t.TempDir(), "", c.easAddress, c.configUID)
+	assertNilError(t, err)
+	a.backend = &batchBackend{
+		autoCommitBackend: c.backend,
+		rpc:               rpc.DialInProc(server),
+	}
+	assertNilError(t, a.setClient(context.Background(), account))
+	return a
+}
+
+// batchBackend is the simulated chain backend that exposes a JSON-RPC client
+// for batch requests.
+type batchBackend struct {
+	*autoCommitBackend
+	rpc *rpc.Client
+}
+
+func (b *batchBackend) Client() *rpc.Client {
+	return b.rpc
+}
+
+// testEthService is the eth_call JSON-RPC method that forwards calls to the
+// simulated chain and fails calls with the UID in the input.
+type testEthService struct {
+	rpc   *rpc.Client
+	fail  eas.UID
+	calls atomic.Int64
+}
+
+func (s *testEthService) Call(ctx context.Context, args map[string]any, block string) (hexutil.Bytes, error) {
+	s.calls.Add(1)
+	if input, _ := args["input"].(string); !s.fail.IsZero() && strings.HasSuffix(input, s.fail.String()[2:]) {
+		return nil, errors.New("execution reverted")
+	}
+	var result hexutil.Bytes
+	if err := s.rpc.CallContext(ctx, &result, "eth_call", args, block); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
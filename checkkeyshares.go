@@ -0,0 +1,74 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"resenje.org/eas"
+)
+
+// checkKeySharesCommand checks key shares that the account received in the
+// key ceremony and attests complaints about dealings with invalid shares.
+func checkKeySharesCommand() error {
+	cli := flag.NewFlagSet("schulzeoneas check-key-shares", flag.ExitOnError)
+
+	options := newAppOptions(cli)
+	account := newAccountOptions(cli)
+	ceremonyFlag := cli.String("ceremony", "", "UID of the key ceremony")
+
+	if err := cli.Parse(os.Args[2:]); err != nil {
+		log.Println(err)
+		cli.Usage()
+	}
+
+	if *ceremonyFlag == "" {
+		return errors.New("key ceremony UID is required")
+	}
+	if !isHexUID(*ceremonyFlag) {
+		return fmt.Errorf("invalid key ceremony UID %q", *ceremonyFlag)
+	}
+
+	a, err := options.newApp()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	key, err := account.key(a)
+	if err != nil {
+		return err
+	}
+	if err := a.setClient(ctx, key); err != nil {
+		return err
+	}
+
+	log.Println("Wallet address:", a.client.Address())
+
+	ceremony, err := a.getKeyCeremony(ctx, eas.HexDecodeUID(*ceremonyFlag))
+	if err != nil {
+		return err
+	}
+
+	uids, err := a.complainKeyShares(ctx, ceremony, key)
+	if err != nil {
+		return err
+	}
+	if len(uids) == 0 {
+		log.Println("All received key shares are valid")
+	}
+	for _, uid := range uids {
+		fmt.Println(uid)
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"resenje.org/eas"
+)
+
+func collectBallotsCommand() error {
+	cli := flag.NewFlagSet("schulzeoneas collect-ballots", flag.ExitOnError)
+
+	options := newAppOptions(cli)
+	votingFlag := cli.String("voting", "", "UID of the voting")
+	dirFlag := cli.String("dir", "", "Directory with JSON files of signed off-chain ballots")
+	formatFlag := cli.String("format", "text", "Output format: text, json or csv")
+	untilFlag := cli.String("until", "", "Do not count ballots signed at or after this RFC 3339 time")
+	allowlistFlag := cli.String("allowlist", "", "File with addresses of the voting allowlist, one per line")
+
+	if err := cli.Parse(os.Args[2:]); err != nil {
+		log.Println(err)
+		cli.Usage()
+	}
+
+	if *votingFlag == "" {
+		return errors.New("voting UID is required")
+	}
+	if !isHexUID(*votingFlag) {
+		return fmt.Errorf("invalid voting UID %q", *votingFlag)
+	}
+	if *dirFlag == "" {
+		return errors.New("ballots directory is required")
+	}
+	votingUID := eas.HexDecodeUID(*votingFlag)
+
+	var o tallyOptions
+	if *untilFlag != "" {
+		t, err := time.Parse(time.RFC3339, *untilFlag)
+		if err != nil {
+			return fmt.Errorf("until time: %w", err)
+		}
+		o.Until = t
+	}
+
+	write, err := resultsWriter(*formatFlag)
+	if err != nil {
+		return err
+	}
+
+	a, err := options.newApp()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	if err := a.setReadOnlyClient(ctx); err != nil {
+		return err
+	}
+
+	voting, err := a.getVoting(ctx, votingUID)
+	if err != nil {
+		return err
+	}
+
+	if *allowlistFlag != "" {
+		if err := a.importAllowlist(ctx, voting, *allowlistFlag); err != nil {
+			return err
+		}
+	}
+
+	records, invalid, err := a.getOffchainBallotRecords(ctx, voting, *dirFlag)
+	if err != nil {
+		return err
+	}
+	log.Println("Times of off-chain ballots are set by their signers, ballots signed with a time after the current chain time are not counted")
+	for _, filename := range sortedInvalidFiles(invalid) {
+		log.Printf("Not counted %s: %v", filename, invalid[filename])
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("no off-chain ballots of voting %s in %s", votingUID, *dirFlag)
+	}
+	o.Offchain = records
+
+	r, err := a.calculateResults(ctx, votingUID, o)
+	if err != nil {
+		return err
+	}
+
+	return write(os.Stdout, r)
+}
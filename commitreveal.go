@@ -0,0 +1,451 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/rivo/tview"
+	"resenje.org/eas"
+)
+
+// ballotCommitment returns the keccak256 hash of the voting UID, the attester
+// address, choice indexes and ranks of the ballot as big endian uint16 values
+// and the salt. The voting and the attester are included so that the
+// commitment can not be copied by another voter or to another voting.
+func ballotCommitment(votingUID eas.UID, attester common.Address, ballot ballotSchema, salt common.Hash) common.Hash {
+	data := make([]byte, 0, len(votingUID)+len(attester)+4*len(ballot)+len(salt))
+	data = append(data, votingUID[:]...)
+	data = append(data, attester[:]...)
+	for _, r := range ballot {
+		data = binary.BigEndian.AppendUint16(data, r.ChoiceIndex)
+		data = binary.BigEndian.AppendUint16(data, r.Rank)
+	}
+	data = append(data, salt[:]...)
+	return crypto.Keccak256Hash(data)
+}
+
+// ballotSecret is the ballot and the salt of a commitment that are stored
+// locally until the ballot is revealed.
+type ballotSecret struct {
+	VotingUID  eas.UID        `json:"voting"`
+	Attester   common.Address `json:"attester"`
+	Commitment common.Hash    `json:"commitment"`
+	Ballot     ballotSchema   `json:"ballot"`
+	Salt       common.Hash    `json:"salt"`
+	// Zero if the ballot is not revealed.
+	RevealUID eas.UID `json:"reveal"`
+}
+
+// ballotSecrets is a file in the configuration directory with secrets of
+// committed ballots. Unlike the index, it can not be rebuilt from the chain.
+type ballotSecrets struct {
+	path string
+
+	Secrets []ballotSecret `json:"secrets"`
+}
+
+func (a *app) openBallotSecrets(ctx context.Context) (*ballotSecrets, error) {
+	if a.secrets != nil {
+		return a.secrets, nil
+	}
+
+	path, err := a.chainDataPath(ctx, "secrets")
+	if err != nil {
+		return nil, err
+	}
+	secrets := &ballotSecrets{
+		path: path,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, secrets); err != nil {
+			return nil, fmt.Errorf("decode ballot secrets %s: %w", path, err)
+		}
+	}
+
+	a.secrets = secrets
+	return secrets, nil
+}
+
+func (s *ballotSecrets) add(secret ballotSecret) error {
+	s.Secrets = append(s.Secrets, secret)
+	return s.save()
+}
+
+// find returns the secret with the commitment or nil if it is not stored.
+func (s *ballotSecrets) find(commitment common.Hash) *ballotSecret {
+	for i := range s.Secrets {
+		if s.Secrets[i].Commitment == commitment {
+			return &s.Secrets[i]
+		}
+	}
+	return nil
+}
+
+// pending returns the latest secret of the attester in every voting if it is
+// not revealed, in the order in which they were stored.
+func (s *ballotSecrets) pending(attester common.Address) []ballotSecret {
+	latest := make(map[eas.UID]int)
+	for i, secret := range s.Secrets {
+		if secret.Attester == attester {
+			latest[secret.VotingUID] = i
+		}
+	}
+	var pending []ballotSecret
+	for i, secret := range s.Secrets {
+		if latest[secret.VotingUID] != i || secret.Attester != attester || !secret.RevealUID.IsZero() {
+			continue
+		}
+		pending = append(pending, secret)
+	}
+	return pending
+}
+
+// save writes the secrets to a temporary file that replaces the secrets
+// file, so that the file is not left partially written.
+func (s *ballotSecrets) save() error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// commitBallot attests the commitment of the ballot with a random salt. The
+// secret is stored locally before the commitment is attested, so that the
+// ballot can be revealed even if the app stops before the transaction is
+// mined.
+func (a *app) commitBallot(ctx context.Context, v *voting, ballot ballotSchema) (*types.Transaction, eas.WaitTx[eas.EASAttested], error) {
+	var salt common.Hash
+	if _, err := rand.Read(salt[:]); err != nil {
+		return nil, nil, err
+	}
+	commitment := ballotCommitment(v.UID, a.client.Address(), ballot, salt)
+
+	secrets, err := a.openBallotSecrets(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := secrets.add(ballotSecret{
+		VotingUID:  v.UID,
+		Attester:   a.client.Address(),
+		Commitment: commitment,
+		Ballot:     ballot,
+		Salt:       salt,
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	return a.client.EAS.Attest(ctx, a.config.schema(commitmentSchemaName).UID, &eas.AttestOptions{
+		Recipient: votingRecipient(v.UID),
+		RefUID:    v.UID,
+		Revocable: true,
+	}, ballotCommitmentSchema{
+		Commitment: commitment,
+	})
+}
+
+// revealBallot attests the reveal of the latest committed ballot of the
+// account in the voting.
+func (a *app) revealBallot(ctx context.Context, v *voting) (*types.Transaction, eas.WaitTx[eas.EASAttested], error) {
+	if !v.CommitReveal {
+		return nil, nil, fmt.Errorf("voting %s does not have secret ballots", v.UID)
+	}
+	now, err := a.chainTime(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := v.checkRevealTime(now); err != nil {
+		return nil, nil, err
+	}
+
+	commitments, err := a.getCommitments(ctx, v)
+	if err != nil {
+		return nil, nil, err
+	}
+	var latest *commitmentRecord
+	for i, c := range commitments {
+		if c.Attester == a.client.Address() {
+			latest = &commitments[i]
+		}
+	}
+	if latest == nil {
+		return nil, nil, fmt.Errorf("account did not submit a ballot in voting %s", v.UID)
+	}
+	if !latest.Unrevealed {
+		return nil, nil, fmt.Errorf("ballot %s is already revealed", latest.UID)
+	}
+
+	secrets, err := a.openBallotSecrets(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	secret := secrets.find(latest.Commitment)
+	if secret == nil {
+		return nil, nil, fmt.Errorf("secret of ballot %s is not stored locally", latest.UID)
+	}
+
+	tx, wait, err := a.client.EAS.Attest(ctx, a.config.schema(revealSchemaName).UID, &eas.AttestOptions{
+		Recipient: votingRecipient(v.UID),
+		RefUID:    latest.UID,
+	}, ballotRevealSchema{
+		Ballot: secret.Ballot,
+		Salt:   secret.Salt,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return tx, func(ctx context.Context) (*eas.EASAttested, error) {
+		r, err := wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+		secret.RevealUID = r.UID
+		return r, secrets.save()
+	}, nil
+}
+
+// commitmentRecord is a ballot commitment with the ballot set from the first
+// matching reveal. It is marked as unrevealed if there is no such reveal.
+type commitmentRecord struct {
+	ballotRecord
+	Commitment common.Hash
+}
+
+// getCommitments returns ballot commitments of the voting in the order in
+// which they were attested. A reveal matches the commitment that it
+// references if it is attested by the same attester in the reveal window and
+// the revealed ballot and salt have the same commitment.
+func (a *app) getCommitments(ctx context.Context, v *voting) ([]commitmentRecord, error) {
+	currentBlock, err := a.client.Backend().(ethereum.BlockNumberReader).BlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+	recipients := []common.Address{votingRecipient(v.UID)}
+
+	commitments, err := a.scanAttestations(ctx, a.config.schema(commitmentSchemaName).Block, currentBlock, recipients, a.config.schema(commitmentSchemaName).UID)
+	if err != nil {
+		return nil, err
+	}
+	reveals, err := a.scanAttestations(ctx, a.config.schema(revealSchemaName).Block, currentBlock, recipients, a.config.schema(revealSchemaName).UID)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]commitmentRecord, 0, len(commitments))
+	byUID := make(map[eas.UID]int)
+	for _, c := range commitments {
+		if c.attestation.RefUID != v.UID {
+			continue
+		}
+		r := commitmentRecord{
+			ballotRecord: ballotRecord{
+				UID:            c.attestation.UID,
+				VotingUID:      c.attestation.RefUID,
+				Recipient:      c.event.Recipient,
+				Attester:       c.event.Attester,
+				Time:           c.attestation.Time,
+				Block:          c.event.Raw.BlockNumber,
+				LogIndex:       c.event.Raw.Index,
+				RevocationTime: attestationTime(c.attestation.RevocationTime),
+				Unrevealed:     true,
+			},
+		}
+		var s ballotCommitmentSchema
+		if err := c.attestation.ScanValues(&s); err == nil {
+			r.Commitment = s.Commitment
+		}
+		byUID[r.UID] = len(records)
+		records = append(records, r)
+	}
+
+	for _, reveal := range reveals {
+		i, ok := byUID[reveal.attestation.RefUID]
+		if !ok {
+			continue
+		}
+		c := &records[i]
+		if !c.Unrevealed || reveal.event.Attester != c.Attester {
+			continue
+		}
+		if v.checkRevealTime(reveal.attestation.Time) != nil {
+			continue
+		}
+		var s ballotRevealSchema
+		if err := reveal.attestation.ScanValues(&s); err != nil {
+			continue
+		}
+		if ballotCommitment(v.UID, c.Attester, s.Ballot, s.Salt) != c.Commitment {
+			continue
+		}
+		c.Ballot = s.Ballot
+		c.Unrevealed = false
+	}
+	return records, nil
+}
+
+// getCommittedBallotRecords returns ballot records of commitments in the
+// voting with revealed ballots.
+func (a *app) getCommittedBallotRecords(ctx context.Context, v *voting) ([]ballotRecord, error) {
+	commitments, err := a.getCommitments(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]ballotRecord, 0, len(commitments))
+	for _, c := range commitments {
+		records = append(records, c.ballotRecord)
+	}
+	return records, nil
+}
+
+// attestedRecord is an Attested event with its attestation.
+type attestedRecord struct {
+	event       eas.EASAttested
+	attestation *eas.Attestation
+}
+
+// scanAttestations returns attestations of the schema with any of the
+// recipients that were attested in the block range, in the order in which
+// they were attested. Both start and end blocks are inclusive.
+func (a *app) scanAttestations(ctx context.Context, start, end uint64, recipients []common.Address, schemaUID eas.UID) ([]attestedRecord, error) {
+	var mu sync.Mutex
+	var records []attestedRecord
+	seen := make(map[eas.UID]struct{})
+	if err := a.scanner.scan(ctx, start, end, func(ctx context.Context, start, end uint64) (int, error) {
+		it, err := a.client.EAS.FilterAttested(ctx, start, &end, recipients, nil, []eas.UID{schemaUID})
+		if err != nil {
+			return 0, err
+		}
+		defer it.Close()
+
+		var events []eas.EASAttested
+		var uids []eas.UID
+		for it.Next() {
+			r := it.Value()
+			events = append(events, r)
+			uids = append(uids, r.UID)
+		}
+		if err := it.Error(); err != nil {
+			return 0, err
+		}
+
+		attestations, err := a.getAttestations(ctx, uids)
+		if err != nil {
+			return 0, err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for i, e := range events {
+			if _, ok := seen[e.UID]; ok {
+				continue
+			}
+			seen[e.UID] = struct{}{}
+			records = append(records, attestedRecord{
+				event:       e,
+				attestation: attestations[i],
+			})
+		}
+		return len(events), nil
+	}); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(records, func(a, b attestedRecord) int {
+		if c := cmp.Compare(a.event.Raw.BlockNumber, b.event.Raw.BlockNumber); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.event.Raw.Index, b.event.Raw.Index)
+	})
+	return records, nil
+}
+
+// pendingRevealsText returns a reminder about committed ballots of the
+// account that are not revealed or an empty string if there are none.
+func (a *app) pendingRevealsText() string {
+	if a.client == nil {
+		return ""
+	}
+	secrets, err := a.openBallotSecrets(context.Background())
+	if err != nil {
+		return ""
+	}
+	switch n := len(secrets.pending(a.client.Address())); n {
+	case 0:
+		return ""
+	case 1:
+		return "1 secret ballot is waiting to be revealed"
+	default:
+		return fmt.Sprintf("%v secret ballots are waiting to be revealed", n)
+	}
+}
+
+func (a *app) newRevealBallotsList(previous tview.Primitive) tview.Primitive {
+	ctx := context.Background()
+
+	secrets, err := a.openBallotSecrets(ctx)
+	if err != nil {
+		return a.newMessage(previous, "Error: "+err.Error())
+	}
+
+	list := tview.NewList()
+	for _, s := range secrets.pending(a.client.Address()) {
+		v, err := a.getVoting(ctx, s.VotingUID)
+		if err != nil {
+			list.AddItem(s.VotingUID.String(), "Error: "+err.Error(), 0, nil)
+			continue
+		}
+		window := "Reveal after " + formatTime(v.End)
+		if !v.RevealEnd.IsZero() {
+			window += " and before " + formatTime(v.RevealEnd)
+		}
+		list.AddItem(v.Title, window, 0, func() {
+			a.renderAsync(list, "Revealing ballot for\n"+v.Title, func() (tview.Primitive, error) {
+				_, wait, err := a.revealBallot(ctx, v)
+				if err != nil {
+					return nil, err
+				}
+				r, err := wait(ctx)
+				if err != nil {
+					return nil, err
+				}
+				return a.newMessage(previous, "Revealed ballot with UID\n"+r.UID.String()), nil
+			})
+		})
+	}
+	list.AddItem("Back", "", 'b', func() {
+		a.render(previous)
+	})
+	list.SetBorder(true).SetTitle(" Reveal secret ballots ").SetTitleAlign(tview.AlignLeft)
+	return list
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"resenje.org/eas"
+)
+
+func TestBallotCommitment(t *testing.T) {
+	votingUID := eas.HexDecodeUID("0x01")
+	attester := common.HexToAddress("0x0a")
+	ballot := ballotSchema{{ChoiceIndex: 0, Rank: 1}, {ChoiceIndex: 1, Rank: 2}}
+	salt := common.HexToHash("0x1234")
+
+	commitment := ballotCommitment(votingUID, attester, ballot, salt)
+	assertEqual(t, "commitment", ballotCommitment(votingUID, attester, ballot, salt), commitment)
+
+	for _, tc := range []struct {
+		name      string
+		votingUID eas.UID
+		attester  common.Address
+		ballot    ballotSchema
+		salt      common.Hash
+	}{
+		{
+			name:      "other voting",
+			votingUID: eas.HexDecodeUID("0x02"),
+			attester:  attester,
+			ballot:    ballot,
+			salt:      salt,
+		},
+		{
+			name:      "other attester",
+			votingUID: votingUID,
+			attester:  common.HexToAddress("0x0b"),
+			ballot:    ballot,
+			salt:      salt,
+		},
+		{
+			name:      "other ballot",
+			votingUID: votingUID,
+			attester:  attester,
+			ballot:    ballotSchema{{ChoiceIndex: 0, Rank: 2}, {ChoiceIndex: 1, Rank: 1}},
+			salt:      salt,
+		},
+		{
+			name:      "other salt",
+			votingUID: votingUID,
+			attester:  attester,
+			ballot:    ballot,
+			salt:      common.HexToHash("0x5678"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if ballotCommitment(tc.votingUID, tc.attester, tc.ballot, tc.salt) == commitment {
+				t.Error("got the same commitment")
+			}
+		})
+	}
+}
+
+func TestBallotSecrets_pending(t *testing.T) {
+	voting1 := eas.HexDecodeUID("0x01")
+	voting2 := eas.HexDecodeUID("0x02")
+	voting3 := eas.HexDecodeUID("0x03")
+	attester := common.HexToAddress("0x0a")
+	other := common.HexToAddress("0x0b")
+
+	s := &ballotSecrets{
+		path: filepath.Join(t.TempDir(), "secrets", "test.json"),
+	}
+	for _, secret := range []ballotSecret{
+		{VotingUID: voting1, Attester: attester, Commitment: common.HexToHash("0x11")},
+		{VotingUID: voting1, Attester: attester, Commitment: common.HexToHash("0x12")},
+		{VotingUID: voting2, Attester: attester, Commitment: common.HexToHash("0x21"), RevealUID: eas.HexDecodeUID("0xff")},
+		{VotingUID: voting3, Attester: attester, Commitment: common.HexToHash("0x31")},
+		{VotingUID: voting3, Attester: other, Commitment: common.HexToHash("0x32")},
+	} {
+		assertNilError(t, s.add(secret))
+	}
+
+	var commitments []common.Hash
+	for _, secret := range s.pending(attester) {
+		commitments = append(commitments, secret.Commitment)
+	}
+	assertEqual(t, "pending commitments", commitments, []common.Hash{
+		common.HexToHash("0x12"),
+		common.HexToHash("0x31"),
+	})
+
+	assertEqual(t, "found", s.find(common.HexToHash("0x21")).VotingUID, voting2)
+	if s.find(common.HexToHash("0x99")) != nil {
+		t.Error("found a secret that is not stored")
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+func createKeyCeremonyCommand() error {
+	cli := flag.NewFlagSet("schulzeoneas create-key-ceremony", flag.ExitOnError)
+
+	options := newAppOptions(cli)
+	account := newAccountOptions(cli)
+	trusteesFlag := cli.String("trustees", "", "Comma separated compressed public keys of trustees")
+	thresholdFlag := cli.Int("threshold", 0, "Number of trustees that are required to decrypt ballots")
+	dealingEndFlag := cli.String("dealing-end", "", "Time until which trustees deal key shares, in RFC3339 format")
+	complaintEndFlag := cli.String("complaint-end", "", "Time until which trustees complain about invalid key shares, in RFC3339 format")
+
+	if err := cli.Parse(os.Args[2:]); err != nil {
+		log.Println(err)
+		cli.Usage()
+	}
+
+	trustees, err := parseTrusteePublicKeys(*trusteesFlag)
+	if err != nil {
+		return err
+	}
+	if *dealingEndFlag == "" {
+		return errors.New("dealing end time is required")
+	}
+	dealingEnd, err := time.Parse(time.RFC3339, *dealingEndFlag)
+	if err != nil {
+		return fmt.Errorf("dealing end time: %w", err)
+	}
+	if *complaintEndFlag == "" {
+		return errors.New("complaint end time is required")
+	}
+	complaintEnd, err := time.Parse(time.RFC3339, *complaintEndFlag)
+	if err != nil {
+		return fmt.Errorf("complaint end time: %w", err)
+	}
+
+	ceremony := &keyCeremony{
+		TrusteePublicKeys: trustees,
+		Threshold:         *thresholdFlag,
+		DealingEnd:        dealingEnd,
+		ComplaintEnd:      complaintEnd,
+	}
+	if err := ceremony.validate(); err != nil {
+		return err
+	}
+
+	a, err := options.newApp()
+	if err != nil {
+		return err
+	}
+
+	if err := account.unlock(a); err != nil {
+		return err
+	}
+
+	log.Println("Wallet address:", a.client.Address())
+
+	ctx := context.Background()
+
+	tx, wait, err := a.createKeyCeremony(ctx, ceremony)
+	if err != nil {
+		return err
+	}
+	log.Println("Waiting key ceremony attestation:", tx.Hash())
+	r, err := wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(r.UID)
+	fmt.Println(tx.Hash())
+
+	return nil
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"gopkg.in/yaml.v3"
+	"resenje.org/eas"
+)
+
+func createVotingCommand() error {
+	cli := flag.NewFlagSet("schulzeoneas create-voting", flag.ExitOnError)
+
+	options := newAppOptions(cli)
+	account := newAccountOptions(cli)
+	fileFlag := cli.String("file", "", "YAML or JSON file with the voting definition")
+
+	if err := cli.Parse(os.Args[2:]); err != nil {
+		log.Println(err)
+		cli.Usage()
+	}
+
+	if *fileFlag == "" {
+		return errors.New("voting definition file is required")
+	}
+
+	definition, err := readVotingDefinition(*fileFlag)
+	if err != nil {
+		return err
+	}
+
+	voting, err := definition.voting()
+	if err != nil {
+		return fmt.Errorf("%s: %w", *fileFlag, err)
+	}
+	// a voting with the key ceremony is validated once its key is known
+	if definition.KeyCeremony == "" {
+		if err := voting.validate(); err != nil {
+			return fmt.Errorf("%s: %w", *fileFlag, err)
+		}
+	}
+
+	var allowlist []common.Address
+	if len(definition.Allowlist) > 0 {
+		allowlist, err = parseAllowlist(definition.Allowlist)
+		if err != nil {
+			return fmt.Errorf("%s: %w", *fileFlag, err)
+		}
+	}
+
+	a, err := options.newApp()
+	if err != nil {
+		return err
+	}
+
+	if err := account.unlock(a); err != nil {
+		return err
+	}
+
+	log.Println("Wallet address:", a.client.Address())
+
+	ctx := context.Background()
+
+	if allowlist != nil {
+		voting.AllowlistRoot, err = a.addAllowlist(ctx, allowlist)
+		if err != nil {
+			return err
+		}
+	}
+
+	if definition.KeyCeremony != "" {
+		voting.TallierPublicKey, err = a.keyCeremonyPublicKey(ctx, eas.HexDecodeUID(definition.KeyCeremony))
+		if err != nil {
+			return err
+		}
+		if err := voting.validate(); err != nil {
+			return fmt.Errorf("%s: %w", *fileFlag, err)
+		}
+	}
+
+	tx, wait, err := a.createVoting(ctx, voting)
+	if err != nil {
+		return err
+	}
+	log.Println("Waiting voting attestation:", tx.Hash())
+	r, err := wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(r.UID)
+	fmt.Println(tx.Hash())
+
+	return nil
+}
+
+// votingDefinition is the content of a voting definition file.
+type votingDefinition struct {
+	Title       string    `json:"title" yaml:"title"`
+	Description string    `json:"description" yaml:"description"`
+	Choices     []string  `json:"choices" yaml:"choices"`
+	Start       time.Time `json:"start" yaml:"start"`
+	End         time.Time `json:"end" yaml:"end"`
+	// Addresses that are eligible to vote, any address can vote if empty.
+	Allowlist  []string                    `json:"allowlist" yaml:"allowlist"`
+	Membership *votingMembershipDefinition `json:"membership" yaml:"membership"`
+	Weight     *votingWeightDefinition     `json:"weight" yaml:"weight"`
+	// Count votes of accounts that did not vote with ballots of their
+	// delegates.
+	Delegation bool `json:"delegation" yaml:"delegation"`
+	// Secret ballots that are revealed after the end time and before the
+	// reveal end time.
+	CommitReveal bool      `json:"commitReveal" yaml:"commitReveal"`
+	RevealEnd    time.Time `json:"revealEnd" yaml:"revealEnd"`
+	// Hex encoded compressed public key of the tallier that ballots are
+	// encrypted to.
+	TallierPublicKey string `json:"tallierPublicKey" yaml:"tallierPublicKey"`
+	// UID of the final key ceremony whose key is used as the tallier public
+	// key.
+	KeyCeremony string `json:"keyCeremony" yaml:"keyCeremony"`
+	// Sum encrypted pairwise preferences of ballots and decrypt only their
+	// sums.
+	HomomorphicTally bool `json:"homomorphicTally" yaml:"homomorphicTally"`
+}
+
+// votingMembershipDefinition defines the electorate as holders of membership
+// attestations of the schema from any of the issuers.
+type votingMembershipDefinition struct {
+	Schema  string   `json:"schema" yaml:"schema"`
+	Issuers []string `json:"issuers" yaml:"issuers"`
+	// Block in which the schema was registered, found on the chain if it is
+	// not set.
+	SchemaBlock uint64 `json:"schemaBlock" yaml:"schemaBlock"`
+}
+
+// votingWeightDefinition defines ballot weights as balances of the ERC-20 or
+// ERC-721 token at the snapshot block.
+type votingWeightDefinition struct {
+	Token string `json:"token" yaml:"token"`
+	Block uint64 `json:"block" yaml:"block"`
+}
+
+func (d votingDefinition) voting() (*voting, error) {
+	v := &voting{
+		Title:       d.Title,
+		Description: d.Description,
+		Choices:     d.Choices,
+		Start:       d.Start,
+		End:         d.End,
+		Delegation:  d.Delegation,
+
+		CommitReveal: d.CommitReveal,
+		RevealEnd:    d.RevealEnd,
+
+		HomomorphicTally: d.HomomorphicTally,
+	}
+	if m := d.Membership; m != nil {
+		if !isHexUID(m.Schema) {
+			return nil, fmt.Errorf("invalid membership schema %q", m.Schema)
+		}
+		v.MembershipSchemaUID = eas.HexDecodeUID(m.Schema)
+		v.MembershipSchemaBlock = m.SchemaBlock
+		issuers, err := parseAddresses(strings.Join(m.Issuers, ","))
+		if err != nil {
+			return nil, fmt.Errorf("membership issuers: %w", err)
+		}
+		v.MembershipIssuers = issuers
+	}
+	if d.TallierPublicKey != "" {
+		key, err := parseTallierPublicKey(d.TallierPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		v.TallierPublicKey = key
+	}
+	if d.KeyCeremony != "" {
+		if d.TallierPublicKey != "" {
+			return nil, errors.New("tallier public key and key ceremony can not be both set")
+		}
+		if !isHexUID(d.KeyCeremony) {
+			return nil, fmt.Errorf("invalid key ceremony %q", d.KeyCeremony)
+		}
+	}
+	if w := d.Weight; w != nil {
+		if !common.IsHexAddress(w.Token) {
+			return nil, fmt.Errorf("invalid weight token %q", w.Token)
+		}
+		v.WeightToken = common.HexToAddress(w.Token)
+		v.WeightBlock = w.Block
+	}
+	return v, nil
+}
+
+// readVotingDefinition decodes the voting definition file as JSON if it has
+// the .json extension, otherwise as YAML.
+func readVotingDefinition(filename string) (*votingDefinition, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var d votingDefinition
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&d); err != nil {
+			return nil, fmt.Errorf("decode %s: %w", filename, err)
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&d); err != nil {
+			return nil, fmt.Errorf("decode %s: %w", filename, err)
+		}
+	}
+	return &d, nil
+}
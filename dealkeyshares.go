@@ -0,0 +1,69 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"resenje.org/eas"
+)
+
+func dealKeySharesCommand() error {
+	cli := flag.NewFlagSet("schulzeoneas deal-key-shares", flag.ExitOnError)
+
+	options := newAppOptions(cli)
+	account := newAccountOptions(cli)
+	ceremonyFlag := cli.String("ceremony", "", "UID of the key ceremony")
+
+	if err := cli.Parse(os.Args[2:]); err != nil {
+		log.Println(err)
+		cli.Usage()
+	}
+
+	if *ceremonyFlag == "" {
+		return errors.New("key ceremony UID is required")
+	}
+	if !isHexUID(*ceremonyFlag) {
+		return fmt.Errorf("invalid key ceremony UID %q", *ceremonyFlag)
+	}
+
+	a, err := options.newApp()
+	if err != nil {
+		return err
+	}
+
+	if err := account.unlock(a); err != nil {
+		return err
+	}
+
+	log.Println("Wallet address:", a.client.Address())
+
+	ctx := context.Background()
+
+	ceremony, err := a.getKeyCeremony(ctx, eas.HexDecodeUID(*ceremonyFlag))
+	if err != nil {
+		return err
+	}
+
+	tx, wait, err := a.dealKeyShares(ctx, ceremony)
+	if err != nil {
+		return err
+	}
+	log.Println("Waiting key dealing attestation:", tx.Hash())
+	r, err := wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(r.UID)
+
+	return nil
+}
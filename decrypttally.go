@@ -0,0 +1,95 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"resenje.org/eas"
+)
+
+func decryptTallyCommand() error {
+	cli := flag.NewFlagSet("schulzeoneas decrypt-tally", flag.ExitOnError)
+
+	options := newAppOptions(cli)
+	account := newAccountOptions(cli)
+	votingFlag := cli.String("voting", "", "UID of the voting")
+	transcriptFlag := cli.String("transcript", "", "File to write the decryption transcript to")
+	formatFlag := cli.String("format", "text", "Output format: text, json or csv")
+	allowlistFlag := cli.String("allowlist", "", "File with addresses of the voting allowlist, one per line")
+
+	if err := cli.Parse(os.Args[2:]); err != nil {
+		log.Println(err)
+		cli.Usage()
+	}
+
+	if *votingFlag == "" {
+		return errors.New("voting UID is required")
+	}
+	if !isHexUID(*votingFlag) {
+		return fmt.Errorf("invalid voting UID %q", *votingFlag)
+	}
+	votingUID := eas.HexDecodeUID(*votingFlag)
+	if *transcriptFlag == "" {
+		return errors.New("transcript file is required")
+	}
+
+	write, err := resultsWriter(*formatFlag)
+	if err != nil {
+		return err
+	}
+
+	a, err := options.newApp()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	key, err := account.key(a)
+	if err != nil {
+		return err
+	}
+	if err := a.setClient(ctx, key); err != nil {
+		return err
+	}
+
+	log.Println("Wallet address:", a.client.Address())
+
+	voting, err := a.getVoting(ctx, votingUID)
+	if err != nil {
+		return err
+	}
+
+	if *allowlistFlag != "" {
+		if err := a.importAllowlist(ctx, voting, *allowlistFlag); err != nil {
+			return err
+		}
+	}
+
+	t, err := a.decryptTally(ctx, voting, key)
+	if err != nil {
+		return err
+	}
+	if err := writeDecryptionTranscript(*transcriptFlag, t); err != nil {
+		return err
+	}
+	log.Println("Decryption transcript:", *transcriptFlag)
+
+	r, err := a.calculateResults(ctx, votingUID, tallyOptions{
+		Transcript: t,
+	})
+	if err != nil {
+		return err
+	}
+
+	return write(os.Stdout, r)
+}
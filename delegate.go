@@ -0,0 +1,84 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"resenje.org/eas"
+)
+
+func delegateCommand() error {
+	cli := flag.NewFlagSet("schulzeoneas delegate", flag.ExitOnError)
+
+	options := newAppOptions(cli)
+	account := newAccountOptions(cli)
+	toFlag := cli.String("to", "", "Address of the delegate")
+	votingFlag := cli.String("voting", "", "UID of the voting, delegates in all votings if not set")
+	revokeFlag := cli.Bool("revoke", false, "Revoke the latest delegation instead of delegating")
+
+	if err := cli.Parse(os.Args[2:]); err != nil {
+		log.Println(err)
+		cli.Usage()
+	}
+
+	var votingUID eas.UID
+	if *votingFlag != "" {
+		if !isHexUID(*votingFlag) {
+			return fmt.Errorf("invalid voting UID %q", *votingFlag)
+		}
+		votingUID = eas.HexDecodeUID(*votingFlag)
+	}
+	if !*revokeFlag && !common.IsHexAddress(*toFlag) {
+		return errors.New("valid delegate address is required")
+	}
+
+	a, err := options.newApp()
+	if err != nil {
+		return err
+	}
+
+	if err := account.unlock(a); err != nil {
+		return err
+	}
+
+	log.Println("Wallet address:", a.client.Address())
+
+	ctx := context.Background()
+
+	if *revokeFlag {
+		tx, wait, err := a.revokeDelegation(ctx, votingUID)
+		if err != nil {
+			return err
+		}
+		log.Println("Waiting delegation revocation:", tx.Hash())
+		if _, err := wait(ctx); err != nil {
+			return err
+		}
+		fmt.Println(tx.Hash())
+		return nil
+	}
+
+	tx, wait, err := a.delegate(ctx, common.HexToAddress(*toFlag), votingUID)
+	if err != nil {
+		return err
+	}
+	log.Println("Waiting delegation attestation:", tx.Hash())
+	r, err := wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(r.UID)
+
+	return nil
+}
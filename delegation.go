@@ -0,0 +1,325 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/rivo/tview"
+	"resenje.org/eas"
+)
+
+// delegationRecord is a delegation attestation of the delegator's vote.
+type delegationRecord struct {
+	UID       eas.UID
+	Delegator common.Address
+	Delegate  common.Address
+	// Voting UID or the config UID for delegations in all votings.
+	RefUID   eas.UID
+	Time     time.Time
+	Block    uint64
+	LogIndex uint
+	// Zero if the delegation is not revoked.
+	RevocationTime time.Time
+}
+
+// isRevoked returns true if the delegation was revoked before the end time.
+// Zero end time does not limit the revocation time.
+func (r delegationRecord) isRevoked(end time.Time) bool {
+	if r.RevocationTime.IsZero() {
+		return false
+	}
+	return end.IsZero() || r.RevocationTime.Before(end)
+}
+
+// ballotDelegation is a counted ballot with votes delegated to its attester.
+type ballotDelegation struct {
+	Ballot   eas.UID        `json:"ballot"`
+	Attester common.Address `json:"attester"`
+	// Weight of the attester's own vote.
+	Weight int `json:"weight"`
+	// Sum of weights of votes delegated to the attester.
+	DelegatedWeight int              `json:"delegatedWeight"`
+	Delegators      []common.Address `json:"delegators"`
+}
+
+// delegate attests the delegation of the account's vote to the delegate in
+// the voting or, if the voting UID is zero, in all votings of the
+// configuration. A new delegation replaces the previous one with the same
+// scope.
+func (a *app) delegate(ctx context.Context, delegate common.Address, votingUID eas.UID) (*types.Transaction, eas.WaitTx[eas.EASAttested], error) {
+	if a.config.schema(delegationSchemaName).UID.IsZero() {
+		return nil, nil, errors.New("configuration does not support delegation")
+	}
+	if delegate == (common.Address{}) {
+		return nil, nil, errors.New("delegate address is required")
+	}
+	if delegate == a.client.Address() {
+		return nil, nil, errors.New("vote cannot be delegated to the same account")
+	}
+	refUID := a.configUID
+	if !votingUID.IsZero() {
+		v, err := a.getVoting(ctx, votingUID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !v.Delegation {
+			return nil, nil, fmt.Errorf("voting %s does not allow delegation", votingUID)
+		}
+		refUID = votingUID
+	}
+	return a.client.EAS.Attest(ctx, a.config.schema(delegationSchemaName).UID, &eas.AttestOptions{
+		Recipient: delegate,
+		RefUID:    refUID,
+		Revocable: true,
+	}, delegationSchema{
+		Delegate: delegate,
+	})
+}
+
+// revokeDelegation revokes the latest delegation of the account in the voting
+// or, if the voting UID is zero, in all votings of the configuration.
+func (a *app) revokeDelegation(ctx context.Context, votingUID eas.UID) (*types.Transaction, eas.WaitTx[eas.EASRevoked], error) {
+	if a.config.schema(delegationSchemaName).UID.IsZero() {
+		return nil, nil, errors.New("configuration does not support delegation")
+	}
+	refUID := a.configUID
+	if !votingUID.IsZero() {
+		refUID = votingUID
+	}
+	records, err := a.getDelegationRecords(ctx, []common.Address{a.client.Address()})
+	if err != nil {
+		return nil, nil, err
+	}
+	var latest *delegationRecord
+	for i, r := range records {
+		if r.RefUID == refUID {
+			latest = &records[i]
+		}
+	}
+	if latest == nil || latest.isRevoked(time.Time{}) {
+		return nil, nil, errors.New("account does not have a delegation to revoke")
+	}
+	return a.client.EAS.Revoke(ctx, a.config.schema(delegationSchemaName).UID, latest.UID, nil)
+}
+
+// getDelegationRecords returns delegation attestations of any of the
+// delegators, or of all delegators if none are provided, in the order in
+// which they were attested.
+func (a *app) getDelegationRecords(ctx context.Context, delegators []common.Address) ([]delegationRecord, error) {
+	currentBlock, err := a.client.Backend().(ethereum.BlockNumberReader).BlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var records []delegationRecord
+	seen := make(map[eas.UID]struct{})
+	if err := a.scanner.scan(ctx, a.config.schema(delegationSchemaName).Block, currentBlock, func(ctx context.Context, start, end uint64) (int, error) {
+		it, err := a.client.EAS.FilterAttested(ctx, start, &end, nil, delegators, []eas.UID{a.config.schema(delegationSchemaName).UID})
+		if err != nil {
+			return 0, err
+		}
+		defer it.Close()
+
+		var events []eas.EASAttested
+		var uids []eas.UID
+		for it.Next() {
+			r := it.Value()
+			events = append(events, r)
+			uids = append(uids, r.UID)
+		}
+		if err := it.Error(); err != nil {
+			return 0, err
+		}
+
+		attestations, err := a.getAttestations(ctx, uids)
+		if err != nil {
+			return 0, err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for i, r := range events {
+			d := attestations[i]
+			if _, ok := seen[d.UID]; ok {
+				continue
+			}
+			seen[d.UID] = struct{}{}
+			var s delegationSchema
+			if err := d.ScanValues(&s); err != nil {
+				continue
+			}
+			records = append(records, delegationRecord{
+				UID:            d.UID,
+				Delegator:      d.Attester,
+				Delegate:       s.Delegate,
+				RefUID:         d.RefUID,
+				Time:           d.Time,
+				Block:          r.Raw.BlockNumber,
+				LogIndex:       r.Raw.Index,
+				RevocationTime: attestationTime(d.RevocationTime),
+			})
+		}
+		return len(events), nil
+	}); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(records, func(a, b delegationRecord) int {
+		if c := cmp.Compare(a.Block, b.Block); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.LogIndex, b.LogIndex)
+	})
+	return records, nil
+}
+
+// getDelegations returns delegates of delegators in the voting that were
+// attested before the end time and the until block and not revoked before the
+// end time. Zero end time and until block do not limit delegations.
+func (a *app) getDelegations(ctx context.Context, v *voting, end time.Time, untilBlock uint64) (map[common.Address]common.Address, error) {
+	records, err := a.getDelegationRecords(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return activeDelegations(records, v.UID, a.configUID, end, untilBlock), nil
+}
+
+// activeDelegations returns delegates from the latest delegation of every
+// delegator in the voting. A delegation that references the voting takes
+// precedence over the one that references the configuration. A revoked
+// latest delegation withdraws the delegation without applying any previous
+// one with the same reference, but a revoked delegation in the voting leaves
+// the delegation in the configuration in effect. Records must be in the order
+// in which they were attested.
+func activeDelegations(records []delegationRecord, votingUID, configUID eas.UID, end time.Time, untilBlock uint64) map[common.Address]common.Address {
+	inVoting := make(map[common.Address]delegationRecord)
+	inConfig := make(map[common.Address]delegationRecord)
+	for _, r := range records {
+		if (!end.IsZero() && !r.Time.Before(end)) || (untilBlock > 0 && r.Block > untilBlock) {
+			continue
+		}
+		switch r.RefUID {
+		case votingUID:
+			inVoting[r.Delegator] = r
+		case configUID:
+			inConfig[r.Delegator] = r
+		}
+	}
+	delegations := make(map[common.Address]common.Address)
+	for _, scope := range []map[common.Address]delegationRecord{inConfig, inVoting} {
+		for delegator, r := range scope {
+			if r.isRevoked(end) {
+				continue
+			}
+			delegations[delegator] = r.Delegate
+		}
+	}
+	return delegations
+}
+
+// resolveDelegations follows delegation chains of delegators that did not
+// vote to the first delegate that voted and returns that voter for every
+// such delegator. Votes of delegators whose chains end with an account that
+// did not vote or delegate, or that end in a cycle, are not credited to any
+// voter. Returned cycles contain delegators in the delegation order, starting
+// with the lowest address.
+func resolveDelegations(delegations map[common.Address]common.Address, voters map[common.Address]struct{}) (credited map[common.Address]common.Address, cycles [][]common.Address) {
+	credited = make(map[common.Address]common.Address)
+	seenCycles := make(map[common.Address]struct{})
+	for delegator := range delegations {
+		if _, ok := voters[delegator]; ok {
+			continue
+		}
+		path := []common.Address{delegator}
+		current := delegator
+		for {
+			next, ok := delegations[current]
+			if !ok {
+				break
+			}
+			if _, ok := voters[next]; ok {
+				credited[delegator] = next
+				break
+			}
+			if i := slices.Index(path, next); i >= 0 {
+				cycle := path[i:]
+				first := slices.Index(cycle, slices.MinFunc(cycle, common.Address.Cmp))
+				cycle = slices.Concat(cycle[first:], cycle[:first])
+				if _, ok := seenCycles[cycle[0]]; !ok {
+					seenCycles[cycle[0]] = struct{}{}
+					cycles = append(cycles, cycle)
+				}
+				break
+			}
+			path = append(path, next)
+			current = next
+		}
+	}
+	slices.SortFunc(cycles, func(a, b []common.Address) int {
+		return a[0].Cmp(b[0])
+	})
+	return credited, cycles
+}
+
+func (a *app) newDelegateForm(previous tview.Primitive) tview.Primitive {
+	form := tview.NewForm()
+	var delegate string
+	form.AddInputField("Delegate", "", 42, nil, func(text string) {
+		delegate = text
+	})
+	var votingUID eas.UID
+	form.AddInputField("Voting", "", 67, nil, func(text string) {
+		votingUID = eas.HexDecodeUID(text)
+	})
+	form.AddTextView("", "Leave the voting empty to delegate in all votings", 0, 1, false, false)
+	form.AddButton("Delegate", func() {
+		if !common.IsHexAddress(strings.TrimSpace(delegate)) {
+			a.render(a.newMessage(form, "Error: invalid delegate address"))
+			return
+		}
+		tx, wait, err := a.delegate(context.Background(), common.HexToAddress(strings.TrimSpace(delegate)), votingUID)
+		if err != nil {
+			a.render(a.newMessage(form, "Error: "+err.Error()))
+			return
+		}
+		a.renderAsync(form, "Waiting transaction\n"+tx.Hash().String(), func() (tview.Primitive, error) {
+			r, err := wait(context.Background())
+			if err != nil {
+				return nil, err
+			}
+			return a.newMessage(previous, "Delegation UID\n"+r.UID.String()), nil
+		})
+	})
+	form.AddButton("Revoke", func() {
+		a.renderAsync(form, "Revoking delegation", func() (tview.Primitive, error) {
+			_, wait, err := a.revokeDelegation(context.Background(), votingUID)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := wait(context.Background()); err != nil {
+				return nil, err
+			}
+			return a.newMessage(previous, "Delegation revoked"), nil
+		})
+	})
+	form.AddButton("Cancel", func() {
+		a.render(previous)
+	})
+	form.SetBorder(true).SetTitle(" Delegate vote ").SetTitleAlign(tview.AlignLeft)
+	return form
+}
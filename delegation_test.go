@@ -0,0 +1,194 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"resenje.org/eas"
+)
+
+func TestResolveDelegations(t *testing.T) {
+	a := common.HexToAddress("0x0a")
+	b := common.HexToAddress("0x0b")
+	c := common.HexToAddress("0x0c")
+	d := common.HexToAddress("0x0d")
+	e := common.HexToAddress("0x0e")
+
+	for _, tc := range []struct {
+		name        string
+		delegations map[common.Address]common.Address
+		voters      []common.Address
+		credited    map[common.Address]common.Address
+		cycles      [][]common.Address
+	}{
+		{
+			name:        "direct",
+			delegations: map[common.Address]common.Address{a: b},
+			voters:      []common.Address{b},
+			credited:    map[common.Address]common.Address{a: b},
+		},
+		{
+			name:        "transitive",
+			delegations: map[common.Address]common.Address{a: b, b: c},
+			voters:      []common.Address{c},
+			credited:    map[common.Address]common.Address{a: c, b: c},
+		},
+		{
+			name:        "chain stops at the first voter",
+			delegations: map[common.Address]common.Address{a: b, b: c},
+			voters:      []common.Address{b, c},
+			credited:    map[common.Address]common.Address{a: b},
+		},
+		{
+			name:        "ballot overrides delegation",
+			delegations: map[common.Address]common.Address{a: b},
+			voters:      []common.Address{a, b},
+			credited:    map[common.Address]common.Address{},
+		},
+		{
+			name:        "delegate did not vote",
+			delegations: map[common.Address]common.Address{a: b},
+			credited:    map[common.Address]common.Address{},
+		},
+		{
+			name:        "cycle",
+			delegations: map[common.Address]common.Address{c: a, a: b, b: c, d: a},
+			voters:      []common.Address{e},
+			credited:    map[common.Address]common.Address{},
+			cycles:      [][]common.Address{{a, b, c}},
+		},
+		{
+			name:        "cycle with a voter",
+			delegations: map[common.Address]common.Address{a: b, b: c, c: a, d: a},
+			voters:      []common.Address{c},
+			credited:    map[common.Address]common.Address{a: c, b: c, d: c},
+		},
+		{
+			name:        "multiple cycles",
+			delegations: map[common.Address]common.Address{e: d, d: e, b: a, a: b, c: c},
+			credited:    map[common.Address]common.Address{},
+			cycles:      [][]common.Address{{a, b}, {c}, {d, e}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			voters := make(map[common.Address]struct{})
+			for _, v := range tc.voters {
+				voters[v] = struct{}{}
+			}
+			credited, cycles := resolveDelegations(tc.delegations, voters)
+			assertEqual(t, "credited", credited, tc.credited)
+			assertEqual(t, "cycles", cycles, tc.cycles)
+		})
+	}
+}
+
+func TestActiveDelegations(t *testing.T) {
+	votingUID := eas.HexDecodeUID("0x01")
+	configUID := eas.HexDecodeUID("0x02")
+	otherUID := eas.HexDecodeUID("0x03")
+
+	delegator := common.HexToAddress("0x0a")
+	b := common.HexToAddress("0x0b")
+	c := common.HexToAddress("0x0c")
+
+	start := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	record := func(delegate common.Address, refUID eas.UID, block uint64, revoked time.Time) delegationRecord {
+		return delegationRecord{
+			Delegator:      delegator,
+			Delegate:       delegate,
+			RefUID:         refUID,
+			Time:           start.Add(time.Duration(block) * time.Minute),
+			Block:          block,
+			RevocationTime: revoked,
+		}
+	}
+
+	for _, tc := range []struct {
+		name       string
+		records    []delegationRecord
+		end        time.Time
+		untilBlock uint64
+		want       map[common.Address]common.Address
+	}{
+		{
+			name:    "configuration",
+			records: []delegationRecord{record(b, configUID, 1, time.Time{})},
+			want:    map[common.Address]common.Address{delegator: b},
+		},
+		{
+			name:    "other voting",
+			records: []delegationRecord{record(b, otherUID, 1, time.Time{})},
+			want:    map[common.Address]common.Address{},
+		},
+		{
+			name: "voting takes precedence",
+			records: []delegationRecord{
+				record(c, votingUID, 1, time.Time{}),
+				record(b, configUID, 2, time.Time{}),
+			},
+			want: map[common.Address]common.Address{delegator: c},
+		},
+		{
+			name: "latest delegation",
+			records: []delegationRecord{
+				record(b, configUID, 1, time.Time{}),
+				record(c, configUID, 2, time.Time{}),
+			},
+			want: map[common.Address]common.Address{delegator: c},
+		},
+		{
+			name: "revoked latest delegation",
+			records: []delegationRecord{
+				record(b, configUID, 1, time.Time{}),
+				record(c, configUID, 2, end.Add(-time.Minute)),
+			},
+			end:  end,
+			want: map[common.Address]common.Address{},
+		},
+		{
+			name:    "revoked after the end",
+			records: []delegationRecord{record(b, configUID, 1, end)},
+			end:     end,
+			want:    map[common.Address]common.Address{delegator: b},
+		},
+		{
+			name: "revoked delegation in the voting",
+			records: []delegationRecord{
+				record(b, configUID, 1, time.Time{}),
+				record(c, votingUID, 2, start.Add(3*time.Minute)),
+			},
+			want: map[common.Address]common.Address{delegator: b},
+		},
+		{
+			name: "attested after the end",
+			records: []delegationRecord{
+				record(b, configUID, 1, time.Time{}),
+				record(c, configUID, 60, time.Time{}),
+			},
+			end:  end,
+			want: map[common.Address]common.Address{delegator: b},
+		},
+		{
+			name: "attested after the until block",
+			records: []delegationRecord{
+				record(b, configUID, 1, time.Time{}),
+				record(c, configUID, 3, time.Time{}),
+			},
+			untilBlock: 2,
+			want:       map[common.Address]common.Address{delegator: b},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := activeDelegations(tc.records, votingUID, configUID, tc.end, tc.untilBlock)
+			assertEqual(t, "delegations", got, tc.want)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"resenje.org/eas"
+)
+
+// easDeployment is an official deployment of EAS contracts on a chain.
+type easDeployment struct {
+	EAS            common.Address
+	SchemaRegistry common.Address
+}
+
+// easDeployments are official EAS deployments by the chain id.
+var easDeployments = map[uint64]easDeployment{
+	// Ethereum
+	1: {
+		EAS:            common.HexToAddress("0xA1207F3BBa224E2c9c3c6D5aF63D0eb1582Ce587"),
+		SchemaRegistry: common.HexToAddress("0xA7b39296258348C78294F95B872b282326A97BDF"),
+	},
+	// Optimism
+	10: {
+		EAS:            common.HexToAddress("0x4200000000000000000000000000000000000021"),
+		SchemaRegistry: common.HexToAddress("0x4200000000000000000000000000000000000020"),
+	},
+	// Base
+	8453: {
+		EAS:            common.HexToAddress("0x4200000000000000000000000000000000000021"),
+		SchemaRegistry: common.HexToAddress("0x4200000000000000000000000000000000000020"),
+	},
+	// Arbitrum One
+	42161: {
+		EAS:            common.HexToAddress("0xbD75f629A22Dc1ceD33dDA0b68c546A1c035c458"),
+		SchemaRegistry: common.HexToAddress("0xA310da9c5B885E7fb3fbA9D66E9Ba6Df512b78eB"),
+	},
+	// Base Sepolia
+	84532: {
+		EAS:            common.HexToAddress("0x4200000000000000000000000000000000000021"),
+		SchemaRegistry: common.HexToAddress("0x4200000000000000000000000000000000000020"),
+	},
+	// Sepolia
+	11155111: {
+		EAS:            common.HexToAddress("0xC2679fBD37d54388Ce493F1DB75320D236e1815e"),
+		SchemaRegistry: common.HexToAddress("0x0a7E2Ff54e76B8E6659aedc9103FB21c038050D0"),
+	},
+	// Optimism Sepolia
+	11155420: {
+		EAS:            common.HexToAddress("0x4200000000000000000000000000000000000021"),
+		SchemaRegistry: common.HexToAddress("0x4200000000000000000000000000000000000020"),
+	},
+}
+
+// connectEAS returns the EAS client of the key that is connected to the
+// endpoint, or to the backend if it is set, and the address of its EAS
+// contract. The chain of the endpoint must have the chain id if it is not
+// zero. The EAS contract address of the official deployment on the chain is
+// used if the address is not set, and the contract code must exist at the
+// address.
+func connectEAS(ctx context.Context, backend eas.Backend, endpoint string, chainID uint64, easContractAddress common.Address, pk *ecdsa.PrivateKey) (*eas.Client, common.Address, error) {
+	if backend == nil {
+		b, err := ethclient.DialContext(ctx, endpoint)
+		if err != nil {
+			return nil, common.Address{}, fmt.Errorf("connect to %s: %w", endpoint, err)
+		}
+		backend = b
+	}
+	id, err := backend.ChainID(ctx)
+	if err != nil {
+		return nil, common.Address{}, fmt.Errorf("chain id: %w", err)
+	}
+	if chainID != 0 && (!id.IsUint64() || id.Uint64() != chainID) {
+		return nil, common.Address{}, fmt.Errorf("rpc endpoint %s is on chain %v instead of chain %v", endpoint, id, chainID)
+	}
+
+	options := &eas.Options{
+		Backend: backend,
+	}
+	deployment, ok := easDeployments[id.Uint64()]
+	if easContractAddress == (common.Address{}) {
+		if !ok {
+			return nil, common.Address{}, fmt.Errorf("no known eas deployment on chain %v, eas contract address is required", id)
+		}
+		easContractAddress = deployment.EAS
+	}
+	if ok && deployment.EAS == easContractAddress {
+		options.SchemaRegistryContractAddress = deployment.SchemaRegistry
+	}
+
+	code, err := backend.CodeAt(ctx, easContractAddress, nil)
+	if err != nil {
+		return nil, common.Address{}, fmt.Errorf("eas contract code: %w", err)
+	}
+	if len(code) == 0 {
+		return nil, common.Address{}, fmt.Errorf("no eas contract at %s on chain %v", easContractAddress, id)
+	}
+
+	client, err := eas.NewClient(ctx, endpoint, pk, easContractAddress, options)
+	if err != nil {
+		return nil, common.Address{}, err
+	}
+	return client, easContractAddress, nil
+}
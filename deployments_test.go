@@ -0,0 +1,54 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestConnectEAS(t *testing.T) {
+	c := newTestChain(t, 1)
+
+	ctx := context.Background()
+
+	id, err := c.backend.ChainID(ctx)
+	assertNilError(t, err)
+	chainID := id.Uint64()
+
+	if _, _, err := connectEAS(ctx, c.backend, "", chainID+1, c.easAddress, c.accounts[0]); err == nil {
+		t.Error("connected to the endpoint on a different chain")
+	}
+	if _, _, err := connectEAS(ctx, c.backend, "", chainID, common.Address{}, c.accounts[0]); err == nil {
+		t.Error("connected without the eas contract address on a chain without a known deployment")
+	}
+	if _, _, err := connectEAS(ctx, c.backend, "", chainID, common.HexToAddress("0x0000000000000000000000000000000000001234"), c.accounts[0]); err == nil {
+		t.Error("connected to the address without the contract")
+	}
+
+	client, easContractAddress, err := connectEAS(ctx, c.backend, "", chainID, c.easAddress, c.accounts[0])
+	assertNilError(t, err)
+	assertEqual(t, "eas contract address", easContractAddress, c.easAddress)
+	if _, err := client.EAS.GetAttestation(ctx, c.configUID); err != nil {
+		t.Errorf("get config attestation: %v", err)
+	}
+
+	easDeployments[chainID] = easDeployment{EAS: c.easAddress}
+	t.Cleanup(func() { delete(easDeployments, chainID) })
+
+	_, easContractAddress, err = connectEAS(ctx, c.backend, "", 0, common.Address{}, c.accounts[0])
+	assertNilError(t, err)
+	assertEqual(t, "registry eas contract address", easContractAddress, c.easAddress)
+
+	a, err := newApp(t.TempDir(), "", common.Address{}, c.configUID)
+	assertNilError(t, err)
+	a.backend = c.backend
+	a.chainID = chainID
+	assertNilError(t, a.setClient(ctx, c.accounts[0]))
+	assertEqual(t, "app eas contract address", a.easContractAddress, c.easAddress)
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// eligibility checks if an address is eligible to vote in a voting by the
+// voting allowlist and the required membership.
+type eligibility struct {
+	// Nil if the voting does not have an allowlist.
+	allowlist     *allowlistTree
+	allowlistRoot common.Hash
+	// Nil if the voting does not require a membership.
+	memberships map[common.Address][]membershipRecord
+}
+
+func (a *app) getEligibility(ctx context.Context, v *voting) (*eligibility, error) {
+	allowlist, err := a.getAllowlist(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	e := &eligibility{
+		allowlist:     allowlist,
+		allowlistRoot: v.AllowlistRoot,
+	}
+	if !v.MembershipSchemaUID.IsZero() {
+		e.memberships, err = a.getMemberships(ctx, v.MembershipSchemaUID, v.MembershipSchemaBlock, v.MembershipIssuers)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// check returns an error if the address is not eligible to vote at the time.
+func (e *eligibility) check(address common.Address, t time.Time) error {
+	if e.allowlist != nil {
+		proof, ok := e.allowlist.proof(address)
+		if !ok || !verifyAllowlistProof(e.allowlistRoot, address, proof) {
+			return fmt.Errorf("account %s is not in the voting allowlist", address)
+		}
+	}
+	if e.memberships != nil {
+		var valid bool
+		for _, m := range e.memberships[address] {
+			if m.isValid(t) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("account %s does not have a valid membership", address)
+		}
+	}
+	return nil
+}
+
+// checkEligibility returns an error if the address is not eligible to vote in
+// the voting at the current chain time.
+func (a *app) checkEligibility(ctx context.Context, v *voting, address common.Address) error {
+	e, err := a.getEligibility(ctx, v)
+	if err != nil {
+		return err
+	}
+	now, err := a.chainTime(ctx)
+	if err != nil {
+		return err
+	}
+	return e.check(address, now)
+}
@@ -0,0 +1,584 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"resenje.org/eas"
+)
+
+// Encrypted ballots use ECIES on the secp256k1 curve. The ciphertext is the
+// compressed ephemeral public key R = r·G followed by the ballot plaintext
+// sealed with AES-256-GCM, with the key derived from the shared secret
+// S = r·P, where P is the tallier public key. The tallier computes the same
+// shared secret as S = p·R and publishes it with a proof of its correctness,
+// so that anyone can decrypt ballots without the tallier private key.
+
+const compressedPublicKeySize = 33
+
+// ballotEncryptionDomain separates keys of encrypted ballots from keys of
+// other ciphertexts that are encrypted with the same scheme.
+const ballotEncryptionDomain = "SchulzeOnEAS ballot encryption"
+
+// parseTallierPublicKey decodes the hex encoded compressed public key with an
+// optional 0x prefix.
+func parseTallierPublicKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid tallier public key: %w", err)
+	}
+	if _, err := crypto.DecompressPubkey(key); err != nil {
+		return nil, fmt.Errorf("invalid tallier public key: %w", err)
+	}
+	return key, nil
+}
+
+// ballotPlaintext returns the voting UID, the attester address and choice
+// indexes and ranks of the ballot as big endian uint16 values. The voting and
+// the attester are included so that the ciphertext can not be copied by
+// another voter or to another voting.
+func ballotPlaintext(votingUID eas.UID, attester common.Address, ballot ballotSchema) []byte {
+	data := make([]byte, 0, len(votingUID)+len(attester)+4*len(ballot))
+	data = append(data, votingUID[:]...)
+	data = append(data, attester[:]...)
+	for _, r := range ballot {
+		data = binary.BigEndian.AppendUint16(data, r.ChoiceIndex)
+		data = binary.BigEndian.AppendUint16(data, r.Rank)
+	}
+	return data
+}
+
+// parseBallotPlaintext returns the ballot from the plaintext if it is for the
+// voting and the attester.
+func parseBallotPlaintext(data []byte, votingUID eas.UID, attester common.Address) (ballotSchema, error) {
+	prefix := len(votingUID) + len(attester)
+	if len(data) <= prefix || (len(data)-prefix)%4 != 0 {
+		return nil, errors.New("invalid ballot plaintext size")
+	}
+	if !bytes.Equal(data[:len(votingUID)], votingUID[:]) {
+		return nil, errors.New("ballot is for a different voting")
+	}
+	if !bytes.Equal(data[len(votingUID):prefix], attester[:]) {
+		return nil, errors.New("ballot is for a different attester")
+	}
+	var ballot ballotSchema
+	for i := prefix; i < len(data); i += 4 {
+		ballot = append(ballot, ballotRanking{
+			ChoiceIndex: binary.BigEndian.Uint16(data[i:]),
+			Rank:        binary.BigEndian.Uint16(data[i+2:]),
+		})
+	}
+	return ballot, nil
+}
+
+// encryptBallot encrypts the ballot of the attester in the voting to the
+// tallier public key with a new ephemeral key.
+func encryptBallot(tallier *ecdsa.PublicKey, votingUID eas.UID, attester common.Address, ballot ballotSchema) ([]byte, error) {
+	return sealECIES(tallier, ballotEncryptionDomain, ballotPlaintext(votingUID, attester, ballot))
+}
+
+// decryptBallot decrypts the ciphertext with the shared secret and returns
+// the ballot if it is for the voting and the attester.
+func decryptBallot(ciphertext []byte, shared *ecdsa.PublicKey, votingUID eas.UID, attester common.Address) (ballotSchema, error) {
+	plaintext, err := openECIES(ciphertext, shared, ballotEncryptionDomain)
+	if err != nil {
+		return nil, err
+	}
+	return parseBallotPlaintext(plaintext, votingUID, attester)
+}
+
+// sealECIES encrypts the plaintext to the public key with a new ephemeral
+// key and the cipher key derived for the domain.
+func sealECIES(public *ecdsa.PublicKey, domain string, plaintext []byte) ([]byte, error) {
+	ephemeral, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	r := crypto.CompressPubkey(&ephemeral.PublicKey)
+	aead, err := newECIESCipher(domain, scalarMult(public, ephemeral.D), r)
+	if err != nil {
+		return nil, err
+	}
+	// the key is derived from a new ephemeral key for every ciphertext, so
+	// the zero nonce is never reused with the same key
+	return aead.Seal(r, make([]byte, aead.NonceSize()), plaintext, r), nil
+}
+
+// openECIES decrypts the ciphertext with the shared secret.
+func openECIES(ciphertext []byte, shared *ecdsa.PublicKey, domain string) ([]byte, error) {
+	if len(ciphertext) < compressedPublicKeySize {
+		return nil, errors.New("ciphertext too short")
+	}
+	r := ciphertext[:compressedPublicKeySize]
+	aead, err := newECIESCipher(domain, shared, r)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), ciphertext[compressedPublicKeySize:], r)
+}
+
+// ephemeralPublicKey returns the ephemeral public key of the ciphertext.
+func ephemeralPublicKey(ciphertext []byte) (*ecdsa.PublicKey, error) {
+	if len(ciphertext) < compressedPublicKeySize {
+		return nil, errors.New("ciphertext too short")
+	}
+	return crypto.DecompressPubkey(ciphertext[:compressedPublicKeySize])
+}
+
+// newECIESCipher returns AES-256-GCM with the key that is the keccak256 hash
+// of the domain, the compressed shared secret and the compressed ephemeral
+// public key.
+func newECIESCipher(domain string, shared *ecdsa.PublicKey, ephemeral []byte) (cipher.AEAD, error) {
+	key := crypto.Keccak256([]byte(domain), crypto.CompressPubkey(shared), ephemeral)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// decryptionProof is a Chaum-Pedersen proof that the shared secret S of an
+// encrypted ballot and the tallier public key P have the same discrete
+// logarithm p with respect to the ephemeral public key R and the generator
+// G, that is S = p·R and P = p·G, without revealing the tallier private key.
+type decryptionProof struct {
+	// A = w·G for a random w.
+	A hexutil.Bytes `json:"a"`
+	// B = w·R.
+	B hexutil.Bytes `json:"b"`
+	// Z = w + c·p where c is the decryptionChallenge.
+	Z hexutil.Bytes `json:"z"`
+}
+
+func proveDecryption(key *ecdsa.PrivateKey, ephemeral, shared *ecdsa.PublicKey) (*decryptionProof, error) {
+	w, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	a := crypto.CompressPubkey(&w.PublicKey)
+	b := crypto.CompressPubkey(scalarMult(ephemeral, w.D))
+	c := decryptionChallenge(&key.PublicKey, ephemeral, shared, a, b)
+	z := new(big.Int).Mul(c, key.D)
+	z.Add(z, w.D)
+	z.Mod(z, crypto.S256().Params().N)
+	return &decryptionProof{
+		A: a,
+		B: b,
+		Z: z.FillBytes(make([]byte, 32)),
+	}, nil
+}
+
+// verify returns an error if the proof does not show that the shared secret
+// is the ephemeral public key multiplied by the private key of the tallier,
+// by checking that z·G = A + c·P and z·R = B + c·S.
+func (p *decryptionProof) verify(tallier, ephemeral, shared *ecdsa.PublicKey) error {
+	a, err := crypto.DecompressPubkey(p.A)
+	if err != nil {
+		return fmt.Errorf("decryption proof: %w", err)
+	}
+	b, err := crypto.DecompressPubkey(p.B)
+	if err != nil {
+		return fmt.Errorf("decryption proof: %w", err)
+	}
+	z := new(big.Int).SetBytes(p.Z)
+	if len(p.Z) != 32 || z.Sign() == 0 || z.Cmp(crypto.S256().Params().N) >= 0 {
+		return errors.New("decryption proof: invalid scalar")
+	}
+	c := decryptionChallenge(tallier, ephemeral, shared, p.A, p.B)
+	if !equalPoints(scalarBaseMult(z), addPoints(a, scalarMult(tallier, c))) {
+		return errors.New("decryption proof: tallier public key does not match")
+	}
+	if !equalPoints(scalarMult(ephemeral, z), addPoints(b, scalarMult(shared, c))) {
+		return errors.New("decryption proof: shared secret does not match")
+	}
+	return nil
+}
+
+// decryptionChallenge returns the Fiat-Shamir challenge of the decryption
+// proof as the keccak256 hash of the compressed points modulo the curve
+// order.
+func decryptionChallenge(tallier, ephemeral, shared *ecdsa.PublicKey, a, b []byte) *big.Int {
+	h := crypto.Keccak256(
+		[]byte("SchulzeOnEAS decryption proof"),
+		crypto.CompressPubkey(tallier),
+		crypto.CompressPubkey(ephemeral),
+		crypto.CompressPubkey(shared),
+		a,
+		b,
+	)
+	c := new(big.Int).SetBytes(h)
+	return c.Mod(c, crypto.S256().Params().N)
+}
+
+// Curve points are represented as public keys, where nil is the point at
+// infinity.
+
+func scalarMult(p *ecdsa.PublicKey, k *big.Int) *ecdsa.PublicKey {
+	k = new(big.Int).Mod(k, crypto.S256().Params().N)
+	if p == nil || k.Sign() == 0 {
+		return nil
+	}
+	x, y := crypto.S256().ScalarMult(p.X, p.Y, k.FillBytes(make([]byte, 32)))
+	return &ecdsa.PublicKey{Curve: crypto.S256(), X: x, Y: y}
+}
+
+func scalarBaseMult(k *big.Int) *ecdsa.PublicKey {
+	k = new(big.Int).Mod(k, crypto.S256().Params().N)
+	if k.Sign() == 0 {
+		return nil
+	}
+	x, y := crypto.S256().ScalarBaseMult(k.FillBytes(make([]byte, 32)))
+	return &ecdsa.PublicKey{Curve: crypto.S256(), X: x, Y: y}
+}
+
+// addPoints returns the sum of points. The curve addition does not handle
+// the point at infinity and equal points, so they are handled here.
+func addPoints(p, q *ecdsa.PublicKey) *ecdsa.PublicKey {
+	switch {
+	case p == nil:
+		return q
+	case q == nil:
+		return p
+	case p.X.Cmp(q.X) == 0:
+		if p.Y.Cmp(q.Y) != 0 {
+			return nil
+		}
+		x, y := crypto.S256().Double(p.X, p.Y)
+		return &ecdsa.PublicKey{Curve: crypto.S256(), X: x, Y: y}
+	}
+	x, y := crypto.S256().Add(p.X, p.Y, q.X, q.Y)
+	return &ecdsa.PublicKey{Curve: crypto.S256(), X: x, Y: y}
+}
+
+func equalPoints(p, q *ecdsa.PublicKey) bool {
+	if p == nil || q == nil {
+		return p == q
+	}
+	return p.X.Cmp(q.X) == 0 && p.Y.Cmp(q.Y) == 0
+}
+
+// decryptionTranscript is the result of the decryption of encrypted ballots
+// of the voting by the tallier. It contains the shared secret of every
+// ballot with the proof of its correctness, so that anyone can decrypt the
+// ballots and check that they are decrypted with the tallier private key.
+type decryptionTranscript struct {
+	VotingUID        eas.UID           `json:"voting"`
+	TallierPublicKey hexutil.Bytes     `json:"tallierPublicKey"`
+	Ballots          []decryptedBallot `json:"ballots"`
+	// Decryptions of sums of encrypted preferences of counted ballots in the
+	// order of pairwiseAggregate ciphertexts if the voting has homomorphic
+	// tallying, in which case ballots are not decrypted.
+	Aggregate []decryptedCiphertext `json:"aggregate,omitempty"`
+}
+
+// decryptedBallot is the decryption of an encrypted ballot in the
+// transcript.
+type decryptedBallot struct {
+	UID      eas.UID        `json:"uid"`
+	Attester common.Address `json:"attester"`
+	// Empty if the ciphertext does not start with a valid ephemeral public
+	// key.
+	SharedSecret hexutil.Bytes    `json:"sharedSecret,omitempty"`
+	Proof        *decryptionProof `json:"proof,omitempty"`
+	// Empty if the ballot could not be decrypted.
+	Ballot ballotSchema `json:"ballot,omitempty"`
+}
+
+// newDecryptedBallot decrypts the ciphertext of the ballot with the tallier
+// private key.
+func newDecryptedBallot(key *ecdsa.PrivateKey, votingUID eas.UID, r encryptedBallotRecord) (decryptedBallot, error) {
+	d := decryptedBallot{
+		UID:      r.UID,
+		Attester: r.Attester,
+	}
+	ephemeral, err := ephemeralPublicKey(r.Ciphertext)
+	if err != nil {
+		return d, nil
+	}
+	shared := scalarMult(ephemeral, key.D)
+	proof, err := proveDecryption(key, ephemeral, shared)
+	if err != nil {
+		return d, err
+	}
+	d.SharedSecret = crypto.CompressPubkey(shared)
+	d.Proof = proof
+	if ballot, err := decryptBallot(r.Ciphertext, shared, votingUID, r.Attester); err == nil {
+		d.Ballot = ballot
+	}
+	return d, nil
+}
+
+// verify checks the decryption of the ciphertext and returns the decrypted
+// ballot or nil if the ciphertext could not be decrypted. An error is
+// returned if the transcript does not correctly decrypt the ciphertext.
+func (d decryptedBallot) verify(tallier *ecdsa.PublicKey, votingUID eas.UID, ciphertext []byte) (ballotSchema, error) {
+	ephemeral, err := ephemeralPublicKey(ciphertext)
+	if err != nil {
+		if len(d.SharedSecret) > 0 || len(d.Ballot) > 0 {
+			return nil, fmt.Errorf("ballot %s does not have a valid ephemeral public key", d.UID)
+		}
+		return nil, nil
+	}
+	if d.Proof == nil {
+		return nil, fmt.Errorf("ballot %s does not have a decryption proof", d.UID)
+	}
+	shared, err := crypto.DecompressPubkey(d.SharedSecret)
+	if err != nil {
+		return nil, fmt.Errorf("ballot %s shared secret: %w", d.UID, err)
+	}
+	if err := d.Proof.verify(tallier, ephemeral, shared); err != nil {
+		return nil, fmt.Errorf("ballot %s: %w", d.UID, err)
+	}
+	ballot, err := decryptBallot(ciphertext, shared, votingUID, d.Attester)
+	if err != nil {
+		ballot = nil
+	}
+	if !slices.Equal(ballot, d.Ballot) {
+		return nil, fmt.Errorf("ballot %s does not match its decryption", d.UID)
+	}
+	return ballot, nil
+}
+
+func readDecryptionTranscript(filename string) (*decryptionTranscript, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var t decryptionTranscript
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", filename, err)
+	}
+	return &t, nil
+}
+
+func writeDecryptionTranscript(filename string, t *decryptionTranscript) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// attestEncryptedBallot attests the ballot encrypted to the tallier public
+// key of the voting.
+func (a *app) attestEncryptedBallot(ctx context.Context, v *voting, ballot ballotSchema) (*types.Transaction, eas.WaitTx[eas.EASAttested], error) {
+	tallier, err := crypto.DecompressPubkey(v.TallierPublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tallier public key: %w", err)
+	}
+	ciphertext, err := encryptBallot(tallier, v.UID, a.client.Address(), ballot)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a.client.EAS.Attest(ctx, a.config.schema(encryptedBallotSchemaName).UID, &eas.AttestOptions{
+		Recipient: votingRecipient(v.UID),
+		RefUID:    v.UID,
+		Revocable: true,
+	}, encryptedBallotSchema{
+		Ciphertext: ciphertext,
+	})
+}
+
+// encryptedBallotRecord is an encrypted ballot attestation with its
+// ciphertext that is empty if the attestation data could not be decoded.
+type encryptedBallotRecord struct {
+	ballotRecord
+	Ciphertext []byte
+}
+
+// getEncryptedBallots returns encrypted ballots of the voting in the order
+// in which they were attested.
+func (a *app) getEncryptedBallots(ctx context.Context, v *voting) ([]encryptedBallotRecord, error) {
+	currentBlock, err := a.client.Backend().(ethereum.BlockNumberReader).BlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+	attested, err := a.scanAttestations(ctx, a.config.schema(encryptedBallotSchemaName).Block, currentBlock, []common.Address{votingRecipient(v.UID)}, a.config.schema(encryptedBallotSchemaName).UID)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]encryptedBallotRecord, 0, len(attested))
+	for _, b := range attested {
+		if b.attestation.RefUID != v.UID {
+			continue
+		}
+		r := encryptedBallotRecord{
+			ballotRecord: ballotRecord{
+				UID:            b.attestation.UID,
+				VotingUID:      b.attestation.RefUID,
+				Recipient:      b.event.Recipient,
+				Attester:       b.event.Attester,
+				Time:           b.attestation.Time,
+				Block:          b.event.Raw.BlockNumber,
+				LogIndex:       b.event.Raw.Index,
+				RevocationTime: attestationTime(b.attestation.RevocationTime),
+			},
+		}
+		var s encryptedBallotSchema
+		if err := b.attestation.ScanValues(&s); err == nil {
+			r.Ciphertext = s.Ciphertext
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// decryptTally decrypts encrypted ballots of the voting that were attested
+// before the voting end time, or only the aggregate of counted ballots if the
+// voting has homomorphic tallying, with the tallier private key and returns
+// the transcript of the decryption.
+func (a *app) decryptTally(ctx context.Context, v *voting, key *ecdsa.PrivateKey) (*decryptionTranscript, error) {
+	if !v.isEncrypted() {
+		return nil, fmt.Errorf("voting %s does not have encrypted ballots", v.UID)
+	}
+	if !bytes.Equal(crypto.CompressPubkey(&key.PublicKey), v.TallierPublicKey) {
+		return nil, fmt.Errorf("account is not the tallier of voting %s", v.UID)
+	}
+	// no more ballots can be attested in the voting time window once the
+	// latest block is at or after the voting end time
+	now, err := a.chainTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if now.Before(v.End) {
+		return nil, fmt.Errorf("ballots can be decrypted after %s", formatTime(v.End))
+	}
+
+	if v.HomomorphicTally {
+		c, err := a.countBallots(ctx, v.UID, tallyOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return decryptAggregate(key, v, c.Aggregate)
+	}
+
+	ballots, err := a.getEncryptedBallots(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	t := &decryptionTranscript{
+		VotingUID:        v.UID,
+		TallierPublicKey: v.TallierPublicKey,
+		Ballots:          make([]decryptedBallot, 0, len(ballots)),
+	}
+	for _, b := range ballots {
+		if !b.Time.Before(v.End) {
+			continue
+		}
+		d, err := newDecryptedBallot(key, v.UID, b)
+		if err != nil {
+			return nil, err
+		}
+		t.Ballots = append(t.Ballots, d)
+	}
+	return t, nil
+}
+
+// getEncryptedBallotRecords returns ballot records of encrypted ballots in
+// the voting with ballots decrypted by verifying the transcript or, without
+// the transcript, by combining partial decryptions of trustees. Every ballot
+// attested before the voting end time must be decrypted.
+func (a *app) getEncryptedBallotRecords(ctx context.Context, v *voting, t *decryptionTranscript) ([]ballotRecord, error) {
+	ballots, err := a.getEncryptedBallots(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	var decrypted map[eas.UID]ballotSchema
+	if t != nil {
+		decrypted, err = verifyDecryptionTranscript(v, t, ballots)
+	} else {
+		decrypted, err = a.decryptWithPartialDecryptions(ctx, v, ballots)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]ballotRecord, 0, len(ballots))
+	for _, b := range ballots {
+		r := b.ballotRecord
+		ballot, ok := decrypted[r.UID]
+		switch {
+		case ok:
+			r.Ballot = ballot
+			r.Undecryptable = ballot == nil
+		case r.Time.Before(v.End):
+			return nil, fmt.Errorf("ballot %s is not decrypted", r.UID)
+		default:
+			// ballots attested after the voting end time are not counted
+			// and they are not decrypted
+			r.Undecryptable = true
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// verifyDecryptionTranscript returns ballots decrypted in the transcript, or
+// nil ballots if they could not be decrypted. Every ballot attested before
+// the voting end time must be in the transcript and the transcript must not
+// have ballots that are not in the voting.
+func verifyDecryptionTranscript(v *voting, t *decryptionTranscript, ballots []encryptedBallotRecord) (map[eas.UID]ballotSchema, error) {
+	if t.VotingUID != v.UID {
+		return nil, fmt.Errorf("decryption transcript is for voting %s", t.VotingUID)
+	}
+	if !bytes.Equal(t.TallierPublicKey, v.TallierPublicKey) {
+		return nil, errors.New("decryption transcript has a different tallier public key")
+	}
+	tallier, err := crypto.DecompressPubkey(v.TallierPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("tallier public key: %w", err)
+	}
+	transcript := make(map[eas.UID]decryptedBallot, len(t.Ballots))
+	for _, d := range t.Ballots {
+		if _, ok := transcript[d.UID]; ok {
+			return nil, fmt.Errorf("decryption transcript has ballot %s more than once", d.UID)
+		}
+		transcript[d.UID] = d
+	}
+
+	decrypted := make(map[eas.UID]ballotSchema, len(t.Ballots))
+	for _, b := range ballots {
+		d, ok := transcript[b.UID]
+		delete(transcript, b.UID)
+		if !ok {
+			if b.Time.Before(v.End) {
+				return nil, fmt.Errorf("decryption transcript does not have ballot %s", b.UID)
+			}
+			continue
+		}
+		if d.Attester != b.Attester {
+			return nil, fmt.Errorf("decryption transcript has a different attester of ballot %s", b.UID)
+		}
+		ballot, err := d.verify(tallier, v.UID, b.Ciphertext)
+		if err != nil {
+			return nil, fmt.Errorf("decryption transcript: %w", err)
+		}
+		decrypted[b.UID] = ballot
+	}
+	if len(transcript) > 0 {
+		return nil, fmt.Errorf("decryption transcript has %v ballots that are not in the voting", len(transcript))
+	}
+	return decrypted, nil
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"resenje.org/eas"
+)
+
+func TestEncryptBallot(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assertNilError(t, err)
+	votingUID := eas.HexDecodeUID("0x01")
+	attester := common.HexToAddress("0x0a")
+	ballot := ballotSchema{{ChoiceIndex: 0, Rank: 2}, {ChoiceIndex: 1, Rank: 1}}
+
+	ciphertext, err := encryptBallot(&key.PublicKey, votingUID, attester, ballot)
+	assertNilError(t, err)
+
+	ephemeral, err := ephemeralPublicKey(ciphertext)
+	assertNilError(t, err)
+	shared := scalarMult(ephemeral, key.D)
+
+	decrypted, err := decryptBallot(ciphertext, shared, votingUID, attester)
+	assertNilError(t, err)
+	assertEqual(t, "ballot", decrypted, ballot)
+
+	if _, err := decryptBallot(ciphertext, shared, eas.HexDecodeUID("0x02"), attester); err == nil {
+		t.Error("decrypted the ballot for another voting")
+	}
+	if _, err := decryptBallot(ciphertext, shared, votingUID, common.HexToAddress("0x0b")); err == nil {
+		t.Error("decrypted the ballot for another attester")
+	}
+	other, err := crypto.GenerateKey()
+	assertNilError(t, err)
+	if _, err := decryptBallot(ciphertext, scalarMult(ephemeral, other.D), votingUID, attester); err == nil {
+		t.Error("decrypted the ballot with another key")
+	}
+}
+
+func TestDecryptedBallot_verify(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assertNilError(t, err)
+	votingUID := eas.HexDecodeUID("0x01")
+	attester := common.HexToAddress("0x0a")
+	ballot := ballotSchema{{ChoiceIndex: 0, Rank: 1}, {ChoiceIndex: 1, Rank: 2}}
+
+	ciphertext, err := encryptBallot(&key.PublicKey, votingUID, attester, ballot)
+	assertNilError(t, err)
+
+	newRecord := func(attester common.Address, ciphertext []byte) encryptedBallotRecord {
+		return encryptedBallotRecord{
+			ballotRecord: ballotRecord{
+				UID:      eas.HexDecodeUID("0x0100"),
+				Attester: attester,
+			},
+			Ciphertext: ciphertext,
+		}
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		d, err := newDecryptedBallot(key, votingUID, newRecord(attester, ciphertext))
+		assertNilError(t, err)
+		assertEqual(t, "ballot", d.Ballot, ballot)
+
+		verified, err := d.verify(&key.PublicKey, votingUID, ciphertext)
+		assertNilError(t, err)
+		assertEqual(t, "verified ballot", verified, ballot)
+	})
+
+	t.Run("copied ciphertext", func(t *testing.T) {
+		d, err := newDecryptedBallot(key, votingUID, newRecord(common.HexToAddress("0x0b"), ciphertext))
+		assertNilError(t, err)
+		assertEqual(t, "ballot", len(d.Ballot), 0)
+
+		verified, err := d.verify(&key.PublicKey, votingUID, ciphertext)
+		assertNilError(t, err)
+		assertEqual(t, "verified ballot", len(verified), 0)
+	})
+
+	t.Run("invalid ciphertext", func(t *testing.T) {
+		d, err := newDecryptedBallot(key, votingUID, newRecord(attester, []byte{1, 2, 3}))
+		assertNilError(t, err)
+		assertEqual(t, "proof", d.Proof, (*decryptionProof)(nil))
+
+		verified, err := d.verify(&key.PublicKey, votingUID, []byte{1, 2, 3})
+		assertNilError(t, err)
+		assertEqual(t, "verified ballot", len(verified), 0)
+	})
+
+	t.Run("other tallier", func(t *testing.T) {
+		other, err := crypto.GenerateKey()
+		assertNilError(t, err)
+		d, err := newDecryptedBallot(other, votingUID, newRecord(attester, ciphertext))
+		assertNilError(t, err)
+
+		if _, err := d.verify(&key.PublicKey, votingUID, ciphertext); err == nil {
+			t.Error("verified the decryption with another key")
+		}
+	})
+
+	t.Run("forged ballot", func(t *testing.T) {
+		d, err := newDecryptedBallot(key, votingUID, newRecord(attester, ciphertext))
+		assertNilError(t, err)
+		d.Ballot = ballotSchema{{ChoiceIndex: 0, Rank: 2}, {ChoiceIndex: 1, Rank: 1}}
+
+		if _, err := d.verify(&key.PublicKey, votingUID, ciphertext); err == nil {
+			t.Error("verified the forged ballot")
+		}
+	})
+
+	t.Run("withheld decryption", func(t *testing.T) {
+		d, err := newDecryptedBallot(key, votingUID, newRecord(attester, ciphertext))
+		assertNilError(t, err)
+		d.Ballot = nil
+
+		if _, err := d.verify(&key.PublicKey, votingUID, ciphertext); err == nil {
+			t.Error("verified the withheld decryption")
+		}
+	})
+}
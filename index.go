@@ -0,0 +1,147 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/common"
+	"resenje.org/eas"
+)
+
+// indexVersion is incremented when the index format changes, so that the
+// index in the old format is discarded and rebuilt.
+const indexVersion = 3
+
+// indexConfirmations is the number of the latest blocks that are not stored
+// in the index as they may be reorganized.
+const indexConfirmations = 64
+
+// attestationIndex is a local cache of decoded voting and ballot attestations
+// that is stored as a file in the configuration directory, so that only new
+// blocks are scanned when results are calculated.
+type attestationIndex struct {
+	path string
+
+	Version int `json:"version"`
+	// Blocks up to which ballot attestations and revocations are indexed for
+	// every ballot recipient address.
+	Blocks  map[common.Address]uint64 `json:"blocks"`
+	Votings map[eas.UID]*voting       `json:"votings"`
+	Ballots []ballotRecord            `json:"ballots"`
+	// Addresses of voting allowlists by their Merkle roots.
+	Allowlists map[common.Hash][]common.Address `json:"allowlists"`
+	// Blocks in which schemas were registered by their UIDs.
+	SchemaBlocks map[eas.UID]uint64 `json:"schemaBlocks"`
+	// Memberships by their schema UIDs and issuers.
+	Memberships map[eas.UID]map[common.Address]*issuerMemberships `json:"memberships"`
+}
+
+func newAttestationIndex(path string) *attestationIndex {
+	return &attestationIndex{
+		path:    path,
+		Version: indexVersion,
+		Blocks:  make(map[common.Address]uint64),
+		Votings: make(map[eas.UID]*voting),
+
+		Allowlists:   make(map[common.Hash][]common.Address),
+		SchemaBlocks: make(map[eas.UID]uint64),
+		Memberships:  make(map[eas.UID]map[common.Address]*issuerMemberships),
+	}
+}
+
+// openIndex loads the index for the chain, EAS contract and configuration
+// that the app is using.
+func (a *app) openIndex(ctx context.Context) (*attestationIndex, error) {
+	if a.index != nil {
+		return a.index, nil
+	}
+
+	path, err := a.chainDataPath(ctx, "index")
+	if err != nil {
+		return nil, err
+	}
+	index := newAttestationIndex(path)
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, index); err != nil {
+			return nil, fmt.Errorf("decode index %s: %w", path, err)
+		}
+		if index.Version != indexVersion {
+			index = newAttestationIndex(path)
+		}
+	}
+
+	a.index = index
+	return index, nil
+}
+
+// chainDataPath returns the path of the file in the data directory subdirectory
+// for the chain, EAS contract and configuration that the app is using.
+func (a *app) chainDataPath(ctx context.Context, dir string) (string, error) {
+	chainID, err := a.client.Backend().ChainID(ctx)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(a.dataDir, dir, fmt.Sprintf("%v-%s-%s.json", chainID, a.easContractAddress.Hex(), a.configUID)), nil
+}
+
+// updateIndex adds ballots with the recipient and their revocations from the
+// blocks after the last indexed block up to the block with enough
+// confirmations and saves the index.
+func (a *app) updateIndex(ctx context.Context, index *attestationIndex, recipient common.Address, currentBlock uint64) error {
+	if currentBlock < indexConfirmations {
+		return nil
+	}
+	end := currentBlock - indexConfirmations
+	start := max(index.Blocks[recipient]+1, a.config.BallotSchemaBlock)
+	if start > end {
+		return nil
+	}
+
+	ballots, revocations, err := a.scanBallots(ctx, start, end, []common.Address{recipient})
+	if err != nil {
+		return err
+	}
+
+	index.Ballots = append(index.Ballots, ballots...)
+	applyRevocations(index.Ballots, revocations)
+	index.Blocks[recipient] = end
+
+	return index.save()
+}
+
+func (i *attestationIndex) addVoting(v *voting) error {
+	i.Votings[v.UID] = v
+	return i.save()
+}
+
+// save writes the index to a temporary file that replaces the index file, so
+// that the index file is not left partially written.
+func (i *attestationIndex) save() error {
+	data, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(i.path), 0700); err != nil {
+		return err
+	}
+	tmp := i.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, i.path)
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"resenje.org/eas"
+)
+
+// keyCeremonyCommand prints the status of the key ceremony with dealings and
+// complaints of trustees and the tallier public key when it is final.
+func keyCeremonyCommand() error {
+	cli := flag.NewFlagSet("schulzeoneas key-ceremony", flag.ExitOnError)
+
+	options := newAppOptions(cli)
+	ceremonyFlag := cli.String("ceremony", "", "UID of the key ceremony")
+
+	if err := cli.Parse(os.Args[2:]); err != nil {
+		log.Println(err)
+		cli.Usage()
+	}
+
+	if *ceremonyFlag == "" {
+		return errors.New("key ceremony UID is required")
+	}
+	if !isHexUID(*ceremonyFlag) {
+		return fmt.Errorf("invalid key ceremony UID %q", *ceremonyFlag)
+	}
+
+	a, err := options.newApp()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	if err := a.setReadOnlyClient(ctx); err != nil {
+		return err
+	}
+
+	ceremony, err := a.getKeyCeremony(ctx, eas.HexDecodeUID(*ceremonyFlag))
+	if err != nil {
+		return err
+	}
+	s, err := a.getKeyCeremonyState(ctx, ceremony)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Key ceremony:", ceremony.UID)
+	fmt.Println("Threshold:", ceremony.Threshold, "of", len(ceremony.TrusteePublicKeys))
+	fmt.Println("Dealing end:", formatTime(ceremony.DealingEnd))
+	fmt.Println("Complaint end:", formatTime(ceremony.ComplaintEnd))
+	fmt.Println()
+	fmt.Println("Trustees:")
+	for i, k := range ceremony.TrusteePublicKeys {
+		index := i + 1
+		status := "no dealing"
+		if d, ok := s.Dealings[index]; ok {
+			status = "dealing " + d.UID.String()
+			if uid, ok := s.Complaints[index]; ok {
+				status += ", disqualified by complaint " + uid.String()
+			}
+		}
+		fmt.Printf("%v. %s %s\n", index, crypto.PubkeyToAddress(*k), status)
+	}
+	fmt.Println()
+	if !s.Final {
+		fmt.Println("Key is not final")
+		return nil
+	}
+	fmt.Println("Tallier public key:", hexutil.Encode(crypto.CompressPubkey(s.publicKey())))
+
+	return nil
+}
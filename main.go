@@ -20,6 +20,34 @@ func main() {
 	switch command {
 	case "register-schemas":
 		err = registerSchemasCommand()
+	case "create-voting":
+		err = createVotingCommand()
+	case "vote":
+		err = voteCommand()
+	case "reveal":
+		err = revealCommand()
+	case "delegate":
+		err = delegateCommand()
+	case "decrypt-tally":
+		err = decryptTallyCommand()
+	case "public-key":
+		err = publicKeyCommand()
+	case "create-key-ceremony":
+		err = createKeyCeremonyCommand()
+	case "deal-key-shares":
+		err = dealKeySharesCommand()
+	case "check-key-shares":
+		err = checkKeySharesCommand()
+	case "key-ceremony":
+		err = keyCeremonyCommand()
+	case "partial-decrypt":
+		err = partialDecryptCommand()
+	case "results":
+		err = resultsCommand()
+	case "collect-ballots":
+		err = collectBallotsCommand()
+	case "relay":
+		err = relayCommand()
 	default:
 		err = runApp()
 	}
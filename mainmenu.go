@@ -17,6 +17,12 @@ func (a *app) newMainMenu() tview.Primitive {
 	list.AddItem("Vote", "", 'v', func() {
 		a.render(a.newOpenBallotForm(list))
 	})
+	list.AddItem("Reveal secret ballots", a.pendingRevealsText(), 'e', func() {
+		a.render(a.newRevealBallotsList(list))
+	})
+	list.AddItem("Delegate vote", "", 'd', func() {
+		a.render(a.newDelegateForm(list))
+	})
 	list.AddItem("Read ballot", "", 'b', func() {
 		a.render(a.newOpenSubmittedBallotForm(list))
 	})
@@ -26,6 +32,9 @@ func (a *app) newMainMenu() tview.Primitive {
 	list.AddItem("Manage accounts", "", 'm', func() {
 		a.render(a.newManageAccountsMenu())
 	})
+	list.AddItem("Switch network", a.network, 'n', func() {
+		a.render(a.newSwitchNetworkMenu(list))
+	})
 	list.AddItem("About Schulze on EAS", "", 'a', func() {
 		a.render(a.newMessage(list,
 			"Schulze voting method on Ethereum Attestation Service\nVersion: "+version,
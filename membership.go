@@ -0,0 +1,288 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"resenje.org/eas"
+)
+
+// membershipRecord is a membership attestation issued to a voter.
+type membershipRecord struct {
+	UID       eas.UID        `json:"uid"`
+	Recipient common.Address `json:"recipient"`
+	Attester  common.Address `json:"attester"`
+	Time      time.Time      `json:"time"`
+	// Zero if the membership does not expire.
+	ExpirationTime time.Time `json:"expirationTime"`
+	// Zero if the membership is not revoked.
+	RevocationTime time.Time `json:"revocationTime"`
+}
+
+// isValid returns true if the membership was attested before or at the time
+// and it was not expired or revoked at that time.
+func (m membershipRecord) isValid(t time.Time) bool {
+	if m.Time.After(t) {
+		return false
+	}
+	if !m.ExpirationTime.IsZero() && !m.ExpirationTime.After(t) {
+		return false
+	}
+	if !m.RevocationTime.IsZero() && !m.RevocationTime.After(t) {
+		return false
+	}
+	return true
+}
+
+// issuerMemberships are membership attestations of one schema attested by
+// the issuer that are stored in the local index.
+type issuerMemberships struct {
+	// Block up to which memberships and their revocations are indexed.
+	Block   uint64             `json:"block"`
+	Records []membershipRecord `json:"records"`
+}
+
+// getMemberships returns attestations of the membership schema attested by
+// any of the issuers grouped by their recipients. Attestations are scanned
+// from the block in which the schema was registered and memberships from
+// blocks with enough confirmations are stored in the local index.
+func (a *app) getMemberships(ctx context.Context, schemaUID eas.UID, schemaBlock uint64, issuers []common.Address) (map[common.Address][]membershipRecord, error) {
+	index, err := a.openIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	currentBlock, err := a.client.Backend().(ethereum.BlockNumberReader).BlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.updateMembershipIndex(ctx, index, schemaUID, schemaBlock, issuers, currentBlock); err != nil {
+		return nil, err
+	}
+
+	start := schemaBlock
+	if currentBlock >= indexConfirmations {
+		start = max(start, currentBlock-indexConfirmations+1)
+	}
+	records, revocations, err := a.scanMemberships(ctx, schemaUID, issuers, start, currentBlock)
+	if err != nil {
+		return nil, err
+	}
+	for _, issuer := range issuers {
+		if m, ok := index.Memberships[schemaUID][issuer]; ok {
+			records = append(records, m.Records...)
+		}
+	}
+
+	memberships := make(map[common.Address][]membershipRecord)
+	seen := make(map[eas.UID]struct{})
+	for _, r := range records {
+		if _, ok := seen[r.UID]; ok {
+			continue
+		}
+		seen[r.UID] = struct{}{}
+		if t, ok := revocations[r.UID]; ok {
+			r.RevocationTime = t
+		}
+		memberships[r.Recipient] = append(memberships[r.Recipient], r)
+	}
+	return memberships, nil
+}
+
+// updateMembershipIndex adds memberships of the schema attested by the
+// issuers and their revocations from the blocks after the last indexed block
+// of every issuer up to the block with enough confirmations and saves the
+// index.
+func (a *app) updateMembershipIndex(ctx context.Context, index *attestationIndex, schemaUID eas.UID, schemaBlock uint64, issuers []common.Address, currentBlock uint64) error {
+	if currentBlock < indexConfirmations {
+		return nil
+	}
+	end := currentBlock - indexConfirmations
+
+	var updated bool
+	for _, issuer := range issuers {
+		m, ok := index.Memberships[schemaUID][issuer]
+		if !ok {
+			m = &issuerMemberships{}
+		}
+		start := schemaBlock
+		if ok {
+			start = max(start, m.Block+1)
+		}
+		if start > end {
+			continue
+		}
+
+		records, revocations, err := a.scanMemberships(ctx, schemaUID, []common.Address{issuer}, start, end)
+		if err != nil {
+			return err
+		}
+		for i, r := range m.Records {
+			if t, ok := revocations[r.UID]; ok {
+				m.Records[i].RevocationTime = t
+			}
+		}
+		m.Records = append(m.Records, records...)
+		m.Block = end
+
+		if index.Memberships[schemaUID] == nil {
+			index.Memberships[schemaUID] = make(map[common.Address]*issuerMemberships)
+		}
+		index.Memberships[schemaUID][issuer] = m
+		updated = true
+	}
+	if !updated {
+		return nil
+	}
+	return index.save()
+}
+
+// scanMemberships returns attestations of the membership schema attested by
+// any of the issuers in the block range and revocation times of memberships
+// that were revoked in the same range. Both start and end blocks are
+// inclusive.
+func (a *app) scanMemberships(ctx context.Context, schemaUID eas.UID, issuers []common.Address, start, end uint64) (memberships []membershipRecord, revocations map[eas.UID]time.Time, err error) {
+	var mu sync.Mutex
+	seen := make(map[eas.UID]struct{})
+	if err := a.scanner.scan(ctx, start, end, func(ctx context.Context, start, end uint64) (int, error) {
+		it, err := a.client.EAS.FilterAttested(ctx, start, &end, nil, issuers, []eas.UID{schemaUID})
+		if err != nil {
+			return 0, err
+		}
+		defer it.Close()
+
+		var uids []eas.UID
+		for it.Next() {
+			uids = append(uids, it.Value().UID)
+		}
+		if err := it.Error(); err != nil {
+			return 0, err
+		}
+
+		attestations, err := a.getAttestations(ctx, uids)
+		if err != nil {
+			return 0, err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, m := range attestations {
+			if _, ok := seen[m.UID]; ok {
+				continue
+			}
+			seen[m.UID] = struct{}{}
+			memberships = append(memberships, membershipRecord{
+				UID:            m.UID,
+				Recipient:      m.Recipient,
+				Attester:       m.Attester,
+				Time:           m.Time,
+				ExpirationTime: attestationTime(m.ExpirationTime),
+				RevocationTime: attestationTime(m.RevocationTime),
+			})
+		}
+		return len(attestations), nil
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	revocations = make(map[eas.UID]time.Time)
+	if err := a.scanner.scan(ctx, start, end, func(ctx context.Context, start, end uint64) (int, error) {
+		it, err := a.client.EAS.FilterRevoked(ctx, start, &end, nil, issuers, []eas.UID{schemaUID})
+		if err != nil {
+			return 0, err
+		}
+		defer it.Close()
+
+		var uids []eas.UID
+		for it.Next() {
+			uids = append(uids, it.Value().UID)
+		}
+		if err := it.Error(); err != nil {
+			return 0, err
+		}
+
+		attestations, err := a.getAttestations(ctx, uids)
+		if err != nil {
+			return 0, err
+		}
+
+		found := make(map[eas.UID]time.Time, len(attestations))
+		for _, m := range attestations {
+			found[m.UID] = m.RevocationTime
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		maps.Copy(revocations, found)
+		return len(found), nil
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	return memberships, revocations, nil
+}
+
+// attestationTime returns zero time for the unset attestation expiration or
+// revocation time that is decoded as the unix epoch.
+func attestationTime(t time.Time) time.Time {
+	if t.Unix() == 0 {
+		return time.Time{}
+	}
+	return t
+}
+
+// schemaBlock returns the block in which the schema was registered, by
+// scanning schema registrations from the first block. It is used only when a
+// voting is created without the block of its membership schema. Found blocks
+// are stored in the local index.
+func (a *app) schemaBlock(ctx context.Context, schemaUID eas.UID, currentBlock uint64) (uint64, error) {
+	index, err := a.openIndex(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if b, ok := index.SchemaBlocks[schemaUID]; ok {
+		return b, nil
+	}
+
+	var mu sync.Mutex
+	var block uint64
+	var found bool
+	if err := a.scanner.scan(ctx, 0, currentBlock, func(ctx context.Context, start, end uint64) (int, error) {
+		it, err := a.client.SchemaRegistry.FilterRegistered(ctx, start, &end, []eas.UID{schemaUID})
+		if err != nil {
+			return 0, err
+		}
+		defer it.Close()
+
+		var n int
+		for it.Next() {
+			b := it.Value().Raw.BlockNumber
+			mu.Lock()
+			if !found || b < block {
+				block = b
+				found = true
+			}
+			mu.Unlock()
+			n++
+		}
+		return n, it.Error()
+	}); err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, fmt.Errorf("schema %s is not registered", schemaUID)
+	}
+
+	index.SchemaBlocks[schemaUID] = block
+	return block, index.save()
+}
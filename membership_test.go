@@ -0,0 +1,157 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"resenje.org/eas"
+)
+
+func TestGetMemberships_index(t *testing.T) {
+	c := newTestChain(t, 3)
+
+	ctx := context.Background()
+
+	issuer := c.newApp(t, c.accounts[0])
+
+	_, wait, err := registerSchema(ctx, issuer.client, membershipTestSchema{})
+	assertNilError(t, err)
+	schemaUID, schemaBlock, err := wait(ctx)
+	assertNilError(t, err)
+
+	attestMembership := func(member *ecdsa.PrivateKey) eas.UID {
+		t.Helper()
+
+		_, wait, err := issuer.client.EAS.Attest(ctx, schemaUID, &eas.AttestOptions{
+			Recipient: crypto.PubkeyToAddress(member.PublicKey),
+			Revocable: true,
+		}, membershipTestSchema{Role: "member"})
+		assertNilError(t, err)
+		r, err := wait(ctx)
+		assertNilError(t, err)
+		return r.UID
+	}
+
+	first := attestMembership(c.accounts[1])
+	second := attestMembership(c.accounts[2])
+
+	v := createTestVoting(t, issuer, &voting{
+		Title:               "Board election",
+		Choices:             []string{"Alice", "Bob"},
+		MembershipSchemaUID: schemaUID,
+		MembershipIssuers:   []common.Address{issuer.client.Address()},
+	})
+	assertEqual(t, "membership schema block", v.MembershipSchemaBlock, schemaBlock)
+
+	c.commit(indexConfirmations)
+
+	dir := t.TempDir()
+	a := c.newAppWithDataDir(t, c.accounts[0], dir)
+	memberships, err := a.getMemberships(ctx, schemaUID, v.MembershipSchemaBlock, v.MembershipIssuers)
+	assertNilError(t, err)
+	assertEqual(t, "members", len(memberships), 2)
+	assertEqual(t, "indexed memberships", len(a.index.Memberships[schemaUID][issuer.client.Address()].Records), 2)
+
+	// revocation and a new membership after the indexed blocks
+	revokeTestAttestation(t, issuer, schemaUID, first)
+	third := attestMembership(c.accounts[0])
+
+	now, err := a.chainTime(ctx)
+	assertNilError(t, err)
+	check := func(a *app) {
+		t.Helper()
+
+		memberships, err := a.getMemberships(ctx, schemaUID, v.MembershipSchemaBlock, v.MembershipIssuers)
+		assertNilError(t, err)
+		for uid, want := range map[eas.UID]bool{first: false, second: true, third: true} {
+			var found bool
+			for _, records := range memberships {
+				for _, m := range records {
+					if m.UID == uid {
+						found = true
+						assertEqual(t, "valid "+uid.String(), m.isValid(now.Add(time.Minute)), want)
+					}
+				}
+			}
+			if !found {
+				t.Errorf("membership %s not found", uid)
+			}
+		}
+	}
+	check(a)
+
+	c.commit(indexConfirmations)
+
+	// memberships and the revocation are loaded from the index
+	check(c.newAppWithDataDir(t, c.accounts[0], dir))
+	check(a)
+	assertEqual(t, "indexed memberships", len(a.index.Memberships[schemaUID][issuer.client.Address()].Records), 3)
+}
+
+func TestMembershipRecord_isValid(t *testing.T) {
+	attested := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	later := attested.Add(time.Hour)
+
+	for _, tc := range []struct {
+		name       string
+		membership membershipRecord
+		time       time.Time
+		want       bool
+	}{
+		{
+			name:       "valid",
+			membership: membershipRecord{Time: attested},
+			time:       later,
+			want:       true,
+		},
+		{
+			name:       "at attestation time",
+			membership: membershipRecord{Time: attested},
+			time:       attested,
+			want:       true,
+		},
+		{
+			name:       "before attestation",
+			membership: membershipRecord{Time: later},
+			time:       attested,
+			want:       false,
+		},
+		{
+			name:       "expired",
+			membership: membershipRecord{Time: attested, ExpirationTime: later},
+			time:       later,
+			want:       false,
+		},
+		{
+			name:       "not yet expired",
+			membership: membershipRecord{Time: attested, ExpirationTime: later},
+			time:       later.Add(-time.Second),
+			want:       true,
+		},
+		{
+			name:       "revoked",
+			membership: membershipRecord{Time: attested, RevocationTime: later},
+			time:       later.Add(time.Second),
+			want:       false,
+		},
+		{
+			name:       "revoked later",
+			membership: membershipRecord{Time: attested, RevocationTime: later},
+			time:       attested.Add(time.Second),
+			want:       true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assertEqual(t, "valid", tc.membership.isValid(tc.time), tc.want)
+		})
+	}
+}
@@ -0,0 +1,353 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/rivo/tview"
+	"resenje.org/eas"
+)
+
+// defaultNetwork is the name of the network profile that is used if no
+// network is selected.
+const defaultNetwork = "sepolia"
+
+// networkProfile is a named set of the Ethereum RPC endpoint, the EAS
+// contract address and the config UID that are used together.
+type networkProfile struct {
+	Name     string `json:"name"`
+	Endpoint string `json:"endpoint"`
+	// Chain id that the endpoint must be on, not checked if it is zero.
+	ChainID uint64 `json:"chainId,omitempty"`
+	// Official EAS deployment on the chain is used if it is not set.
+	EASContractAddress common.Address `json:"easContractAddress"`
+	// Zero if there is no known config attestation on the network, in which
+	// case the user provides it.
+	ConfigUID eas.UID `json:"configUID"`
+}
+
+// builtinNetworkProfiles are network profiles with public RPC endpoints of
+// chains with official EAS deployments.
+var builtinNetworkProfiles = []networkProfile{
+	{
+		Name:     "mainnet",
+		Endpoint: "https://ethereum-rpc.publicnode.com/",
+		ChainID:  1,
+	},
+	{
+		Name:      "sepolia",
+		Endpoint:  "https://ethereum-sepolia-rpc.publicnode.com/",
+		ChainID:   11155111,
+		ConfigUID: eas.HexDecodeUID("0x95061c892e6fad7afc7dc9d625d39e652ba221efeb1afa5e1dd4266c11a145c8"),
+	},
+	{
+		Name:     "base",
+		Endpoint: "https://base-rpc.publicnode.com/",
+		ChainID:  8453,
+	},
+	{
+		Name:     "optimism",
+		Endpoint: "https://optimism-rpc.publicnode.com/",
+		ChainID:  10,
+	},
+	{
+		Name:     "arbitrum",
+		Endpoint: "https://arbitrum-one-rpc.publicnode.com/",
+		ChainID:  42161,
+	},
+	{
+		Name:     "devnet",
+		Endpoint: "http://localhost:8545/",
+	},
+}
+
+// networkProfilesFilename is the name of the file in the data directory with
+// network profiles added by the user.
+const networkProfilesFilename = "networks.json"
+
+// loadNetworkProfiles returns built-in network profiles and the ones that
+// the user added in the data directory, which replace built-in profiles with
+// the same name.
+func loadNetworkProfiles(dataDir string) ([]networkProfile, error) {
+	profiles := slices.Clone(builtinNetworkProfiles)
+	user, err := readUserNetworkProfiles(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range user {
+		if i := slices.IndexFunc(profiles, func(b networkProfile) bool { return b.Name == p.Name }); i >= 0 {
+			profiles[i] = p
+			continue
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+func readUserNetworkProfiles(dataDir string) ([]networkProfile, error) {
+	path := filepath.Join(dataDir, networkProfilesFilename)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var profiles []networkProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return profiles, nil
+}
+
+// saveNetworkProfile adds the profile to the user network profiles in the
+// data directory, replacing the profile with the same name.
+func saveNetworkProfile(dataDir string, p networkProfile) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	profiles, err := readUserNetworkProfiles(dataDir)
+	if err != nil {
+		return err
+	}
+	profiles = slices.DeleteFunc(profiles, func(u networkProfile) bool { return u.Name == p.Name })
+	profiles = append(profiles, p)
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dataDir, networkProfilesFilename), append(data, '\n'), 0600)
+}
+
+// findNetworkProfile returns the profile with the name.
+func findNetworkProfile(profiles []networkProfile, name string) (networkProfile, error) {
+	for _, p := range profiles {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	names := make([]string, 0, len(profiles))
+	for _, p := range profiles {
+		names = append(names, p.Name)
+	}
+	return networkProfile{}, fmt.Errorf("unknown network %q, available networks: %s", name, strings.Join(names, ", "))
+}
+
+// validate returns an error if the profile can not be used to connect to the
+// network. The config UID is not required, as it can be provided by the user.
+func (p networkProfile) validate() error {
+	if p.Name == "" {
+		return errors.New("network name is required")
+	}
+	if p.Endpoint == "" {
+		return fmt.Errorf("network %s has no rpc endpoint", p.Name)
+	}
+	return nil
+}
+
+// networkConnection is the client and the configuration of the network
+// profile that the app can switch to.
+type networkConnection struct {
+	profile            networkProfile
+	easContractAddress common.Address
+	client             *eas.Client
+	config             *configSchema
+}
+
+// connectNetwork connects the client of the account to the network of the
+// profile and loads its configuration. It does not change the app, so it can
+// be called outside of the UI goroutine.
+func (a *app) connectNetwork(ctx context.Context, p networkProfile) (*networkConnection, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	if p.ConfigUID.IsZero() {
+		return nil, fmt.Errorf("network %s has no config uid", p.Name)
+	}
+	client, easContractAddress, err := connectEAS(ctx, a.backend, p.Endpoint, p.ChainID, p.EASContractAddress, a.key)
+	if err != nil {
+		return nil, fmt.Errorf("network %s: %w", p.Name, err)
+	}
+	config, err := fetchConfiguration(ctx, client, p.ConfigUID)
+	if err != nil {
+		return nil, fmt.Errorf("network %s: %w", p.Name, err)
+	}
+	return &networkConnection{
+		profile:            p,
+		easContractAddress: easContractAddress,
+		client:             client,
+		config:             config,
+	}, nil
+}
+
+// setNetwork switches the app to the connected network.
+func (a *app) setNetwork(c *networkConnection) {
+	a.network = c.profile.Name
+	a.ethereumEndpoint = c.profile.Endpoint
+	a.chainID = c.profile.ChainID
+	a.easContractAddress = c.easContractAddress
+	a.configUID = c.profile.ConfigUID
+	a.client = c.client
+	a.config = c.config
+	// the index and ballot secrets are stored for every network separately
+	a.index = nil
+	a.secrets = nil
+}
+
+// switchNetwork connects to the network of the profile and switches the app
+// to it. The app is not changed if the configuration can not be loaded.
+func (a *app) switchNetwork(ctx context.Context, p networkProfile) error {
+	c, err := a.connectNetwork(ctx, p)
+	if err != nil {
+		return err
+	}
+	a.setNetwork(c)
+	return nil
+}
+
+// switchNetworkAsync connects to the network in the background and switches
+// the app to it on the UI goroutine, as the app state is read by the UI.
+func (a *app) switchNetworkAsync(previous tview.Primitive, p networkProfile) {
+	a.renderAsync(previous, "Connecting to "+p.Name, func() (tview.Primitive, error) {
+		c, err := a.connectNetwork(context.Background(), p)
+		if err != nil {
+			return nil, err
+		}
+		done := make(chan struct{})
+		a.QueueUpdate(func() {
+			a.setNetwork(c)
+			close(done)
+		})
+		<-done
+		return nil, nil
+	})
+}
+
+// newConfigUIDForm asks for the config UID of the network that does not have
+// a known config attestation. The app quits on cancel if there is no
+// previous primitive.
+func (a *app) newConfigUIDForm(previous tview.Primitive, network string, done func(configUID eas.UID)) tview.Primitive {
+	form := tview.NewForm()
+	var configUID string
+	form.AddInputField("Config UID", "", 67, nil, func(text string) {
+		configUID = strings.TrimSpace(text)
+	})
+	form.AddButton("Connect", func() {
+		if !isHexUID(configUID) {
+			modal := tview.NewModal()
+			modal.SetText("Error: invalid config UID")
+			modal.AddButtons([]string{"OK"}).SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+				a.render(form)
+			})
+			a.render(modal)
+			return
+		}
+		done(eas.HexDecodeUID(configUID))
+	})
+	form.AddButton("Cancel", func() {
+		if previous == nil {
+			a.Stop()
+			return
+		}
+		a.render(previous)
+	})
+	form.SetBorder(true).SetTitle(" Config of the " + network + " network ").SetTitleAlign(tview.AlignLeft)
+	return form
+}
+
+func (a *app) newSwitchNetworkMenu(previous tview.Primitive) tview.Primitive {
+	list := tview.NewList()
+	profiles, err := loadNetworkProfiles(a.dataDir)
+	if err != nil {
+		return a.newMessage(previous, "Error: "+err.Error())
+	}
+	for _, p := range profiles {
+		name := p.Name
+		if name == a.network {
+			name += " (current)"
+		}
+		list.AddItem(name, p.Endpoint, 0, func() {
+			if p.ConfigUID.IsZero() {
+				a.render(a.newConfigUIDForm(list, p.Name, func(configUID eas.UID) {
+					p.ConfigUID = configUID
+					a.switchNetworkAsync(list, p)
+				}))
+				return
+			}
+			a.switchNetworkAsync(list, p)
+		})
+	}
+	list.AddItem("Add network", "", '+', func() {
+		a.render(a.newAddNetworkForm(list))
+	})
+	list.AddItem("Cancel", "", 'c', func() {
+		a.render(previous)
+	})
+	list.SetBorder(true).SetTitle(" Switch network ").SetTitleAlign(tview.AlignLeft)
+	return list
+}
+
+func (a *app) newAddNetworkForm(previous tview.Primitive) tview.Primitive {
+	form := tview.NewForm()
+	var p networkProfile
+	form.AddInputField("Name", "", 20, nil, func(text string) {
+		p.Name = strings.TrimSpace(text)
+	})
+	form.AddInputField("RPC endpoint", "", 60, nil, func(text string) {
+		p.Endpoint = strings.TrimSpace(text)
+	})
+	form.AddInputField("Chain ID", "", 12, func(textToCheck string, lastChar rune) bool {
+		_, err := strconv.ParseUint(textToCheck, 10, 64)
+		return err == nil
+	}, func(text string) {
+		p.ChainID, _ = strconv.ParseUint(text, 10, 64)
+	})
+	var easContractAddress string
+	form.AddInputField("EAS contract address", "", 42, nil, func(text string) {
+		easContractAddress = strings.TrimSpace(text)
+	})
+	var configUID string
+	form.AddInputField("Config UID", "", 67, nil, func(text string) {
+		configUID = strings.TrimSpace(text)
+	})
+	form.AddButton("Save", func() {
+		if easContractAddress != "" {
+			if !common.IsHexAddress(easContractAddress) {
+				a.render(a.newMessage(form, "Error: invalid EAS contract address"))
+				return
+			}
+			p.EASContractAddress = common.HexToAddress(easContractAddress)
+		}
+		if configUID != "" {
+			if !isHexUID(configUID) {
+				a.render(a.newMessage(form, "Error: invalid config UID"))
+				return
+			}
+			p.ConfigUID = eas.HexDecodeUID(configUID)
+		}
+		if err := saveNetworkProfile(a.dataDir, p); err != nil {
+			a.render(a.newMessage(form, "Error: "+err.Error()))
+			return
+		}
+		a.render(a.newSwitchNetworkMenu(a.newMainMenu()))
+	})
+	form.AddButton("Cancel", func() {
+		a.render(previous)
+	})
+	form.SetBorder(true).SetTitle(" Add network ").SetTitleAlign(tview.AlignLeft)
+	return form
+}
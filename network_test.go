@@ -0,0 +1,103 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"resenje.org/eas"
+)
+
+func TestLoadNetworkProfiles(t *testing.T) {
+	dir := t.TempDir()
+
+	profiles, err := loadNetworkProfiles(dir)
+	assertNilError(t, err)
+	assertEqual(t, "profiles", profiles, builtinNetworkProfiles)
+
+	if err := saveNetworkProfile(dir, networkProfile{Name: "rehearsal"}); err == nil {
+		t.Error("saved a network profile without the rpc endpoint")
+	}
+	// the config uid is provided by the user when the network is selected
+	assertNilError(t, saveNetworkProfile(dir, networkProfile{Name: "holesky", Endpoint: "https://rpc.example.com/"}))
+
+	mainnet := networkProfile{
+		Name:      "mainnet",
+		Endpoint:  "https://rpc.example.com/",
+		ChainID:   1,
+		ConfigUID: eas.HexDecodeUID("0x01"),
+	}
+	rehearsal := networkProfile{
+		Name:               "rehearsal",
+		Endpoint:           "https://ethereum-sepolia-rpc.publicnode.com/",
+		EASContractAddress: common.HexToAddress("0xC2679fBD37d54388Ce493F1DB75320D236e1815e"),
+		ConfigUID:          eas.HexDecodeUID("0x02"),
+	}
+	assertNilError(t, saveNetworkProfile(dir, rehearsal))
+	assertNilError(t, saveNetworkProfile(dir, mainnet))
+	rehearsal.ConfigUID = eas.HexDecodeUID("0x03")
+	assertNilError(t, saveNetworkProfile(dir, rehearsal))
+
+	profiles, err = loadNetworkProfiles(dir)
+	assertNilError(t, err)
+	assertEqual(t, "profiles count", len(profiles), len(builtinNetworkProfiles)+2)
+
+	p, err := findNetworkProfile(profiles, "mainnet")
+	assertNilError(t, err)
+	assertEqual(t, "mainnet", p, mainnet)
+	p, err = findNetworkProfile(profiles, "rehearsal")
+	assertNilError(t, err)
+	assertEqual(t, "rehearsal", p, rehearsal)
+	if _, err := findNetworkProfile(profiles, "goerli"); err == nil {
+		t.Error("found an unknown network")
+	}
+}
+
+func TestSwitchNetwork(t *testing.T) {
+	c := newTestChain(t, 1)
+
+	ctx := context.Background()
+
+	a := c.newApp(t, c.accounts[0])
+
+	_, err := a.openIndex(ctx)
+	assertNilError(t, err)
+
+	if err := a.switchNetwork(ctx, networkProfile{
+		Name:               "mainnet",
+		Endpoint:           "http://localhost:8545/",
+		EASContractAddress: c.easAddress,
+	}); err == nil {
+		t.Error("switched to a network without the config uid")
+	}
+	if err := a.switchNetwork(ctx, networkProfile{
+		Name:               "other",
+		Endpoint:           "http://localhost:8545/",
+		EASContractAddress: c.easAddress,
+		ConfigUID:          eas.HexDecodeUID("0x01"),
+	}); err == nil {
+		t.Error("switched to a network without the config attestation")
+	}
+	assertEqual(t, "config uid", a.configUID, c.configUID)
+	if a.index == nil {
+		t.Error("index was closed after a failed switch")
+	}
+
+	assertNilError(t, a.switchNetwork(ctx, networkProfile{
+		Name:               "rehearsal",
+		Endpoint:           "http://localhost:8545/",
+		EASContractAddress: c.easAddress,
+		ConfigUID:          c.configUID,
+	}))
+	assertEqual(t, "network", a.network, "rehearsal")
+	assertEqual(t, "address", a.client.Address(), crypto.PubkeyToAddress(c.accounts[0].PublicKey))
+	if a.index != nil {
+		t.Error("index of the previous network is open")
+	}
+}
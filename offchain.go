@@ -0,0 +1,388 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"resenje.org/eas"
+)
+
+// offchainAttestationVersion is the version of EAS off-chain attestations
+// that have the version field in the signed message.
+const offchainAttestationVersion = 1
+
+// offchainAttestationTypes are EIP-712 types of the EAS off-chain attestation
+// message.
+var offchainAttestationTypes = apitypes.Types{
+	"Attest": {
+		{Name: "version", Type: "uint16"},
+		{Name: "schema", Type: "bytes32"},
+		{Name: "recipient", Type: "address"},
+		{Name: "time", Type: "uint64"},
+		{Name: "expirationTime", Type: "uint64"},
+		{Name: "revocable", Type: "bool"},
+		{Name: "refUID", Type: "bytes32"},
+		{Name: "data", Type: "bytes"},
+	},
+}
+
+// ballotArguments encode the ballot as the data of the ballot attestation.
+var ballotArguments = abi.Arguments{{Type: mustNewABIType("tuple[]", []abi.ArgumentMarshaling{
+	{Name: "choiceIndex", Type: "uint16"},
+	{Name: "rank", Type: "uint16"},
+})}}
+
+// offchainAttestation is a signed EAS off-chain attestation in the format in
+// which the EAS SDK shares them.
+type offchainAttestation struct {
+	Sig    offchainAttestationSig `json:"sig"`
+	Signer common.Address         `json:"signer"`
+}
+
+type offchainAttestationSig struct {
+	Domain      apitypes.TypedDataDomain   `json:"domain"`
+	PrimaryType string                     `json:"primaryType"`
+	Types       apitypes.Types             `json:"types"`
+	Message     offchainAttestationMessage `json:"message"`
+	UID         eas.UID                    `json:"uid"`
+	Version     uint16                     `json:"version"`
+	Signature   offchainSignature          `json:"signature"`
+}
+
+type offchainAttestationMessage struct {
+	Version        uint16         `json:"version"`
+	Schema         eas.UID        `json:"schema"`
+	Recipient      common.Address `json:"recipient"`
+	Time           uint64         `json:"time"`
+	ExpirationTime uint64         `json:"expirationTime"`
+	Revocable      bool           `json:"revocable"`
+	RefUID         eas.UID        `json:"refUID"`
+	Data           hexutil.Bytes  `json:"data"`
+}
+
+type offchainSignature struct {
+	R hexutil.Bytes `json:"r"`
+	S hexutil.Bytes `json:"s"`
+	V uint8         `json:"v"`
+}
+
+// typedData returns the EIP-712 typed data of the message in the domain.
+func (m offchainAttestationMessage) typedData(domain apitypes.TypedDataDomain) apitypes.TypedData {
+	return apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": {
+				{Name: "name", Type: "string"},
+				{Name: "version", Type: "string"},
+				{Name: "chainId", Type: "uint256"},
+				{Name: "verifyingContract", Type: "address"},
+			},
+			"Attest": offchainAttestationTypes["Attest"],
+		},
+		PrimaryType: "Attest",
+		Domain:      domain,
+		Message: apitypes.TypedDataMessage{
+			"version":        new(big.Int).SetUint64(uint64(m.Version)),
+			"schema":         [32]byte(m.Schema),
+			"recipient":      m.Recipient.Hex(),
+			"time":           new(big.Int).SetUint64(m.Time),
+			"expirationTime": new(big.Int).SetUint64(m.ExpirationTime),
+			"revocable":      m.Revocable,
+			"refUID":         [32]byte(m.RefUID),
+			"data":           []byte(m.Data),
+		},
+	}
+}
+
+// uid returns the UID of the off-chain attestation as it is computed by the
+// EAS SDK for the attestation version 1.
+func (m offchainAttestationMessage) uid() eas.UID {
+	var b bytes.Buffer
+	b.Write(binary.BigEndian.AppendUint16(nil, m.Version))
+	b.WriteString(m.Schema.String())
+	b.Write(m.Recipient.Bytes())
+	b.Write(common.Address{}.Bytes())
+	b.Write(binary.BigEndian.AppendUint64(nil, m.Time))
+	b.Write(binary.BigEndian.AppendUint64(nil, m.ExpirationTime))
+	if m.Revocable {
+		b.WriteByte(1)
+	} else {
+		b.WriteByte(0)
+	}
+	b.Write(m.RefUID[:])
+	b.Write(m.Data)
+	b.Write(make([]byte, 4))
+	return eas.UID(crypto.Keccak256Hash(b.Bytes()))
+}
+
+// offchainDomain returns the EIP-712 domain of off-chain attestations of the
+// EAS contract that the client is connected to.
+func (a *app) offchainDomain(ctx context.Context) (apitypes.TypedDataDomain, error) {
+	chainID, err := a.client.Backend().ChainID(ctx)
+	if err != nil {
+		return apitypes.TypedDataDomain{}, fmt.Errorf("chain id: %w", err)
+	}
+	version, err := a.client.EAS.Version(ctx)
+	if err != nil {
+		return apitypes.TypedDataDomain{}, fmt.Errorf("eas version: %w", err)
+	}
+	return apitypes.TypedDataDomain{
+		Name:              "EAS Attestation",
+		Version:           version,
+		ChainId:           (*math.HexOrDecimal256)(chainID),
+		VerifyingContract: a.easContractAddress.Hex(),
+	}, nil
+}
+
+// signOffchainBallot returns the ballot as an off-chain attestation of the
+// ballot schema signed with the key, which can be counted without a
+// transaction.
+func (a *app) signOffchainBallot(ctx context.Context, v *voting, ballot ballotSchema, key *ecdsa.PrivateKey) (*offchainAttestation, error) {
+	if err := v.checkOffchain(); err != nil {
+		return nil, err
+	}
+	now, err := a.chainTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.checkTime(now); err != nil {
+		return nil, err
+	}
+	signer := crypto.PubkeyToAddress(key.PublicKey)
+	if err := a.checkEligibility(ctx, v, signer); err != nil && !errors.Is(err, errAllowlistUnavailable) {
+		return nil, err
+	}
+	data, err := ballotArguments.Pack(ballot)
+	if err != nil {
+		return nil, err
+	}
+	domain, err := a.offchainDomain(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m := offchainAttestationMessage{
+		Version:   offchainAttestationVersion,
+		Schema:    a.config.BallotSchemaUID,
+		Recipient: votingRecipient(v.UID),
+		Time:      uint64(now.Unix()),
+		Revocable: true,
+		RefUID:    v.UID,
+		Data:      data,
+	}
+	return signOffchainAttestation(domain, m, key)
+}
+
+// signOffchainAttestation signs the message with the key as an off-chain
+// attestation of the domain.
+func signOffchainAttestation(domain apitypes.TypedDataDomain, m offchainAttestationMessage, key *ecdsa.PrivateKey) (*offchainAttestation, error) {
+	hash, _, err := apitypes.TypedDataAndHash(m.typedData(domain))
+	if err != nil {
+		return nil, err
+	}
+	sig, err := crypto.Sign(hash, key)
+	if err != nil {
+		return nil, err
+	}
+	return &offchainAttestation{
+		Sig: offchainAttestationSig{
+			Domain:      domain,
+			PrimaryType: "Attest",
+			Types:       offchainAttestationTypes,
+			Message:     m,
+			UID:         m.uid(),
+			Version:     offchainAttestationVersion,
+			Signature: offchainSignature{
+				R: sig[:32],
+				S: sig[32:64],
+				V: sig[64] + 27,
+			},
+		},
+		Signer: crypto.PubkeyToAddress(key.PublicKey),
+	}, nil
+}
+
+// verifyOffchainBallot checks that the off-chain attestation is a ballot of
+// the voting signed by its signer for the EAS contract that the client is
+// connected to, and returns it as a ballot record.
+func (a *app) verifyOffchainBallot(v *voting, domain apitypes.TypedDataDomain, o *offchainAttestation) (ballotRecord, error) {
+	s := o.Sig
+	m := s.Message
+	if s.Version != offchainAttestationVersion || m.Version != offchainAttestationVersion {
+		return ballotRecord{}, fmt.Errorf("unsupported off-chain attestation version %v", m.Version)
+	}
+	if s.PrimaryType != "Attest" {
+		return ballotRecord{}, fmt.Errorf("unsupported primary type %q", s.PrimaryType)
+	}
+	if s.Domain.Name != domain.Name || s.Domain.Version != domain.Version ||
+		s.Domain.ChainId == nil || (*big.Int)(s.Domain.ChainId).Cmp((*big.Int)(domain.ChainId)) != 0 ||
+		!strings.EqualFold(s.Domain.VerifyingContract, domain.VerifyingContract) {
+		return ballotRecord{}, errors.New("signed for another EAS contract")
+	}
+	if m.Schema != a.config.BallotSchemaUID {
+		return ballotRecord{}, fmt.Errorf("schema %s is not the ballot schema", m.Schema)
+	}
+	if m.RefUID != v.UID {
+		return ballotRecord{}, fmt.Errorf("references %s instead of the voting", m.RefUID)
+	}
+	if m.Recipient != votingRecipient(v.UID) {
+		return ballotRecord{}, fmt.Errorf("recipient %s is not the voting recipient", m.Recipient)
+	}
+	if m.ExpirationTime != 0 {
+		return ballotRecord{}, errors.New("ballot has the expiration time")
+	}
+	if m.uid() != s.UID {
+		return ballotRecord{}, fmt.Errorf("uid %s does not match the message", s.UID)
+	}
+	hash, _, err := apitypes.TypedDataAndHash(m.typedData(domain))
+	if err != nil {
+		return ballotRecord{}, err
+	}
+	if len(s.Signature.R) != 32 || len(s.Signature.S) != 32 || s.Signature.V < 27 {
+		return ballotRecord{}, errors.New("invalid signature")
+	}
+	sig := slices.Concat(s.Signature.R, s.Signature.S, []byte{s.Signature.V - 27})
+	key, err := crypto.SigToPub(hash, sig)
+	if err != nil {
+		return ballotRecord{}, fmt.Errorf("signature: %w", err)
+	}
+	if signer := crypto.PubkeyToAddress(*key); signer != o.Signer {
+		return ballotRecord{}, fmt.Errorf("signed by %s instead of %s", signer, o.Signer)
+	}
+	r := ballotRecord{
+		UID:       s.UID,
+		VotingUID: m.RefUID,
+		Recipient: m.Recipient,
+		Attester:  o.Signer,
+		Time:      time.Unix(int64(m.Time), 0),
+	}
+	if err := (eas.Attestation{Data: m.Data}).ScanValues(&r.Ballot); err != nil {
+		return ballotRecord{}, fmt.Errorf("ballot data: %w", err)
+	}
+	return r, nil
+}
+
+// getOffchainBallotRecords returns off-chain ballots of the voting from JSON
+// files in the directory, ordered by their time, with revocation times of
+// ballots that their signers revoked on-chain. Files that are not valid
+// ballots of the voting are returned with their errors. The time of an
+// off-chain ballot is set by its signer, so ballots with the time after the
+// current chain time are not valid.
+func (a *app) getOffchainBallotRecords(ctx context.Context, v *voting, dir string) (records []ballotRecord, invalid map[string]error, err error) {
+	if err := v.checkOffchain(); err != nil {
+		return nil, nil, err
+	}
+	filenames, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	domain, err := a.offchainDomain(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	now, err := a.chainTime(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	invalid = make(map[string]error)
+	seen := make(map[eas.UID]struct{})
+	for _, filename := range filenames {
+		o, err := readOffchainAttestation(filename)
+		if err != nil {
+			invalid[filename] = err
+			continue
+		}
+		r, err := a.verifyOffchainBallot(v, domain, o)
+		if err != nil {
+			invalid[filename] = err
+			continue
+		}
+		if r.Time.After(now) {
+			invalid[filename] = fmt.Errorf("signed with the time %s after the collection time %s", r.Time.UTC().Format(time.RFC3339), now.UTC().Format(time.RFC3339))
+			continue
+		}
+		if _, ok := seen[r.UID]; ok {
+			continue
+		}
+		seen[r.UID] = struct{}{}
+		revoked, err := a.client.EAS.GetRevokeOffchain(ctx, r.Attester, r.UID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if revoked > 0 {
+			r.RevocationTime = time.Unix(int64(revoked), 0)
+		}
+		records = append(records, r)
+	}
+	// off-chain ballots are not in blocks, so the log index orders them by
+	// time
+	slices.SortFunc(records, func(a, b ballotRecord) int {
+		if c := a.Time.Compare(b.Time); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.UID[:], b.UID[:])
+	})
+	for i := range records {
+		records[i].LogIndex = uint(i)
+	}
+	return records, invalid, nil
+}
+
+// checkOffchain returns an error if ballots of the voting can not be signed
+// off-chain, as commitments and encrypted ballots are verified on-chain.
+func (v *voting) checkOffchain() error {
+	if v.CommitReveal || v.isEncrypted() {
+		return fmt.Errorf("voting %s does not support off-chain ballots", v.UID)
+	}
+	return nil
+}
+
+func readOffchainAttestation(filename string) (*offchainAttestation, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var o offchainAttestation
+	if err := json.Unmarshal(data, &o); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", filename, err)
+	}
+	return &o, nil
+}
+
+func writeOffchainAttestation(filename string, o *offchainAttestation) error {
+	data, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// sortedInvalidFiles returns names of invalid files in the lexical order.
+func sortedInvalidFiles(invalid map[string]error) []string {
+	names := make([]string, 0, len(invalid))
+	for name := range invalid {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
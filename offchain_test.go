@@ -0,0 +1,120 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCalculateResults_offchain(t *testing.T) {
+	c := newTestChain(t, 4)
+
+	ctx := context.Background()
+
+	apps := make([]*app, 0, len(c.accounts))
+	for _, account := range c.accounts {
+		apps = append(apps, c.newApp(t, account))
+	}
+
+	now, err := apps[0].chainTime(ctx)
+	assertNilError(t, err)
+
+	v := createTestVoting(t, apps[0], &voting{
+		Title:   "Board election",
+		Choices: []string{"Alice", "Bob", "Carol"},
+		End:     now.Add(time.Hour),
+	})
+	other := createTestVoting(t, apps[0], &voting{
+		Title:   "Budget",
+		Choices: []string{"Yes", "No"},
+		End:     now.Add(time.Hour),
+	})
+
+	// the ballot data is encoded in the same way as in ballot attestations
+	attested, err := apps[0].client.EAS.GetAttestation(ctx, castTestBallot(t, apps[0], v, "1,,2"))
+	assertNilError(t, err)
+	ballot, err := parseRanking("1,,2", len(v.Choices))
+	assertNilError(t, err)
+	data, err := ballotArguments.Pack(ballot)
+	assertNilError(t, err)
+	if !bytes.Equal(data, attested.Data) {
+		t.Errorf("got ballot data %x, want %x", data, attested.Data)
+	}
+
+	dir := t.TempDir()
+	sign := func(a *app, i int, v *voting, ranking string) *offchainAttestation {
+		t.Helper()
+
+		ballot, err := parseRanking(ranking, len(v.Choices))
+		assertNilError(t, err)
+		o, err := a.signOffchainBallot(ctx, v, ballot, c.accounts[i])
+		assertNilError(t, err)
+		return o
+	}
+	write := func(name string, o *offchainAttestation) {
+		t.Helper()
+
+		assertNilError(t, writeOffchainAttestation(filepath.Join(dir, name), o))
+	}
+
+	write("0.json", sign(apps[0], 0, v, "1,2,3"))
+	write("1.json", sign(apps[1], 1, v, "2,1,3"))
+	write("2.json", sign(apps[2], 2, v, "3,2,1"))
+	write("2-copy.json", sign(apps[2], 2, v, "3,2,1"))
+	write("other.json", sign(apps[3], 3, other, "1,2"))
+
+	tampered := sign(apps[3], 3, v, "1,2,3")
+	tampered.Signer = apps[0].client.Address()
+	write("tampered.json", tampered)
+
+	future := sign(apps[3], 3, v, "2,3,1")
+	future.Sig.Message.Time += uint64(time.Hour / time.Second)
+	future, err = signOffchainAttestation(future.Sig.Domain, future.Sig.Message, c.accounts[3])
+	assertNilError(t, err)
+	write("future.json", future)
+
+	revoked := sign(apps[3], 3, v, "3,1,2")
+	write("revoked.json", revoked)
+	_, wait, err := apps[3].client.EAS.RevokeOffchain(ctx, revoked.Sig.UID)
+	assertNilError(t, err)
+	_, err = wait(ctx)
+	assertNilError(t, err)
+
+	records, invalid, err := apps[0].getOffchainBallotRecords(ctx, v, dir)
+	assertNilError(t, err)
+	assertEqual(t, "records", len(records), 4)
+	assertEqual(t, "invalid", sortedInvalidFiles(invalid), []string{
+		filepath.Join(dir, "future.json"),
+		filepath.Join(dir, "other.json"),
+		filepath.Join(dir, "tampered.json"),
+	})
+
+	r, err := apps[0].calculateResults(ctx, v.UID, tallyOptions{
+		Offchain: records,
+	})
+	assertNilError(t, err)
+
+	assertEqual(t, "attestations", r.Attestations, 4)
+	assertEqual(t, "ballots", r.Ballots, 3)
+	assertEqual(t, "revoked", len(r.Revoked), 1)
+	assertEqual(t, "revoked ballot", r.Revoked[0].UID, revoked.Sig.UID)
+	assertEqual(t, "preferences", r.Preferences, [][]int{
+		{0, 1, 2},
+		{2, 0, 2},
+		{1, 1, 0},
+	})
+
+	c.adjustTime(t, time.Hour)
+	c.commit(1)
+
+	if _, err := apps[0].signOffchainBallot(ctx, v, ballot, c.accounts[0]); err == nil {
+		t.Error("signed an off-chain ballot after the voting end")
+	}
+}
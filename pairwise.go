@@ -0,0 +1,658 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"resenje.org/eas"
+)
+
+// With homomorphic tallying, a ballot is encrypted as preferences of every
+// ordered pair of choices, one if the first choice is ranked higher than the
+// second and zero otherwise, which is all that the Schulze method needs.
+// Preferences are encrypted with the exponential ElGamal encryption, so that
+// ciphertexts of counted ballots can be summed into the encrypted pairwise
+// preferences matrix, and only the sums are decrypted. Every ballot has
+// disjunctive Chaum-Pedersen proofs that its preferences are of a ranking,
+// without revealing it.
+
+// maxHomomorphicChoices limits the number of choices of votings with
+// homomorphic tallying, as the number of ballot proofs grows with the cube of
+// the number of choices.
+const maxHomomorphicChoices = 10
+
+// maxHomomorphicWeight limits the sum of weights of counted ballots of
+// weighted and delegated votings with homomorphic tallying, as decrypted sums
+// are found with the baby-step giant-step algorithm that takes time and
+// memory proportional to the square root of the largest possible sum.
+const maxHomomorphicWeight = 1 << 32
+
+// pairwiseBallotProofDomain separates challenges of pairwise ballot proofs.
+const pairwiseBallotProofDomain = "SchulzeOnEAS pairwise ballot proof"
+
+// elGamalCiphertext is an exponential ElGamal encryption (A, B) =
+// (r·G, r·P + m·G) of a small integer m to the public key P. The sum of
+// ciphertexts is the encryption of the sum of their values.
+type elGamalCiphertext struct {
+	A, B *ecdsa.PublicKey
+}
+
+// encryptElGamal returns the encryption of the value and its randomness.
+func encryptElGamal(public *ecdsa.PublicKey, m int) (elGamalCiphertext, *big.Int, error) {
+	k, err := crypto.GenerateKey()
+	if err != nil {
+		return elGamalCiphertext{}, nil, err
+	}
+	return elGamalCiphertext{
+		A: &k.PublicKey,
+		B: addPoints(scalarMult(public, k.D), scalarBaseMult(big.NewInt(int64(m)))),
+	}, k.D, nil
+}
+
+func (c elGamalCiphertext) add(o elGamalCiphertext) elGamalCiphertext {
+	return elGamalCiphertext{
+		A: addPoints(c.A, o.A),
+		B: addPoints(c.B, o.B),
+	}
+}
+
+func (c elGamalCiphertext) mul(k *big.Int) elGamalCiphertext {
+	return elGamalCiphertext{
+		A: scalarMult(c.A, k),
+		B: scalarMult(c.B, k),
+	}
+}
+
+// bytes returns compressed points A and B.
+func (c elGamalCiphertext) bytes() []byte {
+	return append(pointBytes(c.A), pointBytes(c.B)...)
+}
+
+func parseElGamalCiphertext(data []byte) (elGamalCiphertext, error) {
+	if len(data) != 2*compressedPublicKeySize {
+		return elGamalCiphertext{}, errors.New("invalid ciphertext size")
+	}
+	a, err := crypto.DecompressPubkey(data[:compressedPublicKeySize])
+	if err != nil {
+		return elGamalCiphertext{}, fmt.Errorf("ciphertext: %w", err)
+	}
+	b, err := crypto.DecompressPubkey(data[compressedPublicKeySize:])
+	if err != nil {
+		return elGamalCiphertext{}, fmt.Errorf("ciphertext: %w", err)
+	}
+	return elGamalCiphertext{A: a, B: b}, nil
+}
+
+// pointBytes returns the compressed point or zero bytes for the point at
+// infinity.
+func pointBytes(p *ecdsa.PublicKey) []byte {
+	if p == nil {
+		return make([]byte, compressedPublicKeySize)
+	}
+	return crypto.CompressPubkey(p)
+}
+
+func negatePoint(p *ecdsa.PublicKey) *ecdsa.PublicKey {
+	if p == nil {
+		return nil
+	}
+	return &ecdsa.PublicKey{
+		Curve: crypto.S256(),
+		X:     new(big.Int).Set(p.X),
+		Y:     new(big.Int).Sub(crypto.S256().Params().P, p.Y),
+	}
+}
+
+// discreteLog returns the value m between zero and max for which the point is
+// m·G, with the baby-step giant-step algorithm. The max must not be larger
+// than maxHomomorphicWeight.
+func discreteLog(p *ecdsa.PublicKey, max int) (int, error) {
+	if max > maxHomomorphicWeight {
+		return 0, fmt.Errorf("decrypted value limit %v is larger than the supported %v", max, maxHomomorphicWeight)
+	}
+	step := int(math.Sqrt(float64(max))) + 1
+	g := scalarBaseMult(big.NewInt(1))
+	baby := make(map[string]int, step)
+	var q *ecdsa.PublicKey
+	for j := range step {
+		if q != nil {
+			baby[string(crypto.CompressPubkey(q))] = j
+		}
+		q = addPoints(q, g)
+	}
+	giant := negatePoint(q)
+	for i := 0; i*step <= max; i++ {
+		j, ok := 0, p == nil
+		if !ok {
+			j, ok = baby[string(crypto.CompressPubkey(p))]
+		}
+		if ok && i*step+j <= max {
+			return i*step + j, nil
+		}
+		p = addPoints(p, giant)
+	}
+	return 0, errors.New("decrypted value is out of range")
+}
+
+// pairIndex returns the index of the ordered pair of different choices i and
+// j in the row-major order of the preferences matrix without its diagonal.
+func pairIndex(i, j, choicesCount int) int {
+	if j > i {
+		j--
+	}
+	return i*(choicesCount-1) + j
+}
+
+// pairwisePreferences returns preferences of the ballot for every ordered
+// pair of choices, in the order of their pair indexes. The ballot must rank
+// every choice with a different rank.
+func pairwisePreferences(ballot ballotSchema, choicesCount int) ([]int, error) {
+	ranks := make([]int, choicesCount)
+	seen := make(map[uint16]struct{}, len(ballot))
+	for _, r := range ballot {
+		if int(r.ChoiceIndex) >= choicesCount {
+			return nil, fmt.Errorf("ballot has invalid choice %v", r.ChoiceIndex+1)
+		}
+		if r.Rank == 0 || ranks[r.ChoiceIndex] != 0 {
+			return nil, fmt.Errorf("ballot has invalid rank for choice %v", r.ChoiceIndex+1)
+		}
+		if _, ok := seen[r.Rank]; ok {
+			return nil, errors.New("homomorphic tallying requires different ranks for all choices")
+		}
+		seen[r.Rank] = struct{}{}
+		ranks[r.ChoiceIndex] = int(r.Rank)
+	}
+	if len(seen) != choicesCount {
+		return nil, errors.New("homomorphic tallying requires all choices to be ranked")
+	}
+	preferences := make([]int, choicesCount*(choicesCount-1))
+	for i := range choicesCount {
+		for j := range choicesCount {
+			if i != j && ranks[i] < ranks[j] {
+				preferences[pairIndex(i, j, choicesCount)] = 1
+			}
+		}
+	}
+	return preferences, nil
+}
+
+// pairwiseStatement requires that the sum of ciphertexts of pairs is one of
+// the values.
+type pairwiseStatement struct {
+	pairs  []int
+	values []int
+}
+
+// pairwiseStatements returns statements that hold for all ciphertexts of a
+// pairwise ballot only if they encrypt preferences of a ranking without ties.
+// The preference of i over j, where i < j, is zero or one, preferences of i
+// over j and j over i sum to one, so that every pair is decided in one
+// direction, and preferences of i over j, j over k and k over i, where
+// i < j < k, sum to one or two, so that there are no cycles.
+func pairwiseStatements(choicesCount int) []pairwiseStatement {
+	var statements []pairwiseStatement
+	for i := range choicesCount {
+		for j := i + 1; j < choicesCount; j++ {
+			statements = append(statements, pairwiseStatement{
+				pairs:  []int{pairIndex(i, j, choicesCount)},
+				values: []int{0, 1},
+			})
+		}
+	}
+	for i := range choicesCount {
+		for j := i + 1; j < choicesCount; j++ {
+			statements = append(statements, pairwiseStatement{
+				pairs:  []int{pairIndex(i, j, choicesCount), pairIndex(j, i, choicesCount)},
+				values: []int{1},
+			})
+		}
+	}
+	for i := range choicesCount {
+		for j := i + 1; j < choicesCount; j++ {
+			for k := j + 1; k < choicesCount; k++ {
+				statements = append(statements, pairwiseStatement{
+					pairs:  []int{pairIndex(i, j, choicesCount), pairIndex(j, k, choicesCount), pairIndex(k, i, choicesCount)},
+					values: []int{1, 2},
+				})
+			}
+		}
+	}
+	return statements
+}
+
+// pairwiseProofContext returns the hash that binds ballot proofs to the
+// tallier public key, the voting, the attester and ballot ciphertexts, so
+// that proofs can not be used with ciphertexts copied by another attester.
+func pairwiseProofContext(tallier *ecdsa.PublicKey, votingUID eas.UID, attester common.Address, ciphertexts [][]byte) []byte {
+	data := [][]byte{
+		[]byte(pairwiseBallotProofDomain),
+		crypto.CompressPubkey(tallier),
+		votingUID[:],
+		attester[:],
+	}
+	return crypto.Keccak256(append(data, ciphertexts...)...)
+}
+
+// statementContext returns the context of the statement with the index.
+func statementContext(context []byte, index int) []byte {
+	return binary.BigEndian.AppendUint32(slices.Clone(context), uint32(index))
+}
+
+// encryptPairwiseBallot encrypts preferences of the ballot to the tallier
+// public key and proves that they are of a ranking.
+func encryptPairwiseBallot(tallier *ecdsa.PublicKey, votingUID eas.UID, attester common.Address, ballot ballotSchema, choicesCount int) (pairwiseBallotSchema, error) {
+	var s pairwiseBallotSchema
+	preferences, err := pairwisePreferences(ballot, choicesCount)
+	if err != nil {
+		return s, err
+	}
+	ciphertexts := make([]elGamalCiphertext, 0, len(preferences))
+	randomness := make([]*big.Int, 0, len(preferences))
+	for _, m := range preferences {
+		c, r, err := encryptElGamal(tallier, m)
+		if err != nil {
+			return s, err
+		}
+		ciphertexts = append(ciphertexts, c)
+		randomness = append(randomness, r)
+		s.Ciphertexts = append(s.Ciphertexts, c.bytes())
+	}
+	context := pairwiseProofContext(tallier, votingUID, attester, s.Ciphertexts)
+	for i, statement := range pairwiseStatements(choicesCount) {
+		var c elGamalCiphertext
+		r := new(big.Int)
+		m := 0
+		for _, p := range statement.pairs {
+			c = c.add(ciphertexts[p])
+			r.Add(r, randomness[p])
+			m += preferences[p]
+		}
+		proof, err := proveOneOf(tallier, c, r, m, statement.values, statementContext(context, i))
+		if err != nil {
+			return s, err
+		}
+		s.Proof = append(s.Proof, proof...)
+	}
+	return s, nil
+}
+
+// verifyPairwiseBallot returns ciphertexts of the ballot if its proofs are
+// valid.
+func verifyPairwiseBallot(tallier *ecdsa.PublicKey, votingUID eas.UID, attester common.Address, s pairwiseBallotSchema, choicesCount int) ([]elGamalCiphertext, error) {
+	if len(s.Ciphertexts) != choicesCount*(choicesCount-1) {
+		return nil, fmt.Errorf("ballot has %v ciphertexts for %v choices", len(s.Ciphertexts), choicesCount)
+	}
+	ciphertexts := make([]elGamalCiphertext, 0, len(s.Ciphertexts))
+	for _, data := range s.Ciphertexts {
+		c, err := parseElGamalCiphertext(data)
+		if err != nil {
+			return nil, err
+		}
+		ciphertexts = append(ciphertexts, c)
+	}
+	statements := pairwiseStatements(choicesCount)
+	size := 0
+	for _, statement := range statements {
+		size += oneOfProofSize(statement.values)
+	}
+	if len(s.Proof) != size {
+		return nil, errors.New("invalid ballot proof size")
+	}
+	context := pairwiseProofContext(tallier, votingUID, attester, s.Ciphertexts)
+	proof := s.Proof
+	for i, statement := range statements {
+		var c elGamalCiphertext
+		for _, p := range statement.pairs {
+			c = c.add(ciphertexts[p])
+		}
+		size := oneOfProofSize(statement.values)
+		if err := verifyOneOf(tallier, c, statement.values, statementContext(context, i), proof[:size]); err != nil {
+			return nil, err
+		}
+		proof = proof[size:]
+	}
+	return ciphertexts, nil
+}
+
+// oneOfProofSize returns the size of the proof with a challenge and a
+// response for every value.
+func oneOfProofSize(values []int) int {
+	return len(values) * 64
+}
+
+// proveOneOf returns the disjunctive Chaum-Pedersen proof that the
+// ciphertext with the randomness r encrypts one of the values, without
+// revealing which one. For every value v, the proof has a challenge c_v and a
+// response z_v, such that T_v = z_v·G - c_v·A and U_v = z_v·P - c_v·(B - v·G)
+// are commitments of the proof of knowledge of r, where challenges of values
+// other than m are chosen at random, and challenges sum to the hash of all
+// commitments.
+func proveOneOf(tallier *ecdsa.PublicKey, c elGamalCiphertext, r *big.Int, m int, values []int, context []byte) ([]byte, error) {
+	n := crypto.S256().Params().N
+	index := slices.Index(values, m)
+	if index < 0 {
+		return nil, fmt.Errorf("value %v is not allowed", m)
+	}
+	challenges := make([]*big.Int, len(values))
+	responses := make([]*big.Int, len(values))
+	commitments := make([]*ecdsa.PublicKey, 0, 2*len(values))
+	var w *big.Int
+	for i, v := range values {
+		if i == index {
+			k, err := crypto.GenerateKey()
+			if err != nil {
+				return nil, err
+			}
+			w = k.D
+			commitments = append(commitments, &k.PublicKey, scalarMult(tallier, w))
+			continue
+		}
+		ck, err := crypto.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		zk, err := crypto.GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		challenges[i] = ck.D
+		responses[i] = zk.D
+		t, u := oneOfCommitments(tallier, c, v, ck.D, zk.D)
+		commitments = append(commitments, t, u)
+	}
+	challenge := oneOfChallenge(context, c, commitments)
+	for i, ci := range challenges {
+		if i != index {
+			challenge.Sub(challenge, ci)
+		}
+	}
+	challenges[index] = challenge.Mod(challenge, n)
+	z := new(big.Int).Mul(challenges[index], r)
+	z.Add(z, w)
+	responses[index] = z.Mod(z, n)
+
+	proof := make([]byte, 0, oneOfProofSize(values))
+	for i := range values {
+		proof = append(proof, challenges[i].FillBytes(make([]byte, 32))...)
+		proof = append(proof, responses[i].FillBytes(make([]byte, 32))...)
+	}
+	return proof, nil
+}
+
+// verifyOneOf returns an error if the proof does not show that the
+// ciphertext encrypts one of the values.
+func verifyOneOf(tallier *ecdsa.PublicKey, c elGamalCiphertext, values []int, context, proof []byte) error {
+	n := crypto.S256().Params().N
+	if len(proof) != oneOfProofSize(values) {
+		return errors.New("invalid ballot proof size")
+	}
+	sum := new(big.Int)
+	commitments := make([]*ecdsa.PublicKey, 0, 2*len(values))
+	for i, v := range values {
+		ci := new(big.Int).SetBytes(proof[i*64 : i*64+32])
+		zi := new(big.Int).SetBytes(proof[i*64+32 : i*64+64])
+		if ci.Cmp(n) >= 0 || zi.Cmp(n) >= 0 {
+			return errors.New("ballot proof: invalid scalar")
+		}
+		t, u := oneOfCommitments(tallier, c, v, ci, zi)
+		commitments = append(commitments, t, u)
+		sum.Add(sum, ci)
+	}
+	if sum.Mod(sum, n).Cmp(oneOfChallenge(context, c, commitments)) != 0 {
+		return errors.New("ballot proof is not valid")
+	}
+	return nil
+}
+
+func oneOfCommitments(tallier *ecdsa.PublicKey, c elGamalCiphertext, v int, challenge, response *big.Int) (t, u *ecdsa.PublicKey) {
+	negChallenge := new(big.Int).Neg(challenge)
+	t = addPoints(scalarBaseMult(response), scalarMult(c.A, negChallenge))
+	b := addPoints(c.B, negatePoint(scalarBaseMult(big.NewInt(int64(v)))))
+	u = addPoints(scalarMult(tallier, response), scalarMult(b, negChallenge))
+	return t, u
+}
+
+func oneOfChallenge(context []byte, c elGamalCiphertext, commitments []*ecdsa.PublicKey) *big.Int {
+	data := [][]byte{context, c.bytes()}
+	for _, p := range commitments {
+		data = append(data, pointBytes(p))
+	}
+	h := new(big.Int).SetBytes(crypto.Keccak256(data...))
+	return h.Mod(h, crypto.S256().Params().N)
+}
+
+// pairwiseAggregate is the sum of encrypted preferences of counted ballots
+// of a voting with homomorphic tallying, in the order of pair indexes.
+type pairwiseAggregate struct {
+	Preferences []elGamalCiphertext
+	// Sums of preferences multiplied by ballot weights, nil if the voting is
+	// not weighted or delegated.
+	WeightedPreferences []elGamalCiphertext
+	// Number of summed ballots and the sum of their weights, which are upper
+	// bounds of decrypted values.
+	Ballots int
+	Weight  int
+}
+
+func newPairwiseAggregate(choicesCount int, weighted bool) *pairwiseAggregate {
+	g := &pairwiseAggregate{
+		Preferences: make([]elGamalCiphertext, choicesCount*(choicesCount-1)),
+	}
+	if weighted {
+		g.WeightedPreferences = make([]elGamalCiphertext, choicesCount*(choicesCount-1))
+	}
+	return g
+}
+
+func (g *pairwiseAggregate) add(ciphertexts []elGamalCiphertext, weight int) {
+	for i, c := range ciphertexts {
+		g.Preferences[i] = g.Preferences[i].add(c)
+		if g.WeightedPreferences != nil {
+			g.WeightedPreferences[i] = g.WeightedPreferences[i].add(c.mul(big.NewInt(int64(weight))))
+		}
+	}
+	g.Ballots++
+	g.Weight += weight
+}
+
+// ciphertexts returns sums of preferences followed by sums of weighted
+// preferences.
+func (g *pairwiseAggregate) ciphertexts() []elGamalCiphertext {
+	return append(slices.Clone(g.Preferences), g.WeightedPreferences...)
+}
+
+// decrypt returns preferences and weighted preferences in the format of the
+// schulze package from the ciphertexts decrypted with their shared secrets.
+func (g *pairwiseAggregate) decrypt(shared []*ecdsa.PublicKey, choicesCount int) (preferences, weightedPreferences []int, err error) {
+	values := make([]int, 0, len(shared))
+	for i, c := range g.ciphertexts() {
+		max := g.Ballots
+		if i >= len(g.Preferences) {
+			max = g.Weight
+		}
+		v, err := discreteLog(addPoints(c.B, negatePoint(shared[i])), max)
+		if err != nil {
+			return nil, nil, err
+		}
+		values = append(values, v)
+	}
+	matrix := func(values []int) []int {
+		p := make([]int, choicesCount*choicesCount)
+		for i := range choicesCount {
+			for j := range choicesCount {
+				if i != j {
+					p[i*choicesCount+j] = values[pairIndex(i, j, choicesCount)]
+				}
+			}
+		}
+		return p
+	}
+	preferences = matrix(values[:len(g.Preferences)])
+	if g.WeightedPreferences != nil {
+		weightedPreferences = matrix(values[len(g.Preferences):])
+	}
+	return preferences, weightedPreferences, nil
+}
+
+// decryptedCiphertext is the decryption of a sum of encrypted preferences in
+// the transcript. It is empty if the sum has no ballots.
+type decryptedCiphertext struct {
+	SharedSecret hexutil.Bytes    `json:"sharedSecret,omitempty"`
+	Proof        *decryptionProof `json:"proof,omitempty"`
+}
+
+// attestPairwiseBallot attests preferences of the ballot encrypted to the
+// tallier public key of the voting.
+func (a *app) attestPairwiseBallot(ctx context.Context, v *voting, ballot ballotSchema) (*types.Transaction, eas.WaitTx[eas.EASAttested], error) {
+	tallier, err := crypto.DecompressPubkey(v.TallierPublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tallier public key: %w", err)
+	}
+	s, err := encryptPairwiseBallot(tallier, v.UID, a.client.Address(), ballot, len(v.Choices))
+	if err != nil {
+		return nil, nil, err
+	}
+	return a.client.EAS.Attest(ctx, a.config.schema(pairwiseBallotSchemaName).UID, &eas.AttestOptions{
+		Recipient: votingRecipient(v.UID),
+		RefUID:    v.UID,
+		Revocable: true,
+	}, s)
+}
+
+// getPairwiseBallotRecords returns ballot records of pairwise ballots in the
+// voting and ciphertexts of ballots with valid proofs by the ballot UID.
+// Ballots without valid proofs are undecryptable.
+func (a *app) getPairwiseBallotRecords(ctx context.Context, v *voting) ([]ballotRecord, map[eas.UID][]elGamalCiphertext, error) {
+	tallier, err := crypto.DecompressPubkey(v.TallierPublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tallier public key: %w", err)
+	}
+	currentBlock, err := a.client.Backend().(ethereum.BlockNumberReader).BlockNumber(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	attested, err := a.scanAttestations(ctx, a.config.schema(pairwiseBallotSchemaName).Block, currentBlock, []common.Address{votingRecipient(v.UID)}, a.config.schema(pairwiseBallotSchemaName).UID)
+	if err != nil {
+		return nil, nil, err
+	}
+	records := make([]ballotRecord, 0, len(attested))
+	ciphertexts := make(map[eas.UID][]elGamalCiphertext, len(attested))
+	for _, b := range attested {
+		if b.attestation.RefUID != v.UID {
+			continue
+		}
+		r := ballotRecord{
+			UID:            b.attestation.UID,
+			VotingUID:      b.attestation.RefUID,
+			Recipient:      b.event.Recipient,
+			Attester:       b.event.Attester,
+			Time:           b.attestation.Time,
+			Block:          b.event.Raw.BlockNumber,
+			LogIndex:       b.event.Raw.Index,
+			RevocationTime: attestationTime(b.attestation.RevocationTime),
+			Undecryptable:  true,
+		}
+		var s pairwiseBallotSchema
+		if err := b.attestation.ScanValues(&s); err == nil {
+			if c, err := verifyPairwiseBallot(tallier, v.UID, r.Attester, s, len(v.Choices)); err == nil {
+				ciphertexts[r.UID] = c
+				r.Undecryptable = false
+			}
+		}
+		records = append(records, r)
+	}
+	return records, ciphertexts, nil
+}
+
+// decryptPairwiseAggregate returns decrypted preferences and weighted
+// preferences of the aggregate by verifying the transcript or, without the
+// transcript, by combining partial decryptions of trustees.
+func (a *app) decryptPairwiseAggregate(ctx context.Context, v *voting, g *pairwiseAggregate, t *decryptionTranscript) (preferences, weightedPreferences []int, err error) {
+	var shared []*ecdsa.PublicKey
+	if t != nil {
+		shared, err = verifyAggregateTranscript(v, t, g.ciphertexts())
+	} else {
+		shared, err = a.combineAggregatePartialDecryptions(ctx, v, g.ciphertexts())
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return g.decrypt(shared, len(v.Choices))
+}
+
+// decryptAggregate decrypts the aggregate with the tallier private key and
+// returns the transcript of the decryption.
+func decryptAggregate(key *ecdsa.PrivateKey, v *voting, g *pairwiseAggregate) (*decryptionTranscript, error) {
+	t := &decryptionTranscript{
+		VotingUID:        v.UID,
+		TallierPublicKey: v.TallierPublicKey,
+		Ballots:          []decryptedBallot{},
+	}
+	for _, c := range g.ciphertexts() {
+		if c.A == nil {
+			t.Aggregate = append(t.Aggregate, decryptedCiphertext{})
+			continue
+		}
+		shared := scalarMult(c.A, key.D)
+		proof, err := proveDecryption(key, c.A, shared)
+		if err != nil {
+			return nil, err
+		}
+		t.Aggregate = append(t.Aggregate, decryptedCiphertext{
+			SharedSecret: crypto.CompressPubkey(shared),
+			Proof:        proof,
+		})
+	}
+	return t, nil
+}
+
+// verifyAggregateTranscript returns shared secrets of aggregate ciphertexts
+// from the transcript if their proofs are valid.
+func verifyAggregateTranscript(v *voting, t *decryptionTranscript, ciphertexts []elGamalCiphertext) ([]*ecdsa.PublicKey, error) {
+	if t.VotingUID != v.UID {
+		return nil, fmt.Errorf("decryption transcript is for voting %s", t.VotingUID)
+	}
+	if !bytes.Equal(t.TallierPublicKey, v.TallierPublicKey) {
+		return nil, errors.New("decryption transcript has a different tallier public key")
+	}
+	tallier, err := crypto.DecompressPubkey(v.TallierPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("tallier public key: %w", err)
+	}
+	if len(t.Aggregate) != len(ciphertexts) {
+		return nil, errors.New("decryption transcript does not match the aggregate of counted ballots")
+	}
+	shared := make([]*ecdsa.PublicKey, len(ciphertexts))
+	for i, c := range ciphertexts {
+		if c.A == nil {
+			continue
+		}
+		d := t.Aggregate[i]
+		s, err := crypto.DecompressPubkey(d.SharedSecret)
+		if err != nil || d.Proof == nil {
+			return nil, errors.New("decryption transcript does not match the aggregate of counted ballots")
+		}
+		if err := d.Proof.verify(tallier, c.A, s); err != nil {
+			return nil, fmt.Errorf("decryption transcript does not match the aggregate of counted ballots: %w", err)
+		}
+		shared[i] = s
+	}
+	return shared, nil
+}
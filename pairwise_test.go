@@ -0,0 +1,121 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"resenje.org/eas"
+)
+
+func TestPairwisePreferences(t *testing.T) {
+	preferences, err := pairwisePreferences(ballotSchema{
+		{ChoiceIndex: 0, Rank: 2},
+		{ChoiceIndex: 1, Rank: 3},
+		{ChoiceIndex: 2, Rank: 1},
+	}, 3)
+	assertNilError(t, err)
+	// pairs in order (0,1) (0,2) (1,0) (1,2) (2,0) (2,1)
+	assertEqual(t, "preferences", preferences, []int{1, 0, 0, 0, 1, 1})
+
+	for _, tc := range []struct {
+		name   string
+		ballot ballotSchema
+	}{
+		{
+			name:   "unranked choice",
+			ballot: ballotSchema{{ChoiceIndex: 0, Rank: 1}, {ChoiceIndex: 1, Rank: 2}},
+		},
+		{
+			name:   "tie",
+			ballot: ballotSchema{{ChoiceIndex: 0, Rank: 1}, {ChoiceIndex: 1, Rank: 1}, {ChoiceIndex: 2, Rank: 2}},
+		},
+		{
+			name:   "repeated choice",
+			ballot: ballotSchema{{ChoiceIndex: 0, Rank: 1}, {ChoiceIndex: 0, Rank: 2}, {ChoiceIndex: 2, Rank: 3}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := pairwisePreferences(tc.ballot, 3); err == nil {
+				t.Error("got preferences of an invalid ranking")
+			}
+		})
+	}
+}
+
+func TestVerifyPairwiseBallot(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assertNilError(t, err)
+	votingUID := eas.HexDecodeUID("0x01")
+	attester := common.HexToAddress("0x0a")
+	ballot := ballotSchema{
+		{ChoiceIndex: 0, Rank: 3},
+		{ChoiceIndex: 1, Rank: 1},
+		{ChoiceIndex: 2, Rank: 4},
+		{ChoiceIndex: 3, Rank: 2},
+	}
+
+	s, err := encryptPairwiseBallot(&key.PublicKey, votingUID, attester, ballot, 4)
+	assertNilError(t, err)
+
+	ciphertexts, err := verifyPairwiseBallot(&key.PublicKey, votingUID, attester, s, 4)
+	assertNilError(t, err)
+	want, err := pairwisePreferences(ballot, 4)
+	assertNilError(t, err)
+	for i, c := range ciphertexts {
+		m, err := discreteLog(addPoints(c.B, negatePoint(scalarMult(c.A, key.D))), 1)
+		assertNilError(t, err)
+		assertEqual(t, "preference", m, want[i])
+	}
+
+	if _, err := verifyPairwiseBallot(&key.PublicKey, votingUID, common.HexToAddress("0x0b"), s, 4); err == nil {
+		t.Error("verified the ballot copied by another attester")
+	}
+	if _, err := verifyPairwiseBallot(&key.PublicKey, eas.HexDecodeUID("0x02"), attester, s, 4); err == nil {
+		t.Error("verified the ballot copied to another voting")
+	}
+
+	swapped := pairwiseBallotSchema{
+		Ciphertexts: append([][]byte{}, s.Ciphertexts...),
+		Proof:       s.Proof,
+	}
+	swapped.Ciphertexts[0], swapped.Ciphertexts[1] = swapped.Ciphertexts[1], swapped.Ciphertexts[0]
+	if _, err := verifyPairwiseBallot(&key.PublicKey, votingUID, attester, swapped, 4); err == nil {
+		t.Error("verified the ballot with swapped ciphertexts")
+	}
+
+	// a ciphertext of a value that is not a preference can not be proven
+	c, r, err := encryptElGamal(&key.PublicKey, 2)
+	assertNilError(t, err)
+	if _, err := proveOneOf(&key.PublicKey, c, r, 2, []int{0, 1}, nil); err == nil {
+		t.Error("proved a value that is not allowed")
+	}
+	one, r, err := encryptElGamal(&key.PublicKey, 1)
+	assertNilError(t, err)
+	proof, err := proveOneOf(&key.PublicKey, one, r, 1, []int{0, 1}, nil)
+	assertNilError(t, err)
+	assertNilError(t, verifyOneOf(&key.PublicKey, one, []int{0, 1}, nil, proof))
+	if err := verifyOneOf(&key.PublicKey, c, []int{0, 1}, nil, proof); err == nil {
+		t.Error("verified the proof for another ciphertext")
+	}
+}
+
+func TestDiscreteLog(t *testing.T) {
+	for _, m := range []int{0, 1, 2, 15, 16, 17, 99, 100} {
+		got, err := discreteLog(scalarBaseMult(big.NewInt(int64(m))), 100)
+		assertNilError(t, err)
+		assertEqual(t, "value", got, m)
+	}
+	if _, err := discreteLog(scalarBaseMult(big.NewInt(101)), 100); err == nil {
+		t.Error("found the value out of range")
+	}
+	if _, err := discreteLog(scalarBaseMult(big.NewInt(1)), maxHomomorphicWeight+1); err == nil {
+		t.Error("searched the value over the limit")
+	}
+}
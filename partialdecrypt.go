@@ -0,0 +1,91 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"resenje.org/eas"
+)
+
+func partialDecryptCommand() error {
+	cli := flag.NewFlagSet("schulzeoneas partial-decrypt", flag.ExitOnError)
+
+	options := newAppOptions(cli)
+	account := newAccountOptions(cli)
+	votingFlag := cli.String("voting", "", "UID of the voting")
+	ceremonyFlag := cli.String("ceremony", "", "UID of the key ceremony")
+	allowlistFlag := cli.String("allowlist", "", "File with addresses of the voting allowlist, one per line")
+
+	if err := cli.Parse(os.Args[2:]); err != nil {
+		log.Println(err)
+		cli.Usage()
+	}
+
+	if *votingFlag == "" {
+		return errors.New("voting UID is required")
+	}
+	if !isHexUID(*votingFlag) {
+		return fmt.Errorf("invalid voting UID %q", *votingFlag)
+	}
+	if *ceremonyFlag == "" {
+		return errors.New("key ceremony UID is required")
+	}
+	if !isHexUID(*ceremonyFlag) {
+		return fmt.Errorf("invalid key ceremony UID %q", *ceremonyFlag)
+	}
+
+	a, err := options.newApp()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	key, err := account.key(a)
+	if err != nil {
+		return err
+	}
+	if err := a.setClient(ctx, key); err != nil {
+		return err
+	}
+
+	log.Println("Wallet address:", a.client.Address())
+
+	voting, err := a.getVoting(ctx, eas.HexDecodeUID(*votingFlag))
+	if err != nil {
+		return err
+	}
+	ceremony, err := a.getKeyCeremony(ctx, eas.HexDecodeUID(*ceremonyFlag))
+	if err != nil {
+		return err
+	}
+
+	if *allowlistFlag != "" {
+		if err := a.importAllowlist(ctx, voting, *allowlistFlag); err != nil {
+			return err
+		}
+	}
+
+	tx, wait, err := a.partialDecrypt(ctx, voting, ceremony, key)
+	if err != nil {
+		return err
+	}
+	log.Println("Waiting partial decryption attestation:", tx.Hash())
+	r, err := wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(r.UID)
+
+	return nil
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// publicKeyCommand prints the compressed public key of the keystore account
+// that can be used as the tallier public key of a voting.
+func publicKeyCommand() error {
+	cli := flag.NewFlagSet("schulzeoneas public-key", flag.ExitOnError)
+
+	options := newAppOptions(cli)
+	account := newAccountOptions(cli)
+
+	if err := cli.Parse(os.Args[2:]); err != nil {
+		log.Println(err)
+		cli.Usage()
+	}
+
+	a, err := options.newApp()
+	if err != nil {
+		return err
+	}
+
+	key, err := account.key(a)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(hexutil.Encode(crypto.CompressPubkey(&key.PublicKey)))
+
+	return nil
+}
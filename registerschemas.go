@@ -10,6 +10,8 @@ import (
 	"flag"
 	"log"
 	"os"
+	"strings"
+	"unicode"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -19,14 +21,21 @@ import (
 func registerSchemasCommand() error {
 	cli := flag.NewFlagSet("schulzeoneas register-schemas", flag.ExitOnError)
 
-	endpointFlag := cli.String("rpc-endpoint", "https://ethereum-sepolia-rpc.publicnode.com/", "")
-	easContractAddressFlag := cli.String("eas-contract-address", "0xC2679fBD37d54388Ce493F1DB75320D236e1815e", "")
+	networkFlag := cli.String("network", defaultNetwork, "")
+	endpointFlag := cli.String("rpc-endpoint", "", "")
+	easContractAddressFlag := cli.String("eas-contract-address", "", "")
 	privateKeyFlag := cli.String("private-key", "", "")
 	configSchemaUIDFlag := cli.String("config-schema-uid", "", "")
 	votingSchemaUIDFlag := cli.String("voting-schema-uid", "", "")
 	votingSchemaBlockNumberFlag := cli.Uint64("voting-schema-block", 0, "")
 	ballotSchemaUIDFlag := cli.String("ballot-schema-uid", "", "")
 	ballotSchemaBlockNumberFlag := cli.Uint64("ballot-schema-block", 0, "")
+	schemaUIDFlags := make([]*string, len(schemaDefinitions))
+	schemaBlockNumberFlags := make([]*uint64, len(schemaDefinitions))
+	for i, d := range schemaDefinitions {
+		schemaUIDFlags[i] = cli.String(flagName(d.name)+"-schema-uid", "", "")
+		schemaBlockNumberFlags[i] = cli.Uint64(flagName(d.name)+"-schema-block", 0, "")
+	}
 
 	if err := cli.Parse(os.Args[2:]); err != nil {
 		log.Println(err)
@@ -44,7 +53,18 @@ func registerSchemasCommand() error {
 
 	log.Println("Wallet address:", crypto.PubkeyToAddress(provateKey.PublicKey))
 
-	client, err := eas.NewClient(ctx, *endpointFlag, provateKey, common.HexToAddress(*easContractAddressFlag), nil)
+	network, err := findNetworkProfile(builtinNetworkProfiles, *networkFlag)
+	if err != nil {
+		return err
+	}
+	if *endpointFlag != "" {
+		network.Endpoint = *endpointFlag
+	}
+	if *easContractAddressFlag != "" {
+		network.EASContractAddress = common.HexToAddress(*easContractAddressFlag)
+	}
+
+	client, _, err := connectEAS(ctx, nil, network.Endpoint, network.ChainID, network.EASContractAddress, provateKey)
 	if err != nil {
 		return err
 	}
@@ -55,71 +75,143 @@ func registerSchemasCommand() error {
 		BallotSchemaUID:   eas.HexDecodeUID(*ballotSchemaUIDFlag),
 		BallotSchemaBlock: *ballotSchemaBlockNumberFlag,
 	}
+	for i, d := range schemaDefinitions {
+		if uid := eas.HexDecodeUID(*schemaUIDFlags[i]); !uid.IsZero() {
+			config.Schemas = append(config.Schemas, namedSchema{
+				Name:  d.name,
+				UID:   uid,
+				Block: *schemaBlockNumberFlags[i],
+			})
+		}
+	}
 
-	if configSchemaUID.IsZero() {
-		tx, wait, err := registerConfigSchema(ctx, client)
+	_, err = registerSchemas(ctx, client, configSchemaUID, config)
+	return err
+}
+
+// Names of schemas in the config.
+const (
+	votingV2SchemaName                   = "votingV2"
+	votingV3SchemaName                   = "votingV3"
+	delegationSchemaName                 = "delegation"
+	commitmentSchemaName                 = "commitment"
+	revealSchemaName                     = "reveal"
+	encryptedBallotSchemaName            = "encryptedBallot"
+	keyCeremonySchemaName                = "keyCeremony"
+	keyDealingSchemaName                 = "keyDealing"
+	keyComplaintSchemaName               = "keyComplaint"
+	partialDecryptionSchemaName          = "partialDecryption"
+	pairwiseBallotSchemaName             = "pairwiseBallot"
+	aggregatePartialDecryptionSchemaName = "aggregatePartialDecryption"
+)
+
+// schemaDefinitions are schemas that are listed in the config by their names,
+// in addition to the voting and ballot schemas.
+var schemaDefinitions = []struct {
+	name   string
+	schema any
+}{
+	{name: votingV2SchemaName, schema: votingSchemaV2{}},
+	{name: votingV3SchemaName, schema: votingSchemaV3{}},
+	{name: delegationSchemaName, schema: delegationSchema{}},
+	{name: commitmentSchemaName, schema: ballotCommitmentSchema{}},
+	{name: revealSchemaName, schema: ballotRevealSchema{}},
+	{name: encryptedBallotSchemaName, schema: encryptedBallotSchema{}},
+	{name: keyCeremonySchemaName, schema: keyCeremonySchema{}},
+	{name: keyDealingSchemaName, schema: keyDealingSchema{}},
+	{name: keyComplaintSchemaName, schema: keyComplaintSchema{}},
+	{name: partialDecryptionSchemaName, schema: partialDecryptionSchema{}},
+	{name: pairwiseBallotSchemaName, schema: pairwiseBallotSchema{}},
+	{name: aggregatePartialDecryptionSchemaName, schema: aggregatePartialDecryptionSchema{}},
+}
+
+// flagName returns the command line flag name for the camel case schema
+// name.
+func flagName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if unicode.IsUpper(r) {
+			b.WriteByte('-')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// registerSchemas registers the config schema if its UID is zero and every
+// schema of the config that it does not have, and attests the config. It
+// returns the UID of the config attestation.
+func registerSchemas(ctx context.Context, client *eas.Client, configSchemaUID eas.UID, config configSchema) (eas.UID, error) {
+	register := func(name string, schema any) (eas.UID, uint64, error) {
+		tx, wait, err := registerSchema(ctx, client, schema)
 		if err != nil {
-			return err
+			return eas.UID{}, 0, err
 		}
-		log.Println("Waiting Config schema registration:", tx)
+		log.Println("Waiting", name, "schema registration:", tx)
 		u, blockNumber, err := wait(ctx)
 		if err != nil {
-			return err
+			return eas.UID{}, 0, err
 		}
-		log.Println("Config Schema UID:", u, "at block", blockNumber)
-		configSchemaUID = u
+		log.Println(name, "schema UID:", u, "at block", blockNumber)
+		return u, blockNumber, nil
 	}
 
-	if config.VotingSchemaUID.IsZero() {
-		tx, wait, err := registerVotingSchema(ctx, client)
+	if configSchemaUID.IsZero() {
+		u, _, err := register("config", configSchema{})
 		if err != nil {
-			return err
+			return eas.UID{}, err
 		}
-		log.Println("Waiting Voting schema registration:", tx)
-		u, blockNumber, err := wait(ctx)
+		configSchemaUID = u
+	}
+
+	if config.VotingSchemaUID.IsZero() {
+		u, blockNumber, err := register("voting", votingSchema{})
 		if err != nil {
-			return err
+			return eas.UID{}, err
 		}
-		log.Println("Voting Schema UID:", u, "at block", blockNumber)
 		config.VotingSchemaUID = u
 		config.VotingSchemaBlock = blockNumber
 	}
 
 	if config.BallotSchemaUID.IsZero() {
-		tx, wait, err := registerBallotSchema(ctx, client)
-		if err != nil {
-			return err
-		}
-		log.Println("Waiting Ballot schema registration:", tx)
-		u, blockNumber, err := wait(ctx)
+		u, blockNumber, err := register("ballot", ballotSchema{})
 		if err != nil {
-			return err
+			return eas.UID{}, err
 		}
-		log.Println("Ballot Schema UID:", u, "at block", blockNumber)
 		config.BallotSchemaUID = u
 		config.BallotSchemaBlock = blockNumber
 	}
 
+	for _, d := range schemaDefinitions {
+		if !config.schema(d.name).UID.IsZero() {
+			continue
+		}
+		u, blockNumber, err := register(d.name, d.schema)
+		if err != nil {
+			return eas.UID{}, err
+		}
+		config.Schemas = append(config.Schemas, namedSchema{
+			Name:  d.name,
+			UID:   u,
+			Block: blockNumber,
+		})
+	}
+
 	tx, wait, err := client.EAS.Attest(ctx, configSchemaUID, nil, config)
 	if err != nil {
-		return err
+		return eas.UID{}, err
 	}
 	log.Println("Waiting Config attestation:", tx.Hash())
 	r, err := wait(ctx)
 	if err != nil {
-		return err
+		return eas.UID{}, err
 	}
 	log.Println("Config Schema attestation:", r.UID)
 
-	return nil
+	return r.UID, nil
 }
 
-var registerVotingSchema = registerSchema[votingSchema]
-var registerBallotSchema = registerSchema[ballotSchema]
-var registerConfigSchema = registerSchema[configSchema]
-
-func registerSchema[T any](ctx context.Context, client *eas.Client) (common.Hash, func(context.Context) (eas.UID, uint64, error), error) {
-	var t T
+func registerSchema(ctx context.Context, client *eas.Client, t any) (common.Hash, func(context.Context) (eas.UID, uint64, error), error) {
 	schema, err := eas.NewSchema(t)
 	if err != nil {
 		return common.Hash{}, nil, err
@@ -0,0 +1,64 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+func relayCommand() error {
+	cli := flag.NewFlagSet("schulzeoneas relay", flag.ExitOnError)
+
+	options := newAppOptions(cli)
+	account := newAccountOptions(cli)
+	dirFlag := cli.String("dir", "", "Directory with JSON files of ballots signed for a relayer")
+	batchFlag := cli.Int("batch", 50, "Maximal number of ballots attested in a single transaction")
+
+	if err := cli.Parse(os.Args[2:]); err != nil {
+		log.Println(err)
+		cli.Usage()
+	}
+
+	if *dirFlag == "" {
+		return errors.New("ballots directory is required")
+	}
+
+	filenames, err := relayRequestFiles(*dirFlag)
+	if err != nil {
+		return err
+	}
+
+	a, err := options.newApp()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	key, err := account.key(a)
+	if err != nil {
+		return err
+	}
+	if err := a.setClient(ctx, key); err != nil {
+		return err
+	}
+
+	log.Println("Wallet address:", a.client.Address())
+
+	relayed, skipped, err := a.relayBallots(ctx, key, filenames, *batchFlag)
+	for _, filename := range sortedInvalidFiles(skipped) {
+		log.Printf("Not relayed %s: %v", filename, skipped[filename])
+	}
+	for _, r := range relayed {
+		fmt.Println(r.UID, r.Filename)
+	}
+	return err
+}
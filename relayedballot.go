@@ -0,0 +1,429 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"resenje.org/eas"
+)
+
+// delegatedAttestationABI is the EAS contract methods and the event for
+// attestations by delegation.
+var delegatedAttestationABI = mustParseABI(`[{"inputs":[],"name":"getAttestTypeHash","outputs":[{"internalType":"bytes32","name":"","type":"bytes32"}],"stateMutability":"pure","type":"function"},{"inputs":[],"name":"getDomainSeparator","outputs":[{"internalType":"bytes32","name":"","type":"bytes32"}],"stateMutability":"view","type":"function"},{"inputs":[{"internalType":"address","name":"account","type":"address"}],"name":"getNonce","outputs":[{"internalType":"uint256","name":"","type":"uint256"}],"stateMutability":"view","type":"function"},{"inputs":[{"components":[{"internalType":"bytes32","name":"schema","type":"bytes32"},{"components":[{"internalType":"address","name":"recipient","type":"address"},{"internalType":"uint64","name":"expirationTime","type":"uint64"},{"internalType":"bool","name":"revocable","type":"bool"},{"internalType":"bytes32","name":"refUID","type":"bytes32"},{"internalType":"bytes","name":"data","type":"bytes"},{"internalType":"uint256","name":"value","type":"uint256"}],"internalType":"struct AttestationRequestData[]","name":"data","type":"tuple[]"},{"components":[{"internalType":"uint8","name":"v","type":"uint8"},{"internalType":"bytes32","name":"r","type":"bytes32"},{"internalType":"bytes32","name":"s","type":"bytes32"}],"internalType":"struct EIP712Signature[]","name":"signatures","type":"tuple[]"},{"internalType":"address","name":"attester","type":"address"}],"internalType":"struct MultiDelegatedAttestationRequest[]","name":"multiDelegatedRequests","type":"tuple[]"}],"name":"multiAttestByDelegation","outputs":[{"internalType":"bytes32[]","name":"","type":"bytes32[]"}],"stateMutability":"payable","type":"function"},{"anonymous":false,"inputs":[{"indexed":true,"internalType":"address","name":"recipient","type":"address"},{"indexed":true,"internalType":"address","name":"attester","type":"address"},{"indexed":false,"internalType":"bytes32","name":"uid","type":"bytes32"},{"indexed":true,"internalType":"bytes32","name":"schema","type":"bytes32"}],"name":"Attested","type":"event"}]`)
+
+// delegatedAttestTypeHash is the EIP-712 type hash of delegated attestation
+// requests of the EAS contract.
+var delegatedAttestTypeHash = crypto.Keccak256Hash([]byte("Attest(bytes32 schema,address recipient,uint64 expirationTime,bool revocable,bytes32 refUID,bytes data,uint256 nonce)"))
+
+// relayRequest is a ballot attestation request signed by the voter that a
+// relayer submits to the EAS contract, which records the voter as the
+// attester.
+type relayRequest struct {
+	ChainID        uint64            `json:"chainId"`
+	EAS            common.Address    `json:"eas"`
+	Schema         eas.UID           `json:"schema"`
+	Recipient      common.Address    `json:"recipient"`
+	ExpirationTime uint64            `json:"expirationTime"`
+	Revocable      bool              `json:"revocable"`
+	RefUID         eas.UID           `json:"refUID"`
+	Data           hexutil.Bytes     `json:"data"`
+	Nonce          uint64            `json:"nonce"`
+	Attester       common.Address    `json:"attester"`
+	Signature      offchainSignature `json:"signature"`
+}
+
+// digest returns the EIP-712 hash of the request that the attester signs.
+func (r *relayRequest) digest(domainSeparator common.Hash) common.Hash {
+	word := func(b []byte) []byte {
+		return common.LeftPadBytes(b, 32)
+	}
+	var revocable []byte
+	if r.Revocable {
+		revocable = []byte{1}
+	}
+	structHash := crypto.Keccak256(
+		delegatedAttestTypeHash[:],
+		r.Schema[:],
+		word(r.Recipient[:]),
+		word(new(big.Int).SetUint64(r.ExpirationTime).Bytes()),
+		word(revocable),
+		r.RefUID[:],
+		crypto.Keccak256(r.Data),
+		word(new(big.Int).SetUint64(r.Nonce).Bytes()),
+	)
+	return crypto.Keccak256Hash([]byte{0x19, 0x01}, domainSeparator[:], structHash)
+}
+
+// relayDomain returns the EIP-712 domain separator of the EAS contract and
+// the chain id, and checks that the contract signs delegated attestations
+// with the known type hash.
+func (a *app) relayDomain(ctx context.Context) (common.Hash, uint64, error) {
+	typeHash, err := callEAS[[32]byte](ctx, a, "getAttestTypeHash")
+	if err != nil {
+		return common.Hash{}, 0, err
+	}
+	if typeHash != delegatedAttestTypeHash {
+		return common.Hash{}, 0, errors.New("eas contract does not support delegated attestations of this version")
+	}
+	separator, err := callEAS[[32]byte](ctx, a, "getDomainSeparator")
+	if err != nil {
+		return common.Hash{}, 0, err
+	}
+	chainID, err := a.client.Backend().ChainID(ctx)
+	if err != nil {
+		return common.Hash{}, 0, fmt.Errorf("chain id: %w", err)
+	}
+	return separator, chainID.Uint64(), nil
+}
+
+// attesterNonce returns the nonce of the next delegated attestation of the
+// attester.
+func (a *app) attesterNonce(ctx context.Context, attester common.Address) (uint64, error) {
+	n, err := callEAS[*big.Int](ctx, a, "getNonce", attester)
+	if err != nil {
+		return 0, err
+	}
+	return n.Uint64(), nil
+}
+
+func callEAS[T any](ctx context.Context, a *app, method string, args ...any) (v T, err error) {
+	c := bind.NewBoundContract(a.easContractAddress, delegatedAttestationABI, a.client.Backend(), nil, nil)
+	var out []any
+	if err := c.Call(&bind.CallOpts{Context: ctx}, &out, method, args...); err != nil {
+		return v, fmt.Errorf("eas %s: %w", method, err)
+	}
+	if len(out) != 1 {
+		return v, fmt.Errorf("eas %s: unexpected result", method)
+	}
+	v, ok := out[0].(T)
+	if !ok {
+		return v, fmt.Errorf("eas %s: unexpected result type %T", method, out[0])
+	}
+	return v, nil
+}
+
+// signRelayRequest returns the ballot as an attestation request signed with
+// the key, which a relayer can submit without a transaction of the voter.
+func (a *app) signRelayRequest(ctx context.Context, v *voting, ballot ballotSchema, key *ecdsa.PrivateKey) (*relayRequest, error) {
+	if v.CommitReveal || v.isEncrypted() {
+		return nil, fmt.Errorf("voting %s does not support relayed ballots", v.UID)
+	}
+	now, err := a.chainTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.checkTime(now); err != nil {
+		return nil, err
+	}
+	attester := crypto.PubkeyToAddress(key.PublicKey)
+	if err := a.checkEligibility(ctx, v, attester); err != nil && !errors.Is(err, errAllowlistUnavailable) {
+		return nil, err
+	}
+	data, err := ballotArguments.Pack(ballot)
+	if err != nil {
+		return nil, err
+	}
+	separator, chainID, err := a.relayDomain(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := a.attesterNonce(ctx, attester)
+	if err != nil {
+		return nil, err
+	}
+	r := &relayRequest{
+		ChainID:   chainID,
+		EAS:       a.easContractAddress,
+		Schema:    a.config.BallotSchemaUID,
+		Recipient: votingRecipient(v.UID),
+		Revocable: true,
+		RefUID:    v.UID,
+		Data:      data,
+		Nonce:     nonce,
+		Attester:  attester,
+	}
+	digest := r.digest(separator)
+	sig, err := crypto.Sign(digest[:], key)
+	if err != nil {
+		return nil, err
+	}
+	r.Signature = offchainSignature{
+		R: sig[:32],
+		S: sig[32:64],
+		V: sig[64] + 27,
+	}
+	return r, nil
+}
+
+// verifyRelayRequest checks that the request is a ballot signed by its
+// attester for the EAS contract that the client is connected to, and returns
+// its voting.
+func (a *app) verifyRelayRequest(ctx context.Context, r *relayRequest, separator common.Hash, chainID uint64) (*voting, error) {
+	if r.ChainID != chainID || r.EAS != a.easContractAddress {
+		return nil, fmt.Errorf("signed for eas contract %s on chain %v", r.EAS, r.ChainID)
+	}
+	if r.Schema != a.config.BallotSchemaUID {
+		return nil, fmt.Errorf("schema %s is not the ballot schema", r.Schema)
+	}
+	if r.ExpirationTime != 0 {
+		return nil, errors.New("ballot has the expiration time")
+	}
+	if r.Recipient != votingRecipient(r.RefUID) {
+		return nil, fmt.Errorf("recipient %s is not the voting recipient", r.Recipient)
+	}
+	var ballot ballotSchema
+	if err := (eas.Attestation{Data: r.Data}).ScanValues(&ballot); err != nil {
+		return nil, fmt.Errorf("ballot data: %w", err)
+	}
+	if len(r.Signature.R) != 32 || len(r.Signature.S) != 32 || r.Signature.V < 27 {
+		return nil, errors.New("invalid signature")
+	}
+	digest := r.digest(separator)
+	key, err := crypto.SigToPub(digest[:], slices.Concat(r.Signature.R, r.Signature.S, []byte{r.Signature.V - 27}))
+	if err != nil {
+		return nil, fmt.Errorf("signature: %w", err)
+	}
+	if signer := crypto.PubkeyToAddress(*key); signer != r.Attester {
+		return nil, fmt.Errorf("signed by %s instead of %s", signer, r.Attester)
+	}
+	v, err := a.getVoting(ctx, r.RefUID)
+	if err != nil {
+		return nil, err
+	}
+	if v.CommitReveal || v.isEncrypted() {
+		return nil, fmt.Errorf("voting %s does not support relayed ballots", v.UID)
+	}
+	now, err := a.chainTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.checkTime(now); err != nil {
+		return nil, err
+	}
+	if err := a.checkEligibility(ctx, v, r.Attester); err != nil && !errors.Is(err, errAllowlistUnavailable) {
+		return nil, err
+	}
+	return v, nil
+}
+
+// relayedBallot is a relay request that was attested.
+type relayedBallot struct {
+	Filename string
+	Request  *relayRequest
+	UID      eas.UID
+}
+
+// relayBallots verifies relay requests in the files and attests them by
+// delegation in transactions of the key account, with at most batchSize
+// requests in a single transaction. Requests of the same attester are
+// submitted in the order of their nonces and requests with nonces that are
+// used or that can not be used next are skipped and returned with their
+// errors.
+func (a *app) relayBallots(ctx context.Context, key *ecdsa.PrivateKey, filenames []string, batchSize int) (relayed []relayedBallot, skipped map[string]error, err error) {
+	if batchSize <= 0 {
+		return nil, nil, errors.New("batch size must be positive")
+	}
+	separator, chainID, err := a.relayDomain(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	skipped = make(map[string]error)
+	requests := make(map[common.Address][]relayedBallot)
+	for _, filename := range filenames {
+		r, err := readRelayRequest(filename)
+		if err != nil {
+			skipped[filename] = err
+			continue
+		}
+		if _, err := a.verifyRelayRequest(ctx, r, separator, chainID); err != nil {
+			skipped[filename] = err
+			continue
+		}
+		requests[r.Attester] = append(requests[r.Attester], relayedBallot{
+			Filename: filename,
+			Request:  r,
+		})
+	}
+
+	attesters := make([]common.Address, 0, len(requests))
+	for attester := range requests {
+		attesters = append(attesters, attester)
+	}
+	slices.SortFunc(attesters, common.Address.Cmp)
+
+	var queue []relayedBallot
+	for _, attester := range attesters {
+		next, err := a.attesterNonce(ctx, attester)
+		if err != nil {
+			return nil, nil, err
+		}
+		s := requests[attester]
+		slices.SortStableFunc(s, func(a, b relayedBallot) int {
+			return cmp.Compare(a.Request.Nonce, b.Request.Nonce)
+		})
+		for i, r := range s {
+			switch n := r.Request.Nonce; {
+			case n < next && i > 0 && s[i-1].Request.Nonce == n && skipped[s[i-1].Filename] == nil:
+				skipped[r.Filename] = fmt.Errorf("nonce %v is used by %s", n, s[i-1].Filename)
+			case n < next:
+				skipped[r.Filename] = fmt.Errorf("nonce %v is already used", n)
+			case n > next:
+				skipped[r.Filename] = fmt.Errorf("nonce %v is not the next nonce %v of %s", n, next, attester)
+			default:
+				queue = append(queue, r)
+				next++
+			}
+		}
+	}
+
+	opts, err := bind.NewKeyedTransactorWithChainID(key, new(big.Int).SetUint64(chainID))
+	if err != nil {
+		return nil, nil, err
+	}
+	opts.Context = ctx
+	contract := bind.NewBoundContract(a.easContractAddress, delegatedAttestationABI, a.client.Backend(), a.client.Backend(), a.client.Backend())
+	for start := 0; start < len(queue); start += batchSize {
+		batch := queue[start:min(start+batchSize, len(queue))]
+		uids, err := a.attestByDelegation(ctx, contract, opts, batch)
+		if err != nil {
+			return relayed, skipped, err
+		}
+		for i := range batch {
+			batch[i].UID = uids[i]
+		}
+		relayed = append(relayed, batch...)
+	}
+	return relayed, skipped, nil
+}
+
+// multiDelegatedAttestationRequest is the EAS contract request for
+// attestations of a single attester by delegation.
+type multiDelegatedAttestationRequest struct {
+	Schema     [32]byte
+	Data       []attestationRequestData
+	Signatures []eip712Signature
+	Attester   common.Address
+}
+
+type attestationRequestData struct {
+	Recipient      common.Address
+	ExpirationTime uint64
+	Revocable      bool
+	RefUID         [32]byte
+	Data           []byte
+	Value          *big.Int
+}
+
+type eip712Signature struct {
+	V uint8
+	R [32]byte
+	S [32]byte
+}
+
+// attestByDelegation submits requests in a single transaction and returns
+// UIDs of their attestations.
+func (a *app) attestByDelegation(ctx context.Context, contract *bind.BoundContract, opts *bind.TransactOpts, batch []relayedBallot) ([]eas.UID, error) {
+	var requests []multiDelegatedAttestationRequest
+	for _, b := range batch {
+		r := b.Request
+		if l := len(requests); l == 0 || requests[l-1].Attester != r.Attester || requests[l-1].Schema != r.Schema {
+			requests = append(requests, multiDelegatedAttestationRequest{
+				Schema:   r.Schema,
+				Attester: r.Attester,
+			})
+		}
+		m := &requests[len(requests)-1]
+		m.Data = append(m.Data, attestationRequestData{
+			Recipient:      r.Recipient,
+			ExpirationTime: r.ExpirationTime,
+			Revocable:      r.Revocable,
+			RefUID:         r.RefUID,
+			Data:           r.Data,
+			Value:          new(big.Int),
+		})
+		m.Signatures = append(m.Signatures, eip712Signature{
+			V: r.Signature.V,
+			R: [32]byte(r.Signature.R),
+			S: [32]byte(r.Signature.S),
+		})
+	}
+	tx, err := contract.Transact(opts, "multiAttestByDelegation", requests)
+	if err != nil {
+		return nil, fmt.Errorf("attest by delegation: %w", err)
+	}
+	receipt, err := bind.WaitMined(ctx, a.client.Backend(), tx)
+	if err != nil {
+		return nil, err
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return nil, fmt.Errorf("transaction %s failed", tx.Hash())
+	}
+	event := delegatedAttestationABI.Events["Attested"]
+	var uids []eas.UID
+	for _, l := range receipt.Logs {
+		if l.Address != a.easContractAddress || len(l.Topics) == 0 || l.Topics[0] != event.ID {
+			continue
+		}
+		values, err := event.Inputs.NonIndexed().Unpack(l.Data)
+		if err != nil {
+			return nil, err
+		}
+		uids = append(uids, eas.UID(values[0].([32]byte)))
+	}
+	if len(uids) != len(batch) {
+		return nil, fmt.Errorf("transaction %s has %v attestations instead of %v", tx.Hash(), len(uids), len(batch))
+	}
+	return uids, nil
+}
+
+// relayRequestFiles returns JSON files in the directory in the lexical order.
+func relayRequestFiles(dir string) ([]string, error) {
+	filenames, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(filenames)
+	return filenames, nil
+}
+
+func readRelayRequest(filename string) (*relayRequest, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var r relayRequest
+	if err := dec.Decode(&r); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", filename, err)
+	}
+	return &r, nil
+}
+
+func writeRelayRequest(filename string, r *relayRequest) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRelayBallots(t *testing.T) {
+	c := newTestChain(t, 5)
+
+	ctx := context.Background()
+
+	apps := make([]*app, 0, len(c.accounts))
+	for _, account := range c.accounts {
+		apps = append(apps, c.newApp(t, account))
+	}
+
+	now, err := apps[0].chainTime(ctx)
+	assertNilError(t, err)
+
+	v := createTestVoting(t, apps[0], &voting{
+		Title:   "Board election",
+		Choices: []string{"Alice", "Bob", "Carol"},
+		End:     now.Add(time.Hour),
+	})
+
+	dir := t.TempDir()
+	sign := func(i int, name, ranking string) *relayRequest {
+		t.Helper()
+
+		ballot, err := parseRanking(ranking, len(v.Choices))
+		assertNilError(t, err)
+		r, err := apps[i].signRelayRequest(ctx, v, ballot, c.accounts[i])
+		assertNilError(t, err)
+		assertNilError(t, writeRelayRequest(filepath.Join(dir, name), r))
+		return r
+	}
+
+	sign(1, "1.json", "1,2,3")
+	sign(2, "2.json", "2,1,3")
+	sign(3, "3a.json", "3,2,1")
+	sign(3, "3b.json", "1,2,3")
+
+	tampered := sign(4, "4.json", "1,2,3")
+	tampered.Data, err = ballotArguments.Pack(ballotSchema{{ChoiceIndex: 1, Rank: 1}})
+	assertNilError(t, err)
+	assertNilError(t, writeRelayRequest(filepath.Join(dir, "4.json"), tampered))
+
+	filenames, err := relayRequestFiles(dir)
+	assertNilError(t, err)
+
+	relayed, skipped, err := apps[0].relayBallots(ctx, c.accounts[0], filenames, 2)
+	assertNilError(t, err)
+	assertEqual(t, "relayed", len(relayed), 3)
+	assertEqual(t, "skipped", sortedInvalidFiles(skipped), []string{
+		filepath.Join(dir, "3b.json"),
+		filepath.Join(dir, "4.json"),
+	})
+
+	for _, r := range relayed {
+		attestation, err := apps[0].client.EAS.GetAttestation(ctx, r.UID)
+		assertNilError(t, err)
+		assertEqual(t, "attester", attestation.Attester, r.Request.Attester)
+	}
+
+	results, err := apps[0].calculateResults(ctx, v.UID, tallyOptions{})
+	assertNilError(t, err)
+	assertEqual(t, "ballots", results.Ballots, 3)
+	assertEqual(t, "preferences", results.Preferences, [][]int{
+		{0, 1, 2},
+		{2, 0, 2},
+		{1, 1, 0},
+	})
+
+	relayed, skipped, err = apps[0].relayBallots(ctx, c.accounts[0], filenames, 2)
+	assertNilError(t, err)
+	assertEqual(t, "relayed again", len(relayed), 0)
+	assertEqual(t, "skipped again", len(skipped), 5)
+}
@@ -0,0 +1,404 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"resenje.org/eas"
+)
+
+func resultsCommand() error {
+	cli := flag.NewFlagSet("schulzeoneas results", flag.ExitOnError)
+
+	options := newAppOptions(cli)
+	votingFlag := cli.String("voting", "", "UID of the voting")
+	formatFlag := cli.String("format", "text", "Output format: text, json or csv")
+	untilFlag := cli.String("until", "", "Do not count ballots attested at or after this RFC 3339 time")
+	untilBlockFlag := cli.Uint64("until-block", 0, "Do not count ballots attested after this block")
+	allowlistFlag := cli.String("allowlist", "", "File with addresses of the voting allowlist, one per line")
+	transcriptFlag := cli.String("transcript", "", "Decryption transcript file of a voting with encrypted ballots")
+
+	if err := cli.Parse(os.Args[2:]); err != nil {
+		log.Println(err)
+		cli.Usage()
+	}
+
+	if *votingFlag == "" {
+		return errors.New("voting UID is required")
+	}
+	if !isHexUID(*votingFlag) {
+		return fmt.Errorf("invalid voting UID %q", *votingFlag)
+	}
+	votingUID := eas.HexDecodeUID(*votingFlag)
+
+	o := tallyOptions{
+		UntilBlock: *untilBlockFlag,
+	}
+	if *untilFlag != "" {
+		t, err := time.Parse(time.RFC3339, *untilFlag)
+		if err != nil {
+			return fmt.Errorf("until time: %w", err)
+		}
+		o.Until = t
+	}
+	if *transcriptFlag != "" {
+		t, err := readDecryptionTranscript(*transcriptFlag)
+		if err != nil {
+			return err
+		}
+		o.Transcript = t
+	}
+
+	write, err := resultsWriter(*formatFlag)
+	if err != nil {
+		return err
+	}
+
+	a, err := options.newApp()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	if err := a.setReadOnlyClient(ctx); err != nil {
+		return err
+	}
+
+	if *allowlistFlag != "" {
+		voting, err := a.getVoting(ctx, votingUID)
+		if err != nil {
+			return err
+		}
+		if err := a.importAllowlist(ctx, voting, *allowlistFlag); err != nil {
+			return err
+		}
+	}
+
+	r, err := a.calculateResults(ctx, votingUID, o)
+	if err != nil {
+		return err
+	}
+
+	return write(os.Stdout, r)
+}
+
+// resultsWriter returns the function that writes results in the format.
+func resultsWriter(format string) (func(io.Writer, *votingResults) error, error) {
+	switch format {
+	case "text":
+		return writeResultsText, nil
+	case "json":
+		return writeResultsJSON, nil
+	case "csv":
+		return writeResultsCSV, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+type resultsJSON struct {
+	Voting              eas.UID            `json:"voting"`
+	Title               string             `json:"title"`
+	Description         string             `json:"description,omitempty"`
+	Choices             []string           `json:"choices"`
+	Tie                 bool               `json:"tie"`
+	Attestations        int                `json:"attestations"`
+	Ballots             int                `json:"ballots"`
+	Weight              *int               `json:"weight,omitempty"`
+	Results             []resultJSON       `json:"results"`
+	Preferences         [][]int            `json:"preferences"`
+	Strengths           [][]int            `json:"strengths"`
+	WeightedPreferences [][]int            `json:"weightedPreferences,omitempty"`
+	ZeroWeight          []ballotJSON       `json:"zeroWeight,omitempty"`
+	Delegations         []ballotDelegation `json:"delegations,omitempty"`
+	DelegationCycles    [][]common.Address `json:"delegationCycles,omitempty"`
+	Ineligible          []ballotJSON       `json:"ineligible"`
+	Early               []ballotJSON       `json:"early"`
+	Late                []ballotJSON       `json:"late"`
+	Revoked             []ballotJSON       `json:"revoked"`
+	Unrevealed          []ballotJSON       `json:"unrevealed"`
+	Undecryptable       []ballotJSON       `json:"undecryptable"`
+	Invalid             []ballotJSON       `json:"invalid"`
+	Superseded          []ballotJSON       `json:"superseded"`
+}
+
+type resultJSON struct {
+	Choice    string `json:"choice"`
+	Index     int    `json:"index"`
+	Wins      int    `json:"wins"`
+	Strength  int    `json:"strength"`
+	Advantage int    `json:"advantage"`
+}
+
+type ballotJSON struct {
+	UID      eas.UID        `json:"uid"`
+	Attester common.Address `json:"attester"`
+	Time     time.Time      `json:"time"`
+	Block    uint64         `json:"block"`
+	LogIndex uint           `json:"logIndex"`
+	Revoked  *time.Time     `json:"revoked,omitempty"`
+	// UID of the later ballot of the same attester.
+	SupersededBy *eas.UID `json:"supersededBy,omitempty"`
+	// Reason why the ballot is not valid.
+	Reason string `json:"reason,omitempty"`
+}
+
+func newBallotJSON(r ballotRecord) ballotJSON {
+	b := ballotJSON{
+		UID:      r.UID,
+		Attester: r.Attester,
+		Time:     r.Time.UTC(),
+		Block:    r.Block,
+		LogIndex: r.LogIndex,
+	}
+	if !r.RevocationTime.IsZero() {
+		b.Revoked = eas.Ptr(r.RevocationTime.UTC())
+	}
+	return b
+}
+
+func newBallotsJSON(records []ballotRecord) []ballotJSON {
+	s := make([]ballotJSON, 0, len(records))
+	for _, r := range records {
+		s = append(s, newBallotJSON(r))
+	}
+	return s
+}
+
+func newSupersededBallotsJSON(ballots []supersededBallot) []ballotJSON {
+	s := make([]ballotJSON, 0, len(ballots))
+	for _, b := range ballots {
+		j := newBallotJSON(b.ballotRecord)
+		j.SupersededBy = eas.Ptr(b.SupersededBy)
+		s = append(s, j)
+	}
+	return s
+}
+
+func newInvalidBallotsJSON(ballots []invalidBallot) []ballotJSON {
+	s := make([]ballotJSON, 0, len(ballots))
+	for _, b := range ballots {
+		j := newBallotJSON(b.ballotRecord)
+		j.Reason = b.Reason
+		s = append(s, j)
+	}
+	return s
+}
+
+func writeResultsJSON(w io.Writer, r *votingResults) error {
+	results := make([]resultJSON, 0, len(r.Results))
+	for _, r := range r.Results {
+		results = append(results, resultJSON{
+			Choice:    r.Choice,
+			Index:     r.Index,
+			Wins:      r.Wins,
+			Strength:  r.Strength,
+			Advantage: r.Advantage,
+		})
+	}
+	var weight *int
+	var zeroWeight []ballotJSON
+	if r.WeightedPreferences != nil {
+		weight = eas.Ptr(r.Weight)
+	}
+	if r.Voting.isWeighted() {
+		zeroWeight = newBallotsJSON(r.ZeroWeight)
+	}
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(resultsJSON{
+		Voting:              r.VotingUID,
+		Title:               r.Voting.Title,
+		Description:         r.Voting.Description,
+		Choices:             r.Voting.Choices,
+		Tie:                 r.Tie,
+		Attestations:        r.Attestations,
+		Ballots:             r.Ballots,
+		Weight:              weight,
+		Results:             results,
+		Preferences:         r.Preferences,
+		Strengths:           r.Strengths,
+		WeightedPreferences: r.WeightedPreferences,
+		ZeroWeight:          zeroWeight,
+		Delegations:         r.Delegations,
+		DelegationCycles:    r.DelegationCycles,
+		Ineligible:          newBallotsJSON(r.Ineligible),
+		Early:               newBallotsJSON(r.Early),
+		Late:                newBallotsJSON(r.Late),
+		Revoked:             newBallotsJSON(r.Revoked),
+		Unrevealed:          newBallotsJSON(r.Unrevealed),
+		Undecryptable:       newBallotsJSON(r.Undecryptable),
+		Invalid:             newInvalidBallotsJSON(r.Invalid),
+		Superseded:          newSupersededBallotsJSON(r.Superseded),
+	})
+}
+
+func writeResultsCSV(w io.Writer, r *votingResults) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"choice", "index", "wins", "strength", "advantage"}); err != nil {
+		return err
+	}
+	for _, r := range r.Results {
+		if err := cw.Write([]string{
+			r.Choice,
+			strconv.Itoa(r.Index),
+			strconv.Itoa(r.Wins),
+			strconv.Itoa(r.Strength),
+			strconv.Itoa(r.Advantage),
+		}); err != nil {
+			return err
+		}
+	}
+	// totals are written as a separate table after an empty line
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return err
+	}
+	totals := [][]string{
+		{"total", "count"},
+		{"attestations", strconv.Itoa(r.Attestations)},
+		{"counted", strconv.Itoa(r.Ballots)},
+		{"superseded", strconv.Itoa(len(r.Superseded))},
+		{"not counted", strconv.Itoa(r.notCounted())},
+	}
+	if r.WeightedPreferences != nil {
+		totals = append(totals, []string{"weight", strconv.Itoa(r.Weight)})
+	}
+	if r.Voting.isWeighted() {
+		totals = append(totals, []string{"zero weight", strconv.Itoa(len(r.ZeroWeight))})
+	}
+	return cw.WriteAll(totals)
+}
+
+func writeResultsText(w io.Writer, r *votingResults) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Voting:\t%s\n", r.VotingUID)
+	fmt.Fprintf(tw, "Title:\t%s\n", r.Voting.Title)
+	fmt.Fprintf(tw, "Ballots:\t%v\n", r.Ballots)
+	if r.WeightedPreferences != nil {
+		fmt.Fprintf(tw, "Weight:\t%v\n", r.Weight)
+	}
+	if r.Voting.isWeighted() {
+		fmt.Fprintf(tw, "Weight token:\t%s at block %v\n", r.Voting.WeightToken, r.Voting.WeightBlock)
+	}
+	fmt.Fprintf(tw, "Attestations:\t%v\n", r.Attestations)
+	fmt.Fprintf(tw, "Superseded:\t%v\n", len(r.Superseded))
+	fmt.Fprintf(tw, "Not counted:\t%v\n", r.notCounted())
+	fmt.Fprintf(tw, "Revoked:\t%v\n", len(r.Revoked))
+	fmt.Fprintf(tw, "Tie:\t%v\n", r.Tie)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Choice\tIndex\tWins\tStrength\tAdvantage")
+	for _, r := range r.Results {
+		fmt.Fprintf(tw, "%s\t%v\t%v\t%v\t%v\n", r.Choice, r.Index, r.Wins, r.Strength, r.Advantage)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(r.Delegations) > 0 {
+		fmt.Fprintf(w, "\nBallots with delegated votes:\n\n")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "Ballot\tAttester\tWeight\tDelegated weight\tDelegators")
+		for _, d := range r.Delegations {
+			fmt.Fprintf(tw, "%s\t%s\t%v\t%v\t%v\n", d.Ballot, d.Attester, d.Weight, d.DelegatedWeight, len(d.Delegators))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if err := writeBallotsText(w, "Counted ballots with zero weight of accounts with less than one whole token", r.ZeroWeight); err != nil {
+		return err
+	}
+	if len(r.DelegationCycles) > 0 {
+		fmt.Fprintf(w, "\nNot counted delegation cycles:\n\n")
+		for _, c := range r.DelegationCycles {
+			fmt.Fprintln(w, formatDelegationCycle(c))
+		}
+	}
+	if err := writeBallotsText(w, "Not counted ballots of ineligible accounts", r.Ineligible); err != nil {
+		return err
+	}
+	if err := writeBallotsText(w, "Not counted ballots attested before the voting start", r.Early); err != nil {
+		return err
+	}
+	if err := writeBallotsText(w, "Not counted ballots attested after the voting end", r.Late); err != nil {
+		return err
+	}
+	if err := writeBallotsText(w, "Revoked ballots", r.Revoked); err != nil {
+		return err
+	}
+	if err := writeBallotsText(w, "Not counted secret ballots that were not revealed", r.Unrevealed); err != nil {
+		return err
+	}
+	if err := writeBallotsText(w, "Not counted encrypted ballots that could not be decrypted", r.Undecryptable); err != nil {
+		return err
+	}
+	if len(r.Invalid) > 0 {
+		fmt.Fprintf(w, "\nNot counted invalid ballots:\n\n")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "Ballot\tAttester\tTime\tBlock\tLog index\tReason")
+		for _, b := range r.Invalid {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%v\t%v\t%s\n", b.UID, b.Attester, b.Time.UTC().Format(time.RFC3339), b.Block, b.LogIndex, b.Reason)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if len(r.Superseded) == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, "\nSuperseded ballots:\n\n")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Ballot\tAttester\tTime\tBlock\tLog index\tSuperseded by")
+	for _, b := range r.Superseded {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%v\t%v\t%s\n", b.UID, b.Attester, b.Time.UTC().Format(time.RFC3339), b.Block, b.LogIndex, b.SupersededBy)
+	}
+	return tw.Flush()
+}
+
+func writeBallotsText(w io.Writer, title string, records []ballotRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, "\n%s:\n\n", title)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Ballot\tAttester\tTime\tBlock\tLog index")
+	for _, r := range records {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%v\t%v\n", r.UID, r.Attester, r.Time.UTC().Format(time.RFC3339), r.Block, r.LogIndex)
+	}
+	return tw.Flush()
+}
+
+// formatDelegationCycle returns addresses in the cycle joined by arrows in the
+// delegation order, ending with the first address.
+func formatDelegationCycle(cycle []common.Address) string {
+	s := make([]string, 0, len(cycle)+1)
+	for _, a := range cycle {
+		s = append(s, a.Hex())
+	}
+	s = append(s, cycle[0].Hex())
+	return strings.Join(s, " -> ")
+}
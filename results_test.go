@@ -0,0 +1,40 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"resenje.org/schulze"
+)
+
+func TestWriteResultsCSV(t *testing.T) {
+	r := &votingResults{
+		Voting: &voting{Choices: []string{"Pizza", "Pasta"}},
+		Results: []schulze.Result[string]{
+			{Choice: "Pasta", Index: 1, Wins: 1, Strength: 2, Advantage: 1},
+			{Choice: "Pizza", Index: 0},
+		},
+		Attestations: 5,
+		Ballots:      2,
+		Superseded:   []supersededBallot{{}},
+		Late:         []ballotRecord{{}, {}},
+	}
+
+	var b strings.Builder
+	assertNilError(t, writeResultsCSV(&b, r))
+	assertEqual(t, "csv", b.String(), `choice,index,wins,strength,advantage
+Pasta,1,1,2,1
+Pizza,0,0,0,0
+
+total,count
+attestations,5
+counted,2
+superseded,1
+not counted,2
+`)
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"resenje.org/eas"
+)
+
+func revealCommand() error {
+	cli := flag.NewFlagSet("schulzeoneas reveal", flag.ExitOnError)
+
+	options := newAppOptions(cli)
+	account := newAccountOptions(cli)
+	votingFlag := cli.String("voting", "", "UID of the voting")
+
+	if err := cli.Parse(os.Args[2:]); err != nil {
+		log.Println(err)
+		cli.Usage()
+	}
+
+	if *votingFlag == "" {
+		return errors.New("voting UID is required")
+	}
+	if !isHexUID(*votingFlag) {
+		return fmt.Errorf("invalid voting UID %q", *votingFlag)
+	}
+	votingUID := eas.HexDecodeUID(*votingFlag)
+
+	a, err := options.newApp()
+	if err != nil {
+		return err
+	}
+
+	if err := account.unlock(a); err != nil {
+		return err
+	}
+
+	log.Println("Wallet address:", a.client.Address())
+
+	ctx := context.Background()
+
+	voting, err := a.getVoting(ctx, votingUID)
+	if err != nil {
+		return err
+	}
+
+	tx, wait, err := a.revealBallot(ctx, voting)
+	if err != nil {
+		return err
+	}
+	log.Println("Waiting reveal attestation:", tx.Hash())
+	r, err := wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(r.UID)
+
+	return nil
+}
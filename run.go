@@ -7,40 +7,107 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
 	"resenje.org/eas"
 )
 
-const (
-	defaultEndpoint           = "https://ethereum-sepolia-rpc.publicnode.com/"
-	defaultEASContractAddress = "0xC2679fBD37d54388Ce493F1DB75320D236e1815e"
-	defaultConfigUID          = "0x95061c892e6fad7afc7dc9d625d39e652ba221efeb1afa5e1dd4266c11a145c8"
-)
-
 func runApp() error {
 	cli := flag.NewFlagSet("schulzeoneas", flag.ExitOnError)
 
-	configDirFlag := cli.String("config-dir", "", "Local configuration directory")
-	endpointFlag := cli.String("rpc-endpoint", defaultEndpoint, "Ethereum RPC URL")
-	easContractAddressFlag := cli.String("eas-contract-address", defaultEASContractAddress, "Ethereum Attestation Service EAS contract address")
-	configUIDFlag := cli.String("uid", defaultConfigUID, "UID of the SchulzeOnEAS config attestation")
+	options := newAppOptions(cli)
 
 	if err := cli.Parse(os.Args[1:]); err != nil {
 		log.Println(err)
 		cli.Usage()
 	}
 
-	configDir := *configDirFlag
+	a, err := options.newApp()
+	if err != nil {
+		return err
+	}
+
+	if a.configUID.IsZero() {
+		a.render(a.newConfigUIDForm(nil, a.network, func(configUID eas.UID) {
+			a.configUID = configUID
+			a.render(a.newSetAccountOptions())
+		}))
+	} else {
+		a.render(a.newSetAccountOptions())
+	}
+	return a.Run()
+}
+
+// appOptions holds command line flags that are common to the interactive
+// application and to the commands that use the same local configuration.
+type appOptions struct {
+	configDir          *string
+	network            *string
+	endpoint           *string
+	easContractAddress *string
+	configUID          *string
+}
+
+func newAppOptions(cli *flag.FlagSet) *appOptions {
+	names := make([]string, 0, len(builtinNetworkProfiles))
+	for _, p := range builtinNetworkProfiles {
+		names = append(names, p.Name)
+	}
+	return &appOptions{
+		configDir:          cli.String("config-dir", "", "Local configuration directory"),
+		network:            cli.String("network", defaultNetwork, "Network profile: "+strings.Join(names, ", ")+" or a profile added by the user"),
+		endpoint:           cli.String("rpc-endpoint", "", "Ethereum RPC URL, overrides the network profile"),
+		easContractAddress: cli.String("eas-contract-address", "", "Ethereum Attestation Service EAS contract address, overrides the network profile"),
+		configUID:          cli.String("uid", "", "UID of the SchulzeOnEAS config attestation, overrides the network profile"),
+	}
+}
+
+func (o *appOptions) newApp() (*app, error) {
+	configDir := *o.configDir
 	if configDir == "" {
 		dir, err := os.UserConfigDir()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		configDir = dir
 	}
 
-	return newApp(configDir, *endpointFlag, common.HexToAddress(*easContractAddressFlag), eas.HexDecodeUID(*configUIDFlag))
+	profiles, err := loadNetworkProfiles(appDataDir(configDir))
+	if err != nil {
+		return nil, err
+	}
+	p, err := findNetworkProfile(profiles, *o.network)
+	if err != nil {
+		return nil, err
+	}
+	if *o.endpoint != "" {
+		p.Endpoint = *o.endpoint
+	}
+	if *o.easContractAddress != "" {
+		if !common.IsHexAddress(*o.easContractAddress) {
+			return nil, fmt.Errorf("invalid eas contract address %q", *o.easContractAddress)
+		}
+		p.EASContractAddress = common.HexToAddress(*o.easContractAddress)
+	}
+	if *o.configUID != "" {
+		if !isHexUID(*o.configUID) {
+			return nil, fmt.Errorf("invalid config uid %q", *o.configUID)
+		}
+		p.ConfigUID = eas.HexDecodeUID(*o.configUID)
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+
+	a, err := newApp(configDir, p.Endpoint, p.EASContractAddress, p.ConfigUID)
+	if err != nil {
+		return nil, err
+	}
+	a.network = p.Name
+	a.chainID = p.ChainID
+	return a, nil
 }
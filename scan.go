@@ -0,0 +1,257 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// blockScanner calls a function for consecutive block ranges concurrently.
+// Ranges are split in half when the provider rejects them as too large and
+// grown when they contain only a few events. Calls that the provider rejects
+// because of its rate limit or that fail with a transient error are retried
+// with an exponential backoff.
+type blockScanner struct {
+	// Number of ranges that are processed concurrently.
+	workers int
+	// Number of blocks in the first ranges.
+	initialSize uint64
+	// Maximal number of blocks in a range.
+	maxSize uint64
+	// Ranges with fewer events than this number double the size of the
+	// following ranges.
+	sparseEvents int
+	// Number of retries of a rate limited or failed call before the scan
+	// fails.
+	retries int
+	// Delay before the first retry that is doubled for every next retry.
+	backoff time.Duration
+}
+
+func newBlockScanner() *blockScanner {
+	return &blockScanner{
+		workers:      4,
+		initialSize:  1000,
+		maxSize:      50000,
+		sparseEvents: 100,
+		retries:      5,
+		backoff:      500 * time.Millisecond,
+	}
+}
+
+type blockRange struct {
+	start, end uint64
+}
+
+// scan calls the function for block ranges that cover all blocks between
+// start and end blocks, both inclusive. The function is called concurrently
+// and it must return the number of events that it found in the range. Calls
+// for the same range are repeated if the function returns a rate limit or a
+// range limit error, so the function should not keep any results from a
+// failed call. Any other error stops the scan.
+func (s *blockScanner) scan(ctx context.Context, start, end uint64, f func(ctx context.Context, start, end uint64) (int, error)) error {
+	if start > end {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		cond    = sync.NewCond(&mu)
+		next    = start
+		done    bool
+		size    = s.initialSize
+		pending []blockRange
+		active  int
+		scanErr error
+	)
+
+	// take returns the next range to process, waiting for active ranges if
+	// they may be split, and false when there are no more ranges.
+	take := func() (blockRange, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		for {
+			if scanErr != nil {
+				return blockRange{}, false
+			}
+			if l := len(pending); l > 0 {
+				r := pending[l-1]
+				pending = pending[:l-1]
+				active++
+				return r, true
+			}
+			if !done {
+				r := blockRange{start: next, end: end}
+				if end-next >= size {
+					r.end = next + size - 1
+				}
+				if r.end == end {
+					done = true
+				} else {
+					next = r.end + 1
+				}
+				active++
+				return r, true
+			}
+			if active == 0 {
+				return blockRange{}, false
+			}
+			cond.Wait()
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for r, ok := take(); ok; r, ok = take() {
+				n, err := s.call(ctx, r, f)
+
+				mu.Lock()
+				active--
+				switch {
+				case err == nil:
+					if n < s.sparseEvents {
+						size = min(size*2, s.maxSize)
+					}
+				case isRangeLimitError(err) && r.end > r.start:
+					middle := r.start + (r.end-r.start)/2
+					pending = append(pending, blockRange{start: middle + 1, end: r.end}, blockRange{start: r.start, end: middle})
+					size = max(1, min(size, middle-r.start+1))
+				default:
+					if scanErr == nil {
+						scanErr = err
+						cancel()
+					}
+				}
+				cond.Broadcast()
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return scanErr
+}
+
+// call calls the function for the range and retries it if it fails with a
+// rate limit or a transient error.
+func (s *blockScanner) call(ctx context.Context, r blockRange, f func(ctx context.Context, start, end uint64) (int, error)) (int, error) {
+	backoff := s.backoff
+	for retry := 0; ; retry++ {
+		n, err := f(ctx, r.start, r.end)
+		if err == nil || isRangeLimitError(err) || !(isRateLimitError(err) || isTransientError(err)) || retry >= s.retries || ctx.Err() != nil {
+			return n, err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// rangeLimitErrorMessages are parts of error messages that RPC providers
+// return when the block range of a logs query is too large or when the query
+// would return too many results.
+var rangeLimitErrorMessages = []string{
+	"query returned more than",
+	"too many results",
+	"range too large",
+	"range is too large",
+	"response size exceeded",
+	"response size should not greater than",
+	"exceed maximum block range",
+	"results exceed",
+}
+
+func isRangeLimitError(err error) bool {
+	m := strings.ToLower(err.Error())
+	for _, s := range rangeLimitErrorMessages {
+		if strings.Contains(m, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitErrorMessages are parts of error messages that RPC providers
+// return when they reject requests that exceed their rate limits.
+var rateLimitErrorMessages = []string{
+	"rate limit",
+	"too many requests",
+	"request limit",
+	"exceeded its compute units",
+	"capacity exceeded",
+}
+
+func isRateLimitError(err error) bool {
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	m := strings.ToLower(err.Error())
+	for _, s := range rateLimitErrorMessages {
+		if strings.Contains(m, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// transientErrorMessages are parts of error messages of closed connections
+// and of errors that RPC providers return when a node behind a load balancer
+// is not yet synchronized with the requested blocks.
+var transientErrorMessages = []string{
+	"connection reset",
+	"header not found",
+}
+
+// isTransientError returns true if the error is caused by a failure of the
+// provider or the network, and not by the request, so that the same request
+// may succeed later.
+func isTransientError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		switch httpErr.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	m := strings.ToLower(err.Error())
+	for _, s := range transientErrorMessages {
+		if strings.Contains(m, s) {
+			return true
+		}
+	}
+	return false
+}
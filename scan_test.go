@@ -0,0 +1,234 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestBlockScanner(t *testing.T) {
+	errPermanent := errors.New("invalid schema")
+
+	for _, tc := range []struct {
+		name       string
+		start, end uint64
+		// maximal number of blocks that the provider accepts in a range
+		limit uint64
+		// errors of the first calls
+		errors    []error
+		wantErr   error
+		wantCalls int
+	}{
+		{
+			name:      "single range",
+			start:     10,
+			end:       13,
+			limit:     100,
+			wantCalls: 1,
+		},
+		{
+			name:  "growing ranges",
+			start: 0,
+			end:   9999,
+			limit: 10000,
+		},
+		{
+			name:  "split ranges",
+			start: 5,
+			end:   9999,
+			limit: 3,
+		},
+		{
+			name:  "single block ranges",
+			start: 0,
+			end:   99,
+			limit: 1,
+		},
+		{
+			name:      "rate limited",
+			start:     0,
+			end:       3,
+			limit:     100,
+			errors:    []error{errors.New("429 Too Many Requests"), rpc.HTTPError{StatusCode: http.StatusTooManyRequests, Status: "429"}},
+			wantCalls: 3,
+		},
+		{
+			name:  "rate limited after retries",
+			start: 0,
+			end:   3,
+			limit: 100,
+			errors: []error{
+				errors.New("rate limit exceeded"),
+				errors.New("rate limit exceeded"),
+				errors.New("rate limit exceeded"),
+				errors.New("rate limit exceeded"),
+			},
+			wantErr:   errors.New("rate limit exceeded"),
+			wantCalls: 4,
+		},
+		{
+			name:  "transient errors",
+			start: 0,
+			end:   3,
+			limit: 100,
+			errors: []error{
+				rpc.HTTPError{StatusCode: http.StatusBadGateway, Status: "502 Bad Gateway"},
+				io.EOF,
+				errors.New("header not found"),
+			},
+			wantCalls: 4,
+		},
+		{
+			name:      "permanent error",
+			start:     0,
+			end:       3,
+			limit:     100,
+			errors:    []error{errPermanent},
+			wantErr:   errPermanent,
+			wantCalls: 1,
+		},
+		{
+			name:    "block with too many results",
+			start:   0,
+			end:     9,
+			limit:   0,
+			wantErr: errors.New("query returned more than 10000 results"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &blockScanner{
+				workers:      4,
+				initialSize:  4,
+				maxSize:      1000,
+				sparseEvents: 1,
+				retries:      3,
+				backoff:      time.Millisecond,
+			}
+
+			var (
+				mu      sync.Mutex
+				calls   int
+				scanned []blockRange
+			)
+			err := s.scan(context.Background(), tc.start, tc.end, func(ctx context.Context, start, end uint64) (int, error) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				calls++
+				if calls <= len(tc.errors) {
+					return 0, tc.errors[calls-1]
+				}
+				if end-start+1 > tc.limit {
+					return 0, fmt.Errorf("query returned more than %v results", 10000)
+				}
+				scanned = append(scanned, blockRange{start: start, end: end})
+				return 0, nil
+			})
+			if tc.wantErr != nil {
+				if err == nil || err.Error() != tc.wantErr.Error() {
+					t.Fatalf("got error %v, want %v", err, tc.wantErr)
+				}
+			} else {
+				assertNilError(t, err)
+			}
+			if tc.wantCalls > 0 {
+				assertEqual(t, "calls", calls, tc.wantCalls)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+
+			// scanned ranges must cover all blocks exactly once
+			slices.SortFunc(scanned, func(a, b blockRange) int {
+				return int(a.start) - int(b.start)
+			})
+			next := tc.start
+			for _, r := range scanned {
+				if r.start != next {
+					t.Fatalf("got range %v-%v, want range from %v", r.start, r.end, next)
+				}
+				if r.end-r.start+1 > tc.limit {
+					t.Errorf("got range %v-%v larger than %v blocks", r.start, r.end, tc.limit)
+				}
+				next = r.end + 1
+			}
+			assertEqual(t, "end", next-1, tc.end)
+		})
+	}
+}
+
+func TestBlockScanner_canceled(t *testing.T) {
+	s := newBlockScanner()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	var calls int
+	err := s.scan(ctx, 0, 0, func(ctx context.Context, start, end uint64) (int, error) {
+		calls++
+		return 0, ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	assertEqual(t, "calls", calls, 1)
+	if d := time.Since(start); d > s.backoff {
+		t.Errorf("canceled scan took %v", d)
+	}
+}
+
+func TestIsRateLimitError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{err: rpc.HTTPError{StatusCode: http.StatusTooManyRequests, Status: "429 Too Many Requests"}, want: true},
+		{err: fmt.Errorf("filter logs: %w", rpc.HTTPError{StatusCode: http.StatusTooManyRequests}), want: true},
+		{err: errors.New("Your app has exceeded its compute units per second capacity"), want: true},
+		{err: errors.New("daily request limit reached"), want: true},
+		{err: errors.New("invalid argument 0: hex string has length 2, want 64 for common.Hash"), want: false},
+		{err: context.Canceled, want: false},
+	} {
+		assertEqual(t, tc.err.Error(), isRateLimitError(tc.err), tc.want)
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{err: rpc.HTTPError{StatusCode: http.StatusBadGateway, Status: "502 Bad Gateway"}, want: true},
+		{err: rpc.HTTPError{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"}, want: true},
+		{err: fmt.Errorf("filter logs: %w", rpc.HTTPError{StatusCode: http.StatusGatewayTimeout}), want: true},
+		{err: &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, want: true},
+		{err: fmt.Errorf("post: %w", io.EOF), want: true},
+		{err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, want: true},
+		{err: errors.New("read tcp 127.0.0.1:8545: connection reset by peer"), want: true},
+		{err: errors.New("header not found"), want: true},
+		{err: rpc.HTTPError{StatusCode: http.StatusBadRequest, Status: "400 Bad Request"}, want: false},
+		{err: rpc.HTTPError{StatusCode: http.StatusTooManyRequests, Status: "429 Too Many Requests"}, want: false},
+		{err: errors.New("invalid argument 0: hex string has length 2, want 64 for common.Hash"), want: false},
+		{err: context.Canceled, want: false},
+		{err: context.DeadlineExceeded, want: false},
+	} {
+		assertEqual(t, tc.err.Error(), isTransientError(tc.err), tc.want)
+	}
+}
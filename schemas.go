@@ -6,6 +6,9 @@
 package main
 
 import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
 	"resenje.org/eas"
 )
 
@@ -14,16 +17,196 @@ type votingSchema struct {
 	Choices []string `abi:"choices"`
 }
 
+// votingSchemaV2 extends the voting with a description and a time window in
+// which ballots are accepted. Times are unix timestamps in seconds and zero
+// value leaves the window open on that side.
+type votingSchemaV2 struct {
+	Title       string   `abi:"title"`
+	Description string   `abi:"description"`
+	Choices     []string `abi:"choices"`
+	StartTime   uint64   `abi:"startTime"`
+	EndTime     uint64   `abi:"endTime"`
+}
+
+// votingSchemaV3 extends the votingSchemaV2 with options that enable
+// optional features of the voting. Every option has a unique name and an abi
+// encoded value, as described by votingOptionArguments, so that features are
+// added without a new voting schema.
+type votingSchemaV3 struct {
+	Title       string         `abi:"title"`
+	Description string         `abi:"description"`
+	Choices     []string       `abi:"choices"`
+	StartTime   uint64         `abi:"startTime"`
+	EndTime     uint64         `abi:"endTime"`
+	Options     []votingOption `abi:"options"`
+}
+
+type votingOption struct {
+	Name  string `abi:"name"`
+	Value []byte `abi:"value"`
+}
+
+// delegationSchema delegates the vote of the attester to the delegate. The
+// delegation references the voting UID to apply only to that voting or the
+// config UID to apply to all votings of the configuration.
+type delegationSchema struct {
+	Delegate common.Address `abi:"delegate"`
+}
+
+func unixTime(t uint64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(t), 0)
+}
+
+func unixTimestamp(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.Unix())
+}
+
 type ballotSchema []ballotRanking
 
 type ballotRanking struct {
-	ChoiceIndex uint16 `abi:"choiceIndex"`
-	Rank        uint16 `abi:"rank"`
+	ChoiceIndex uint16 `abi:"choiceIndex" json:"choiceIndex"`
+	Rank        uint16 `abi:"rank" json:"rank"`
+}
+
+// ballotCommitmentSchema is a secret ballot in the commit-reveal mode. The
+// commitment is the hash of the ballot, the voting, the attester and a random
+// salt, as computed by ballotCommitment.
+type ballotCommitmentSchema struct {
+	Commitment [32]byte `abi:"commitment"`
+}
+
+// ballotRevealSchema reveals the ballot and the salt of the commitment that
+// the reveal attestation references.
+type ballotRevealSchema struct {
+	Ballot ballotSchema `abi:"ballot"`
+	Salt   [32]byte     `abi:"salt"`
+}
+
+// encryptedBallotSchema is a ballot encrypted to the tallier public key of
+// the voting, as constructed by encryptBallot.
+type encryptedBallotSchema struct {
+	Ciphertext []byte `abi:"ciphertext"`
+}
+
+// pairwiseBallotSchema is a ballot of a voting with homomorphic tallying,
+// as constructed by encryptPairwiseBallot. Ciphertexts are exponential
+// ElGamal encryptions of preferences of every ordered pair of choices and the
+// proof shows that they are preferences of a ranking.
+type pairwiseBallotSchema struct {
+	Ciphertexts [][]byte `abi:"ciphertexts"`
+	Proof       []byte   `abi:"proof"`
 }
 
+// keyCeremonySchema defines trustees that jointly generate the tallier key
+// of encrypted ballots, so that ballots can be decrypted only by at least the
+// threshold number of trustees. Trustees are identified by their compressed
+// public keys and their indexes in the list start from one. Dealings are
+// accepted until the dealing end time and complaints until the complaint end
+// time, after which the key is final.
+type keyCeremonySchema struct {
+	TrusteePublicKeys [][]byte `abi:"trusteePublicKeys"`
+	Threshold         uint16   `abi:"threshold"`
+	DealingEndTime    uint64   `abi:"dealingEndTime"`
+	ComplaintEndTime  uint64   `abi:"complaintEndTime"`
+}
+
+// keyDealingSchema is the contribution of a trustee to the key ceremony that
+// it references. Commitments are compressed points a·G of the coefficients
+// of the trustee's secret polynomial and encrypted shares are values of the
+// polynomial at trustee indexes, each encrypted to the public key of the
+// trustee with the same index.
+type keyDealingSchema struct {
+	Commitments     [][]byte `abi:"commitments"`
+	EncryptedShares [][]byte `abi:"encryptedShares"`
+}
+
+// decryptionProofSchema is the decryptionProof in attestations.
+type decryptionProofSchema struct {
+	A []byte   `abi:"a"`
+	B []byte   `abi:"b"`
+	Z [32]byte `abi:"z"`
+}
+
+// keyComplaintSchema discloses the shared secret of the encrypted share that
+// the attester received in the dealing that the complaint references, so
+// that anyone can check that the share is invalid and disqualify the dealer.
+type keyComplaintSchema struct {
+	SharedSecret []byte                `abi:"sharedSecret"`
+	Proof        decryptionProofSchema `abi:"proof"`
+}
+
+// partialDecryptionSchema holds partial decryptions of encrypted ballots of
+// the voting that it references, computed by a trustee with its share of the
+// key from the key ceremony.
+type partialDecryptionSchema struct {
+	KeyCeremonyUID eas.UID                   `abi:"keyCeremonyUID"`
+	Decryptions    []ballotPartialDecryption `abi:"decryptions"`
+}
+
+type ballotPartialDecryption struct {
+	BallotUID eas.UID               `abi:"ballotUID"`
+	Share     []byte                `abi:"share"`
+	Proof     decryptionProofSchema `abi:"proof"`
+}
+
+// aggregatePartialDecryptionSchema holds partial decryptions of sums of
+// encrypted pairwise preferences of counted ballots of the voting with
+// homomorphic tallying that it references, in the order of ciphertexts of the
+// pairwiseAggregate. Shares and proofs of sums without ballots are empty.
+type aggregatePartialDecryptionSchema struct {
+	KeyCeremonyUID eas.UID                 `abi:"keyCeremonyUID"`
+	Shares         [][]byte                `abi:"shares"`
+	Proofs         []decryptionProofSchema `abi:"proofs"`
+}
+
+// configSchema references schemas of the voting and ballot attestations.
+// Schemas that were added later are listed by their names in the
+// schemaDefinitions, so that new schemas do not change the config schema.
 type configSchema struct {
-	VotingSchemaUID   eas.UID `abi:"votingSchemaUID"`
-	VotingSchemaBlock uint64  `abi:"votingSchemaBlock"`
-	BallotSchemaUID   eas.UID `abi:"ballotSchemaUID"`
-	BallotSchemaBlock uint64  `abi:"ballotSchemaBlock"`
+	VotingSchemaUID   eas.UID       `abi:"votingSchemaUID"`
+	VotingSchemaBlock uint64        `abi:"votingSchemaBlock"`
+	BallotSchemaUID   eas.UID       `abi:"ballotSchemaUID"`
+	BallotSchemaBlock uint64        `abi:"ballotSchemaBlock"`
+	Schemas           []namedSchema `abi:"schemas"`
+}
+
+// namedSchema is a registered schema and the block in which it was
+// registered.
+type namedSchema struct {
+	Name  string  `abi:"name"`
+	UID   eas.UID `abi:"uid"`
+	Block uint64  `abi:"block"`
+}
+
+// schema returns the schema with the name, with zero UID if the config does
+// not have it.
+func (c *configSchema) schema(name string) namedSchema {
+	for _, s := range c.Schemas {
+		if s.Name == name {
+			return s
+		}
+	}
+	return namedSchema{Name: name}
+}
+
+// scanConfigSchema decodes the config attestation data. Configs attested
+// before the schemas list was added have only the voting and ballot schemas.
+func scanConfigSchema(attestation *eas.Attestation) (*configSchema, error) {
+	var config configSchema
+	if len(attestation.Data) == 4*32 {
+		if err := attestation.ScanValues(&config.VotingSchemaUID, &config.VotingSchemaBlock, &config.BallotSchemaUID, &config.BallotSchemaBlock); err != nil {
+			return nil, err
+		}
+		return &config, nil
+	}
+	if err := attestation.ScanValues(&config); err != nil {
+		return nil, err
+	}
+	return &config, nil
 }
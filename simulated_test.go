@@ -0,0 +1,202 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"github.com/ethereum/go-ethereum/rpc"
+	"resenje.org/eas"
+	"resenje.org/eas/eastest"
+)
+
+func TestMain(m *testing.M) {
+	// schema registration and other commands log their progress
+	log.SetOutput(io.Discard)
+
+	os.Exit(m.Run())
+}
+
+// testChain is a simulated chain with EAS contracts deployed, SchulzeOnEAS
+// schemas registered and funded accounts.
+type testChain struct {
+	sim        *simulated.Backend
+	backend    *autoCommitBackend
+	easAddress common.Address
+	configUID  eas.UID
+	accounts   []*ecdsa.PrivateKey
+}
+
+func newTestChain(t testing.TB, accountsCount int) *testChain {
+	t.Helper()
+
+	ctx := context.Background()
+
+	balance := new(big.Int)
+	balance.SetString("100000000000000000000", 10)
+
+	accounts := make([]*ecdsa.PrivateKey, 0, accountsCount+1)
+	balances := make(map[common.Address]*big.Int, accountsCount+1)
+	for i := 0; i <= accountsCount; i++ {
+		pk, err := crypto.GenerateKey()
+		assertNilError(t, err)
+		accounts = append(accounts, pk)
+		balances[crypto.PubkeyToAddress(pk.PublicKey)] = balance
+	}
+
+	sim, easAddress := eastest.NewSimulatedBackend(t, balances)
+
+	c := &testChain{
+		sim: sim,
+		backend: &autoCommitBackend{
+			simulatedClient: sim.Client(),
+			sim:             sim,
+			rpc:             simulatedRPCClient(sim),
+		},
+		easAddress: easAddress,
+		accounts:   accounts[1:],
+	}
+
+	// the first account is the one that registers schemas
+	client, err := eas.NewClient(ctx, "", accounts[0], easAddress, &eas.Options{
+		Backend: c.backend,
+	})
+	assertNilError(t, err)
+
+	c.configUID, err = registerSchemas(ctx, client, eas.UID{}, configSchema{})
+	assertNilError(t, err)
+
+	return c
+}
+
+// newApp returns the app with the client for the account and a new data
+// directory.
+func (c *testChain) newApp(t testing.TB, account *ecdsa.PrivateKey) *app {
+	t.Helper()
+
+	return c.newAppWithDataDir(t, account, t.TempDir())
+}
+
+// newAppWithDataDir returns the app with the client for the account that
+// uses the data directory, so that the local index can be shared between
+// apps.
+func (c *testChain) newAppWithDataDir(t testing.TB, account *ecdsa.PrivateKey, dir string) *app {
+	t.Helper()
+
+	a, err := newApp(dir, "", c.easAddress, c.configUID)
+	assertNilError(t, err)
+	a.backend = c.backend
+
+	assertNilError(t, a.setClient(context.Background(), account))
+	return a
+}
+
+// commit mines empty blocks.
+func (c *testChain) commit(blocks int) {
+	for i := 0; i < blocks; i++ {
+		c.sim.Commit()
+	}
+}
+
+type simulatedClient = simulated.Client
+
+// simulatedRPCClient returns the JSON-RPC client of the simulated chain. The
+// simulated client does not expose it, but it is an exported field of the
+// client struct.
+func simulatedRPCClient(sim *simulated.Backend) *rpc.Client {
+	return reflect.ValueOf(sim.Client()).FieldByName("Client").Interface().(*ethclient.Client).Client()
+}
+
+// adjustTime mines a block with the time moved forward by the duration. The
+// simulated chain can not adjust the time while the transaction pool has
+// pending transactions.
+func (c *testChain) adjustTime(t testing.TB, d time.Duration) {
+	t.Helper()
+
+	assertNilError(t, c.backend.waitEmptyPool(context.Background()))
+	assertNilError(t, c.sim.AdjustTime(d))
+}
+
+// autoCommitBackend mines a block with every sent transaction, so that the
+// code that waits for transactions to be mined can be used without changes.
+type autoCommitBackend struct {
+	simulatedClient
+	sim *simulated.Backend
+	rpc *rpc.Client
+}
+
+func (b *autoCommitBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	if err := b.simulatedClient.SendTransaction(ctx, tx); err != nil {
+		return err
+	}
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		return err
+	}
+	// the transaction pool promotes sent transactions asynchronously, so the
+	// committed block may not include it
+	for {
+		b.sim.Commit()
+		nonce, err := b.NonceAt(ctx, from, nil)
+		if err != nil {
+			return err
+		}
+		if nonce > tx.Nonce() {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return b.waitEmptyPool(ctx)
+}
+
+// waitEmptyPool waits until the transaction pool removes mined transactions,
+// which it does asynchronously after every block.
+func (b *autoCommitBackend) waitEmptyPool(ctx context.Context) error {
+	for {
+		var status map[string]hexutil.Uint
+		if err := b.rpc.CallContext(ctx, &status, "txpool_status"); err != nil {
+			return err
+		}
+		if status["pending"] == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func assertEqual[T any](t testing.TB, name string, got, want T) {
+	t.Helper()
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %s %+v, want %+v", name, got, want)
+	}
+}
+
+func assertNilError(t testing.TB, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("got error %[1]T %[1]q", err)
+	}
+}
@@ -0,0 +1,656 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"resenje.org/eas"
+	"resenje.org/schulze"
+)
+
+// votingResults holds the computed results of a voting and the information
+// about ballots that were used in the computation.
+type votingResults struct {
+	VotingUID eas.UID
+	Voting    *voting
+	Results   []schulze.Result[string]
+	Tie       bool
+	// Pairwise preferences matrix d where d[i][j] is the number of ballots
+	// that prefer the choice i over the choice j.
+	Preferences [][]int
+	// Strongest paths matrix p where p[i][j] is the strength of the strongest
+	// path from the choice i to the choice j.
+	Strengths [][]int
+	// Number of ballot attestations that reference the voting.
+	Attestations int
+	// Number of ballots that were counted, one per attester.
+	Ballots int
+	// Sum of weights of counted ballots if the voting is weighted or
+	// delegated, including weights of delegated votes.
+	Weight int
+	// Pairwise preferences matrix of weighted ballots if the voting is
+	// weighted or delegated, in which case the results are computed from it.
+	WeightedPreferences [][]int
+	// Counted ballots of the weighted voting whose attesters, and their
+	// delegators, held less than one whole weight token at the snapshot
+	// block, so that they do not change the results.
+	ZeroWeight []ballotRecord
+	// Counted ballots that carry votes delegated to their attesters, ordered
+	// by the delegated weight.
+	Delegations []ballotDelegation
+	// Delegation cycles whose delegators' votes were not counted.
+	DelegationCycles [][]common.Address
+	// Ballots of attesters that are not in the voting allowlist or that did
+	// not hold a valid membership when they voted that were not counted.
+	Ineligible []ballotRecord
+	// Ballots attested before the voting start time that were not counted.
+	Early []ballotRecord
+	// Ballots attested after the voting end time or the tally cutoff that
+	// were not counted.
+	Late []ballotRecord
+	// Ballots attested in the voting time window that were revoked before the
+	// voting end time or the tally cutoff. A revoked ballot withdraws the vote
+	// if it is the latest ballot of the attester, without counting any
+	// previous ballot of the same attester.
+	Revoked []ballotRecord
+	// Latest committed ballots of attesters in the voting time window that
+	// were not counted as they were not revealed.
+	Unrevealed []ballotRecord
+	// Latest encrypted ballots of attesters in the voting time window that
+	// were not counted as they could not be decrypted to a ballot of the
+	// attester in the voting or, with homomorphic tallying, as their proofs
+	// are not valid.
+	Undecryptable []ballotRecord
+	// Latest ballots of attesters in the voting time window that were not
+	// counted as their data could not be decoded or as they rank choices that
+	// are not in the voting.
+	Invalid []invalidBallot
+	// Ballots attested in the voting time window that were not counted as the
+	// same attester attested a later ballot.
+	Superseded []supersededBallot
+}
+
+// notCounted returns the number of ballot attestations that are neither
+// counted nor superseded by a later ballot of the same attester.
+func (r *votingResults) notCounted() int {
+	return r.Attestations - r.Ballots - len(r.Superseded)
+}
+
+// ballotRecord is a ballot attestation that references a voting.
+type ballotRecord struct {
+	UID       eas.UID        `json:"uid"`
+	VotingUID eas.UID        `json:"voting"`
+	Recipient common.Address `json:"recipient"`
+	Attester  common.Address `json:"attester"`
+	Time      time.Time      `json:"time"`
+	Block     uint64         `json:"block"`
+	// Index of the Attested event log in the block.
+	LogIndex uint         `json:"logIndex"`
+	Ballot   ballotSchema `json:"ballot"`
+	// True if the attestation data could not be decoded as a ballot.
+	Invalid bool `json:"invalid,omitempty"`
+	// True if the ballot is a commitment without a matching reveal.
+	Unrevealed bool `json:"unrevealed,omitempty"`
+	// True if the ballot is encrypted and it could not be decrypted.
+	Undecryptable bool `json:"undecryptable,omitempty"`
+	// Zero if the ballot is not revoked.
+	RevocationTime time.Time `json:"revocationTime"`
+}
+
+// compareBallotRecords orders ballots by the block number and the log index
+// of their Attested events, which is the order in which they were attested.
+func compareBallotRecords(a, b ballotRecord) int {
+	if c := cmp.Compare(a.Block, b.Block); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.LogIndex, b.LogIndex)
+}
+
+// supersededBallot is a ballot that is not counted because the same attester
+// attested a later ballot.
+type supersededBallot struct {
+	ballotRecord
+	SupersededBy eas.UID
+}
+
+// invalidBallot is a ballot that is not counted because it is not valid in
+// the voting.
+type invalidBallot struct {
+	ballotRecord
+	Reason string
+}
+
+// invalidReason returns the reason why the ballot can not be counted in the
+// voting with the number of choices, or an empty string if it is valid.
+func (r ballotRecord) invalidReason(choicesCount int) string {
+	if r.Invalid {
+		return "ballot data could not be decoded"
+	}
+	for _, rank := range r.Ballot {
+		if int(rank.ChoiceIndex) >= choicesCount {
+			return fmt.Sprintf("choice index %v is not in the voting with %v choices", rank.ChoiceIndex, choicesCount)
+		}
+	}
+	return ""
+}
+
+// isRevoked returns true if the ballot was revoked before the end time. Zero
+// end time does not limit the revocation time.
+func (r ballotRecord) isRevoked(end time.Time) bool {
+	if r.RevocationTime.IsZero() {
+		return false
+	}
+	return end.IsZero() || r.RevocationTime.Before(end)
+}
+
+// tallyOptions limit ballots that are counted in addition to the voting time
+// window, so that the results of a closed voting can be reproduced regardless
+// of ballots attested later.
+type tallyOptions struct {
+	// Ballots attested at or after this time are not counted.
+	Until time.Time
+	// Ballots attested in blocks after this block are not counted.
+	UntilBlock uint64
+	// Decryption transcript of encrypted ballots or of their aggregate with
+	// homomorphic tallying. Partial decryptions of trustees are used if it is
+	// not set.
+	Transcript *decryptionTranscript
+	// Verified off-chain ballots that are counted instead of ballot
+	// attestations.
+	Offchain []ballotRecord
+}
+
+func (a *app) calculateResults(ctx context.Context, votingUID eas.UID, o tallyOptions) (*votingResults, error) {
+	c, err := a.countBallots(ctx, votingUID, o)
+	if err != nil {
+		return nil, err
+	}
+	results := c.Results
+	voting := results.Voting
+	if c.Aggregate != nil {
+		c.Preferences, c.WeightedPreferences, err = a.decryptPairwiseAggregate(ctx, voting, c.Aggregate, o.Transcript)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	choices := make([]uint16, 0, len(voting.Choices))
+	for i := range voting.Choices {
+		choices = append(choices, uint16(i))
+	}
+	tallied := c.Preferences
+	if c.WeightedPreferences != nil {
+		tallied = c.WeightedPreferences
+		results.WeightedPreferences = newPreferencesMatrix(c.WeightedPreferences, len(choices))
+	}
+	computed, duels, tie := schulze.Compute(tallied, choices)
+	results.Results = make([]schulze.Result[string], 0, len(computed))
+	for _, r := range computed {
+		results.Results = append(results.Results, schulze.Result[string]{
+			Choice:    voting.Choices[int(r.Choice)],
+			Index:     r.Index,
+			Wins:      r.Wins,
+			Strength:  r.Strength,
+			Advantage: r.Advantage,
+		})
+	}
+	results.Tie = tie
+	results.Preferences = newPreferencesMatrix(c.Preferences, len(choices))
+	results.Strengths = newStrengthsMatrix(duels, len(choices))
+	return results, nil
+}
+
+// ballotCount is the outcome of counting ballots of a voting before the
+// results are computed from its preferences.
+type ballotCount struct {
+	// Results without the computed results and preferences matrices.
+	Results *votingResults
+	// Pairwise preferences in the format of the schulze package.
+	Preferences []int
+	// Pairwise preferences of weighted ballots, nil if the voting is not
+	// weighted or delegated.
+	WeightedPreferences []int
+	// Encrypted pairwise preferences of counted ballots if the voting has
+	// homomorphic tallying, in which case preferences are not set until the
+	// aggregate is decrypted.
+	Aggregate *pairwiseAggregate
+}
+
+// countBallots selects ballots of the voting that are counted and sums their
+// pairwise preferences.
+func (a *app) countBallots(ctx context.Context, votingUID eas.UID, o tallyOptions) (*ballotCount, error) {
+	voting, err := a.getVoting(ctx, votingUID)
+	if err != nil {
+		return nil, err
+	}
+	eligibility, err := a.getEligibility(ctx, voting)
+	if err != nil {
+		return nil, err
+	}
+	var records []ballotRecord
+	var pairwise map[eas.UID][]elGamalCiphertext
+	switch {
+	case o.Offchain != nil:
+		if err := voting.checkOffchain(); err != nil {
+			return nil, err
+		}
+		records = o.Offchain
+	case voting.HomomorphicTally:
+		records, pairwise, err = a.getPairwiseBallotRecords(ctx, voting)
+	case voting.CommitReveal:
+		records, err = a.getCommittedBallotRecords(ctx, voting)
+	case voting.isEncrypted():
+		records, err = a.getEncryptedBallotRecords(ctx, voting, o.Transcript)
+	default:
+		records, err = a.getBallotRecords(ctx, votingUID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	end := voting.End
+	if !o.Until.IsZero() && (end.IsZero() || o.Until.Before(end)) {
+		end = o.Until
+	}
+
+	results := &votingResults{
+		VotingUID:    votingUID,
+		Voting:       voting,
+		Attestations: len(records),
+	}
+	// Only the latest ballot of every attester in the voting time window is
+	// counted. Records are ordered by the block number and the log index of
+	// their Attested events.
+	var valid []ballotRecord
+	latest := make(map[common.Address]ballotRecord)
+	for _, r := range records {
+		if err := eligibility.check(r.Attester, r.Time); err != nil {
+			results.Ineligible = append(results.Ineligible, r)
+			continue
+		}
+		if !voting.Start.IsZero() && r.Time.Before(voting.Start) {
+			results.Early = append(results.Early, r)
+			continue
+		}
+		if (!end.IsZero() && !r.Time.Before(end)) || (o.UntilBlock > 0 && r.Block > o.UntilBlock) {
+			results.Late = append(results.Late, r)
+			continue
+		}
+		if r.isRevoked(end) {
+			results.Revoked = append(results.Revoked, r)
+		}
+		latest[r.Attester] = r
+		valid = append(valid, r)
+	}
+	for _, r := range valid {
+		if l := latest[r.Attester]; l.UID != r.UID {
+			results.Superseded = append(results.Superseded, supersededBallot{
+				ballotRecord: r,
+				SupersededBy: l.UID,
+			})
+		}
+	}
+	counted := make([]ballotRecord, 0, len(latest))
+	for _, r := range latest {
+		if r.isRevoked(end) {
+			continue
+		}
+		if r.Unrevealed {
+			results.Unrevealed = append(results.Unrevealed, r)
+			continue
+		}
+		if r.Undecryptable {
+			results.Undecryptable = append(results.Undecryptable, r)
+			continue
+		}
+		if reason := r.invalidReason(len(voting.Choices)); reason != "" {
+			results.Invalid = append(results.Invalid, invalidBallot{
+				ballotRecord: r,
+				Reason:       reason,
+			})
+			continue
+		}
+		counted = append(counted, r)
+	}
+	slices.SortFunc(results.Unrevealed, compareBallotRecords)
+	slices.SortFunc(results.Undecryptable, compareBallotRecords)
+	slices.SortFunc(results.Invalid, func(a, b invalidBallot) int {
+		return compareBallotRecords(a.ballotRecord, b.ballotRecord)
+	})
+	results.Ballots = len(counted)
+
+	// delegators of every voter whose votes are counted with the voter's
+	// ballot
+	var delegators map[common.Address][]common.Address
+	if voting.Delegation {
+		delegators, err = a.creditDelegations(ctx, results, voting, eligibility, counted, end, o.UntilBlock)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var weights map[common.Address]int
+	if voting.isWeighted() {
+		addresses := make([]common.Address, 0, len(counted))
+		for _, r := range counted {
+			addresses = append(addresses, r.Attester)
+			addresses = append(addresses, delegators[r.Attester]...)
+		}
+		weights, err = a.getWeights(ctx, voting, addresses)
+		if err != nil {
+			return nil, err
+		}
+	}
+	weight := func(address common.Address) int {
+		if weights == nil {
+			return 1
+		}
+		return weights[address]
+	}
+	weighted := voting.isWeighted() || voting.Delegation
+
+	choices := make([]uint16, 0, len(voting.Choices))
+	for i := range voting.Choices {
+		choices = append(choices, uint16(i))
+	}
+	c := &ballotCount{
+		Results:     results,
+		Preferences: schulze.NewPreferences(len(choices)),
+	}
+	if weighted {
+		c.WeightedPreferences = schulze.NewPreferences(len(choices))
+	}
+	if voting.HomomorphicTally {
+		c.Aggregate = newPairwiseAggregate(len(choices), weighted)
+	}
+	for _, r := range counted {
+		w := 1
+		if weighted {
+			w = weight(r.Attester)
+			if d := delegators[r.Attester]; len(d) > 0 {
+				delegation := ballotDelegation{
+					Ballot:     r.UID,
+					Attester:   r.Attester,
+					Weight:     w,
+					Delegators: d,
+				}
+				for _, delegator := range d {
+					delegation.DelegatedWeight += weight(delegator)
+				}
+				results.Delegations = append(results.Delegations, delegation)
+				w += delegation.DelegatedWeight
+			}
+			results.Weight += w
+			if w == 0 {
+				results.ZeroWeight = append(results.ZeroWeight, r)
+			}
+		}
+		if c.Aggregate != nil {
+			c.Aggregate.add(pairwise[r.UID], w)
+			continue
+		}
+		b := make(schulze.Ballot[uint16])
+		for _, r := range r.Ballot {
+			b[r.ChoiceIndex] = int(r.Rank)
+		}
+		if !weighted {
+			if _, err := schulze.Vote(c.Preferences, choices, b); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// preferences of a single ballot are added to the weighted
+		// preferences multiplied by the ballot weight
+		p := schulze.NewPreferences(len(choices))
+		if _, err := schulze.Vote(p, choices, b); err != nil {
+			return nil, err
+		}
+		for i := range p {
+			c.Preferences[i] += p[i]
+			c.WeightedPreferences[i] += p[i] * w
+		}
+	}
+	if c.Aggregate != nil && results.Weight > maxHomomorphicWeight {
+		return nil, fmt.Errorf("weight %v of counted ballots is larger than %v supported by homomorphic tallying", results.Weight, maxHomomorphicWeight)
+	}
+	slices.SortFunc(results.ZeroWeight, compareBallotRecords)
+	slices.SortFunc(results.Delegations, func(a, b ballotDelegation) int {
+		if c := cmp.Compare(b.DelegatedWeight, a.DelegatedWeight); c != 0 {
+			return c
+		}
+		return a.Attester.Cmp(b.Attester)
+	})
+	return c, nil
+}
+
+// creditDelegations resolves delegations in the voting and returns
+// delegators grouped by the counted voter that their votes are credited to.
+// Delegators that are not eligible to vote at the time of the voter's ballot
+// are not credited. Found delegation cycles are set in the results.
+func (a *app) creditDelegations(ctx context.Context, results *votingResults, v *voting, e *eligibility, counted []ballotRecord, end time.Time, untilBlock uint64) (map[common.Address][]common.Address, error) {
+	delegations, err := a.getDelegations(ctx, v, end, untilBlock)
+	if err != nil {
+		return nil, err
+	}
+	ballots := make(map[common.Address]ballotRecord, len(counted))
+	voters := make(map[common.Address]struct{}, len(counted))
+	for _, r := range counted {
+		ballots[r.Attester] = r
+		voters[r.Attester] = struct{}{}
+	}
+	credited, cycles := resolveDelegations(delegations, voters)
+	results.DelegationCycles = cycles
+
+	delegators := make(map[common.Address][]common.Address)
+	for delegator, voter := range credited {
+		if err := e.check(delegator, ballots[voter].Time); err != nil {
+			continue
+		}
+		delegators[voter] = append(delegators[voter], delegator)
+	}
+	for _, d := range delegators {
+		slices.SortFunc(d, common.Address.Cmp)
+	}
+	return delegators, nil
+}
+
+// newPreferencesMatrix returns the number of ballots that prefer the choice
+// with the row index over the choice with the column index. Diagonal values
+// that are used internally by the schulze package are set to zero.
+func newPreferencesMatrix(preferences []int, choicesCount int) [][]int {
+	m := make([][]int, choicesCount)
+	for i := range m {
+		m[i] = make([]int, choicesCount)
+		for j := range m[i] {
+			if i != j {
+				m[i][j] = preferences[i*choicesCount+j]
+			}
+		}
+	}
+	return m
+}
+
+// newStrengthsMatrix returns the strengths of the strongest paths from the
+// choice with the row index to the choice with the column index.
+func newStrengthsMatrix(duels schulze.DuelsIterator[uint16], choicesCount int) [][]int {
+	m := make([][]int, choicesCount)
+	for i := range m {
+		m[i] = make([]int, choicesCount)
+	}
+	for d := duels(); d != nil; d = duels() {
+		m[d.Left.Index][d.Right.Index] = d.Left.Strength
+		m[d.Right.Index][d.Left.Index] = d.Right.Strength
+	}
+	return m
+}
+
+// getBallotRecords returns all ballot attestations that reference the voting
+// in the order in which they were attested. Ballots from the local index are
+// updated with the ones from the blocks that are not yet indexed.
+//
+// Ballots have the recipient set to the address derived from the voting UID
+// so that only ballots of the voting are filtered by the recipient topic.
+// Ballots attested before the recipient was set have the zero address as the
+// recipient and they are filtered by comparing their voting reference.
+func (a *app) getBallotRecords(ctx context.Context, votingUID eas.UID) ([]ballotRecord, error) {
+	index, err := a.openIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	currentBlock, err := a.client.Backend().(ethereum.BlockNumberReader).BlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	recipients := []common.Address{{}, votingRecipient(votingUID)}
+	start := currentBlock + 1
+	for _, recipient := range recipients {
+		if err := a.updateIndex(ctx, index, recipient, currentBlock); err != nil {
+			return nil, err
+		}
+		start = min(start, max(index.Blocks[recipient]+1, a.config.BallotSchemaBlock))
+	}
+
+	ballots, revocations, err := a.scanBallots(ctx, start, currentBlock, recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []ballotRecord
+	seen := make(map[eas.UID]struct{})
+	for _, r := range slices.Concat(index.Ballots, ballots) {
+		if r.VotingUID != votingUID || !slices.Contains(recipients, r.Recipient) {
+			continue
+		}
+		if _, ok := seen[r.UID]; ok {
+			continue
+		}
+		seen[r.UID] = struct{}{}
+		records = append(records, r)
+	}
+	applyRevocations(records, revocations)
+
+	slices.SortFunc(records, compareBallotRecords)
+	return records, nil
+}
+
+// scanBallots returns ballot attestations with any of the recipients that
+// were attested in the block range and revocation times of ballots that were
+// revoked in the same range. Both start and end blocks are inclusive.
+func (a *app) scanBallots(ctx context.Context, start, end uint64, recipients []common.Address) (ballots []ballotRecord, revocations map[eas.UID]time.Time, err error) {
+	var mu sync.Mutex
+	seen := make(map[eas.UID]struct{})
+	if err := a.scanner.scan(ctx, start, end, func(ctx context.Context, start, end uint64) (int, error) {
+		it, err := a.client.EAS.FilterAttested(ctx, start, &end, recipients, nil, []eas.UID{a.config.BallotSchemaUID})
+		if err != nil {
+			return 0, err
+		}
+		defer it.Close()
+
+		var events []eas.EASAttested
+		var uids []eas.UID
+		for it.Next() {
+			r := it.Value()
+			events = append(events, r)
+			uids = append(uids, r.UID)
+		}
+		if err := it.Error(); err != nil {
+			return 0, err
+		}
+
+		attestations, err := a.getAttestations(ctx, uids)
+		if err != nil {
+			return 0, err
+		}
+
+		records := make([]ballotRecord, 0, len(events))
+		for i, r := range events {
+			b := attestations[i]
+			record := ballotRecord{
+				UID:       r.UID,
+				VotingUID: b.RefUID,
+				Recipient: r.Recipient,
+				Attester:  r.Attester,
+				Time:      b.Time,
+				Block:     r.Raw.BlockNumber,
+				LogIndex:  r.Raw.Index,
+			}
+			if err := b.ScanValues(&record.Ballot); err != nil {
+				record.Invalid = true
+			}
+			if b.IsRevoked() {
+				record.RevocationTime = b.RevocationTime
+			}
+			records = append(records, record)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, r := range records {
+			if _, ok := seen[r.UID]; ok {
+				continue
+			}
+			seen[r.UID] = struct{}{}
+			ballots = append(ballots, r)
+		}
+		return len(records), nil
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	revocations = make(map[eas.UID]time.Time)
+	if err := a.scanner.scan(ctx, start, end, func(ctx context.Context, start, end uint64) (int, error) {
+		it, err := a.client.EAS.FilterRevoked(ctx, start, &end, recipients, nil, []eas.UID{a.config.BallotSchemaUID})
+		if err != nil {
+			return 0, err
+		}
+		defer it.Close()
+
+		var uids []eas.UID
+		for it.Next() {
+			uids = append(uids, it.Value().UID)
+		}
+		if err := it.Error(); err != nil {
+			return 0, err
+		}
+
+		attestations, err := a.getAttestations(ctx, uids)
+		if err != nil {
+			return 0, err
+		}
+
+		found := make(map[eas.UID]time.Time, len(attestations))
+		for _, b := range attestations {
+			found[b.UID] = b.RevocationTime
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		maps.Copy(revocations, found)
+		return len(found), nil
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	return ballots, revocations, nil
+}
+
+func applyRevocations(records []ballotRecord, revocations map[eas.UID]time.Time) {
+	for i, r := range records {
+		if t, ok := revocations[r.UID]; ok {
+			records[i].RevocationTime = t
+		}
+	}
+}
@@ -0,0 +1,774 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"resenje.org/eas"
+)
+
+func TestCalculateResults(t *testing.T) {
+	c := newTestChain(t, 5)
+
+	organizer := c.newApp(t, c.accounts[0])
+	v := createTestVoting(t, organizer, &voting{
+		Title:   "Board election",
+		Choices: []string{"Alice", "Bob", "Carol"},
+	})
+
+	for i, ranking := range []string{
+		"1,2,3",
+		"1,3,2",
+		"2,1,3",
+		"3,2,1",
+		"3,1,2",
+	} {
+		castTestBallot(t, c.newApp(t, c.accounts[i]), v, ranking)
+	}
+
+	r, err := organizer.calculateResults(context.Background(), v.UID, tallyOptions{})
+	assertNilError(t, err)
+
+	assertEqual(t, "attestations", r.Attestations, 5)
+	assertEqual(t, "ballots", r.Ballots, 5)
+	assertEqual(t, "tie", r.Tie, false)
+	assertEqual(t, "choices", resultsChoices(r), []string{"Bob", "Alice", "Carol"})
+	assertEqual(t, "preferences", r.Preferences, [][]int{
+		{0, 2, 3},
+		{3, 0, 3},
+		{2, 2, 0},
+	})
+	assertEqual(t, "strengths", r.Strengths, [][]int{
+		{0, 0, 3},
+		{3, 0, 3},
+		{0, 0, 0},
+	})
+}
+
+func TestCalculateResults_recipients(t *testing.T) {
+	c := newTestChain(t, 3)
+
+	ctx := context.Background()
+
+	organizer := c.newApp(t, c.accounts[0])
+	v := createTestVoting(t, organizer, &voting{
+		Title:   "Lunch",
+		Choices: []string{"Pizza", "Pasta"},
+	})
+
+	castTestBallot(t, organizer, v, "2,1")
+
+	attest := func(voter *app, recipient common.Address, ranking string) {
+		t.Helper()
+		ballot, err := parseRanking(ranking, len(v.Choices))
+		assertNilError(t, err)
+		_, wait, err := voter.client.EAS.Attest(ctx, voter.config.BallotSchemaUID, &eas.AttestOptions{
+			Recipient: recipient,
+			RefUID:    v.UID,
+			Revocable: true,
+		}, ballot)
+		assertNilError(t, err)
+		_, err = wait(ctx)
+		assertNilError(t, err)
+	}
+
+	// Ballot attested without the recipient by a previous version.
+	legacyVoter := c.newApp(t, c.accounts[1])
+	attest(legacyVoter, common.Address{}, "2,1")
+
+	// Ballot with another recipient is not requested.
+	otherVoter := c.newApp(t, c.accounts[2])
+	attest(otherVoter, otherVoter.client.Address(), "1,2")
+
+	r, err := organizer.calculateResults(ctx, v.UID, tallyOptions{})
+	assertNilError(t, err)
+	assertEqual(t, "attestations", r.Attestations, 2)
+	assertEqual(t, "ballots", r.Ballots, 2)
+	assertEqual(t, "winner", r.Results[0].Choice, "Pasta")
+	assertEqual(t, "winner votes", r.Results[0].Wins, 1)
+}
+
+func TestCalculateResults_invalidBallots(t *testing.T) {
+	c := newTestChain(t, 3)
+
+	ctx := context.Background()
+
+	organizer := c.newApp(t, c.accounts[0])
+	v := createTestVoting(t, organizer, &voting{
+		Title:   "Lunch",
+		Choices: []string{"Pizza", "Pasta"},
+	})
+
+	castTestBallot(t, organizer, v, "1,2")
+
+	voter := c.newApp(t, c.accounts[1])
+	castTestBallot(t, voter, v, "2,1")
+	outOfRange := attestTestBallot(t, voter, v, ballotSchema{{ChoiceIndex: 2, Rank: 1}})
+
+	malformedVoter := c.newApp(t, c.accounts[2])
+	_, wait, err := malformedVoter.client.EAS.Attest(ctx, malformedVoter.config.BallotSchemaUID, &eas.AttestOptions{
+		Recipient: votingRecipient(v.UID),
+		RefUID:    v.UID,
+		Revocable: true,
+	}, uint64(1))
+	assertNilError(t, err)
+	malformed, err := wait(ctx)
+	assertNilError(t, err)
+
+	r, err := organizer.calculateResults(ctx, v.UID, tallyOptions{})
+	assertNilError(t, err)
+	assertEqual(t, "attestations", r.Attestations, 4)
+	assertEqual(t, "ballots", r.Ballots, 1)
+	assertEqual(t, "superseded", len(r.Superseded), 1)
+	assertEqual(t, "invalid", len(r.Invalid), 2)
+	assertEqual(t, "out of range ballot", r.Invalid[0].UID, outOfRange)
+	assertEqual(t, "out of range reason", r.Invalid[0].Reason, "choice index 2 is not in the voting with 2 choices")
+	assertEqual(t, "malformed ballot", r.Invalid[1].UID, malformed.UID)
+	assertEqual(t, "malformed reason", r.Invalid[1].Reason, "ballot data could not be decoded")
+	assertEqual(t, "winner", r.Results[0].Choice, "Pizza")
+}
+
+func TestCalculateResults_latestBallot(t *testing.T) {
+	c := newTestChain(t, 3)
+
+	ctx := context.Background()
+
+	organizer := c.newApp(t, c.accounts[0])
+	v := createTestVoting(t, organizer, &voting{
+		Title:   "Lunch",
+		Choices: []string{"Pizza", "Pasta"},
+	})
+
+	first := c.newApp(t, c.accounts[0])
+	second := c.newApp(t, c.accounts[1])
+	third := c.newApp(t, c.accounts[2])
+
+	changed := castTestBallot(t, first, v, "1,2")
+	latest := castTestBallot(t, first, v, "2,1")
+	castTestBallot(t, second, v, "2,1")
+	revoked := castTestBallot(t, third, v, "1,2")
+
+	revokeTestAttestation(t, third, third.config.BallotSchemaUID, revoked)
+
+	r, err := organizer.calculateResults(ctx, v.UID, tallyOptions{})
+	assertNilError(t, err)
+
+	assertEqual(t, "attestations", r.Attestations, 4)
+	assertEqual(t, "ballots", r.Ballots, 2)
+	assertEqual(t, "choices", resultsChoices(r), []string{"Pasta", "Pizza"})
+	assertEqual(t, "revoked", len(r.Revoked), 1)
+	assertEqual(t, "revoked ballot", r.Revoked[0].UID, revoked)
+	assertEqual(t, "superseded", len(r.Superseded), 1)
+	assertEqual(t, "superseded ballot", r.Superseded[0].UID, changed)
+	assertEqual(t, "superseded by", r.Superseded[0].SupersededBy, latest)
+}
+
+func TestCalculateResults_untilBlock(t *testing.T) {
+	c := newTestChain(t, 2)
+
+	ctx := context.Background()
+
+	organizer := c.newApp(t, c.accounts[0])
+	v := createTestVoting(t, organizer, &voting{
+		Title:   "Lunch",
+		Choices: []string{"Pizza", "Pasta"},
+	})
+
+	castTestBallot(t, c.newApp(t, c.accounts[0]), v, "1,2")
+
+	block, err := c.backend.BlockNumber(ctx)
+	assertNilError(t, err)
+
+	late := castTestBallot(t, c.newApp(t, c.accounts[1]), v, "2,1")
+
+	r, err := organizer.calculateResults(ctx, v.UID, tallyOptions{
+		UntilBlock: block,
+	})
+	assertNilError(t, err)
+
+	assertEqual(t, "ballots", r.Ballots, 1)
+	assertEqual(t, "winner", r.Results[0].Choice, "Pizza")
+	assertEqual(t, "late", len(r.Late), 1)
+	assertEqual(t, "late ballot", r.Late[0].UID, late)
+}
+
+func TestCalculateResults_index(t *testing.T) {
+	c := newTestChain(t, 2)
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	organizer := c.newAppWithDataDir(t, c.accounts[0], dir)
+	v := createTestVoting(t, organizer, &voting{
+		Title:   "Lunch",
+		Choices: []string{"Pizza", "Pasta"},
+	})
+
+	castTestBallot(t, c.newApp(t, c.accounts[0]), v, "1,2")
+	c.commit(indexConfirmations)
+
+	r, err := organizer.calculateResults(ctx, v.UID, tallyOptions{})
+	assertNilError(t, err)
+	assertEqual(t, "ballots", r.Ballots, 1)
+	assertEqual(t, "indexed ballots", len(organizer.index.Ballots), 1)
+
+	castTestBallot(t, c.newApp(t, c.accounts[1]), v, "1,2")
+
+	// a new app loads the index saved by the previous one
+	a := c.newAppWithDataDir(t, c.accounts[1], dir)
+
+	r, err = a.calculateResults(ctx, v.UID, tallyOptions{})
+	assertNilError(t, err)
+	assertEqual(t, "ballots", r.Ballots, 2)
+	assertEqual(t, "indexed ballots", len(a.index.Ballots), 1)
+	assertEqual(t, "winner", r.Results[0].Choice, "Pizza")
+}
+
+func TestCalculateResults_allowlist(t *testing.T) {
+	c := newTestChain(t, 3)
+
+	ctx := context.Background()
+
+	organizer := c.newApp(t, c.accounts[0])
+	root, err := organizer.addAllowlist(ctx, []common.Address{
+		crypto.PubkeyToAddress(c.accounts[0].PublicKey),
+		crypto.PubkeyToAddress(c.accounts[1].PublicKey),
+	})
+	assertNilError(t, err)
+
+	v := createTestVoting(t, organizer, &voting{
+		Title:         "Board election",
+		Choices:       []string{"Alice", "Bob"},
+		AllowlistRoot: root,
+	})
+	assertEqual(t, "allowlist root", v.AllowlistRoot, root)
+
+	outsider := crypto.PubkeyToAddress(c.accounts[2].PublicKey)
+	if err := organizer.checkEligibility(ctx, v, outsider); err == nil {
+		t.Error("outsider is eligible")
+	}
+
+	castTestBallot(t, c.newApp(t, c.accounts[0]), v, "1,2")
+	castTestBallot(t, c.newApp(t, c.accounts[1]), v, "1,2")
+	ineligible := castTestBallot(t, c.newApp(t, c.accounts[2]), v, "2,1")
+
+	// results can not be calculated without the allowlist
+	_, err = c.newApp(t, c.accounts[2]).calculateResults(ctx, v.UID, tallyOptions{})
+	assertEqual(t, "error", err, errAllowlistUnavailable)
+
+	r, err := organizer.calculateResults(ctx, v.UID, tallyOptions{})
+	assertNilError(t, err)
+
+	assertEqual(t, "attestations", r.Attestations, 3)
+	assertEqual(t, "ballots", r.Ballots, 2)
+	assertEqual(t, "ineligible", len(r.Ineligible), 1)
+	assertEqual(t, "ineligible ballot", r.Ineligible[0].UID, ineligible)
+	assertEqual(t, "preferences", r.Preferences, [][]int{
+		{0, 2},
+		{0, 0},
+	})
+}
+
+func TestCalculateResults_membership(t *testing.T) {
+	c := newTestChain(t, 4)
+
+	ctx := context.Background()
+
+	issuer := c.newApp(t, c.accounts[0])
+	untrustedIssuer := c.newApp(t, c.accounts[3])
+
+	_, wait, err := registerSchema(ctx, issuer.client, membershipTestSchema{})
+	assertNilError(t, err)
+	schemaUID, _, err := wait(ctx)
+	assertNilError(t, err)
+
+	member := c.newApp(t, c.accounts[1])
+	revokedMember := c.newApp(t, c.accounts[2])
+	untrustedMember := c.newApp(t, c.accounts[3])
+
+	memberships := make(map[*app]eas.UID)
+	for _, m := range []struct {
+		issuer, member *app
+	}{
+		{issuer: issuer, member: member},
+		{issuer: issuer, member: revokedMember},
+		{issuer: untrustedIssuer, member: untrustedMember},
+	} {
+		_, wait, err := m.issuer.client.EAS.Attest(ctx, schemaUID, &eas.AttestOptions{
+			Recipient: m.member.client.Address(),
+			Revocable: true,
+		}, membershipTestSchema{Role: "member"})
+		assertNilError(t, err)
+		r, err := wait(ctx)
+		assertNilError(t, err)
+		memberships[m.member] = r.UID
+	}
+
+	revokeTestAttestation(t, issuer, schemaUID, memberships[revokedMember])
+
+	v := createTestVoting(t, issuer, &voting{
+		Title:               "Board election",
+		Choices:             []string{"Alice", "Bob"},
+		MembershipSchemaUID: schemaUID,
+		MembershipIssuers:   []common.Address{issuer.client.Address()},
+	})
+	assertEqual(t, "membership schema", v.MembershipSchemaUID, schemaUID)
+	assertEqual(t, "membership issuers", v.MembershipIssuers, []common.Address{issuer.client.Address()})
+
+	assertNilError(t, member.checkEligibility(ctx, v, member.client.Address()))
+	if err := revokedMember.checkEligibility(ctx, v, revokedMember.client.Address()); err == nil {
+		t.Error("revoked member is eligible")
+	}
+	if err := untrustedMember.checkEligibility(ctx, v, untrustedMember.client.Address()); err == nil {
+		t.Error("member of untrusted issuer is eligible")
+	}
+
+	castTestBallot(t, member, v, "1,2")
+	for _, a := range []*app{revokedMember, untrustedMember} {
+		ballot, err := parseRanking("2,1", len(v.Choices))
+		assertNilError(t, err)
+		if _, _, err := a.submitBallot(ctx, v, ballot); err == nil {
+			t.Error("ineligible ballot submitted")
+		}
+		attestTestBallot(t, a, v, ballot)
+	}
+
+	// membership revoked after voting does not invalidate the ballot
+	revokeTestAttestation(t, issuer, schemaUID, memberships[member])
+
+	r, err := issuer.calculateResults(ctx, v.UID, tallyOptions{})
+	assertNilError(t, err)
+
+	assertEqual(t, "attestations", r.Attestations, 3)
+	assertEqual(t, "ballots", r.Ballots, 1)
+	assertEqual(t, "ineligible", len(r.Ineligible), 2)
+	assertEqual(t, "winner", r.Results[0].Choice, "Alice")
+}
+
+func TestCalculateResults_delegation(t *testing.T) {
+	c := newTestChain(t, 7)
+
+	ctx := context.Background()
+
+	apps := make([]*app, 0, len(c.accounts))
+	for _, account := range c.accounts {
+		apps = append(apps, c.newApp(t, account))
+	}
+	address := func(i int) common.Address {
+		return apps[i].client.Address()
+	}
+
+	v := createTestVoting(t, apps[0], &voting{
+		Title:      "Board election",
+		Choices:    []string{"Alice", "Bob"},
+		Delegation: true,
+	})
+	assertEqual(t, "delegation", v.Delegation, true)
+
+	undelegated := createTestVoting(t, apps[0], &voting{
+		Title:   "Budget",
+		Choices: []string{"Accept", "Reject"},
+	})
+	if _, _, err := apps[1].delegate(ctx, address(2), undelegated.UID); err == nil {
+		t.Error("delegated in a voting without delegation")
+	}
+
+	delegate := func(from, to int, votingUID eas.UID) {
+		t.Helper()
+
+		_, wait, err := apps[from].delegate(ctx, address(to), votingUID)
+		assertNilError(t, err)
+		_, err = wait(ctx)
+		assertNilError(t, err)
+	}
+
+	// 3 and 4 are credited to 1 through the chain 3 -> 4 -> 1
+	delegate(3, 4, eas.UID{})
+	delegate(4, 1, v.UID)
+	// the ballot of 5 overrides its delegation
+	delegate(5, 2, eas.UID{})
+	// 0 and 6 delegate to each other and neither votes
+	delegate(0, 6, eas.UID{})
+	delegate(6, 0, v.UID)
+
+	castTestBallot(t, apps[1], v, "1,2")
+	castTestBallot(t, apps[2], v, "2,1")
+	castTestBallot(t, apps[5], v, "1,2")
+
+	r, err := apps[0].calculateResults(ctx, v.UID, tallyOptions{})
+	assertNilError(t, err)
+
+	assertEqual(t, "ballots", r.Ballots, 3)
+	assertEqual(t, "weight", r.Weight, 5)
+	assertEqual(t, "preferences", r.Preferences, [][]int{{0, 2}, {1, 0}})
+	assertEqual(t, "weighted preferences", r.WeightedPreferences, [][]int{{0, 4}, {1, 0}})
+	assertEqual(t, "delegations count", len(r.Delegations), 1)
+	assertEqual(t, "delegation attester", r.Delegations[0].Attester, address(1))
+	assertEqual(t, "delegation weight", r.Delegations[0].Weight, 1)
+	assertEqual(t, "delegated weight", r.Delegations[0].DelegatedWeight, 2)
+	assertEqual(t, "delegators", r.Delegations[0].Delegators, sortedAddresses([]common.Address{address(3), address(4)}))
+	cycle := []common.Address{address(0), address(6)}
+	if cycle[1].Cmp(cycle[0]) < 0 {
+		cycle[0], cycle[1] = cycle[1], cycle[0]
+	}
+	assertEqual(t, "delegation cycles", r.DelegationCycles, [][]common.Address{cycle})
+
+	// revoking the delegation of 4 in the voting breaks the chain of 3
+	_, wait, err := apps[4].revokeDelegation(ctx, v.UID)
+	assertNilError(t, err)
+	_, err = wait(ctx)
+	assertNilError(t, err)
+	if _, _, err := apps[4].revokeDelegation(ctx, v.UID); err == nil {
+		t.Error("revoked the delegation twice")
+	}
+
+	r, err = apps[0].calculateResults(ctx, v.UID, tallyOptions{})
+	assertNilError(t, err)
+
+	assertEqual(t, "weight", r.Weight, 3)
+	assertEqual(t, "delegations count", len(r.Delegations), 0)
+}
+
+func TestCalculateResults_commitReveal(t *testing.T) {
+	c := newTestChain(t, 4)
+
+	ctx := context.Background()
+
+	apps := make([]*app, 0, len(c.accounts))
+	for _, account := range c.accounts {
+		apps = append(apps, c.newApp(t, account))
+	}
+
+	now, err := apps[0].chainTime(ctx)
+	assertNilError(t, err)
+
+	v := createTestVoting(t, apps[0], &voting{
+		Title:        "Board election",
+		Choices:      []string{"Alice", "Bob"},
+		End:          now.Add(time.Hour),
+		CommitReveal: true,
+		RevealEnd:    now.Add(2 * time.Hour),
+	})
+	assertEqual(t, "commit reveal", v.CommitReveal, true)
+	assertEqual(t, "reveal end", v.RevealEnd, now.Add(2*time.Hour))
+
+	castTestBallot(t, apps[0], v, "2,1")
+	castTestBallot(t, apps[0], v, "1,2")
+	castTestBallot(t, apps[1], v, "1,2")
+	castTestBallot(t, apps[2], v, "2,1")
+	unrevealed := castTestBallot(t, apps[3], v, "2,1")
+
+	r, err := apps[0].calculateResults(ctx, v.UID, tallyOptions{})
+	assertNilError(t, err)
+
+	assertEqual(t, "attestations", r.Attestations, 5)
+	assertEqual(t, "ballots", r.Ballots, 0)
+	assertEqual(t, "unrevealed", len(r.Unrevealed), 4)
+
+	if _, _, err := apps[1].revealBallot(ctx, v); err == nil {
+		t.Error("revealed the ballot before the voting end")
+	}
+
+	c.adjustTime(t, time.Hour)
+	c.commit(1)
+
+	for _, a := range apps[:3] {
+		_, wait, err := a.revealBallot(ctx, v)
+		assertNilError(t, err)
+		_, err = wait(ctx)
+		assertNilError(t, err)
+
+		secrets, err := a.openBallotSecrets(ctx)
+		assertNilError(t, err)
+		assertEqual(t, "pending", len(secrets.pending(a.client.Address())), 0)
+	}
+	if _, _, err := apps[1].revealBallot(ctx, v); err == nil {
+		t.Error("revealed the ballot twice")
+	}
+
+	r, err = apps[0].calculateResults(ctx, v.UID, tallyOptions{})
+	assertNilError(t, err)
+
+	assertEqual(t, "attestations", r.Attestations, 5)
+	assertEqual(t, "ballots", r.Ballots, 3)
+	assertEqual(t, "superseded", len(r.Superseded), 1)
+	assertEqual(t, "unrevealed", len(r.Unrevealed), 1)
+	assertEqual(t, "unrevealed ballot", r.Unrevealed[0].UID, unrevealed)
+	assertEqual(t, "preferences", r.Preferences, [][]int{
+		{0, 2},
+		{1, 0},
+	})
+
+	c.adjustTime(t, time.Hour)
+	c.commit(1)
+
+	if _, _, err := apps[3].revealBallot(ctx, v); err == nil {
+		t.Error("revealed the ballot after the reveal end")
+	}
+}
+
+func TestCalculateResults_encrypted(t *testing.T) {
+	c := newTestChain(t, 4)
+
+	ctx := context.Background()
+
+	apps := make([]*app, 0, len(c.accounts))
+	for _, account := range c.accounts {
+		apps = append(apps, c.newApp(t, account))
+	}
+	tallier := c.accounts[3]
+
+	now, err := apps[0].chainTime(ctx)
+	assertNilError(t, err)
+
+	v := createTestVoting(t, apps[0], &voting{
+		Title:            "Board election",
+		Choices:          []string{"Alice", "Bob"},
+		End:              now.Add(time.Hour),
+		TallierPublicKey: crypto.CompressPubkey(&tallier.PublicKey),
+	})
+	assertEqual(t, "tallier public key", []byte(v.TallierPublicKey), crypto.CompressPubkey(&tallier.PublicKey))
+
+	castTestBallot(t, apps[0], v, "2,1")
+	castTestBallot(t, apps[0], v, "1,2")
+	castTestBallot(t, apps[1], v, "1,2")
+	castTestBallot(t, apps[2], v, "2,1")
+
+	// a ciphertext copied from another voter can not be decrypted
+	ballots, err := apps[3].getEncryptedBallots(ctx, v)
+	assertNilError(t, err)
+	_, wait, err := apps[3].client.EAS.Attest(ctx, apps[3].config.schema(encryptedBallotSchemaName).UID, &eas.AttestOptions{
+		Recipient: votingRecipient(v.UID),
+		RefUID:    v.UID,
+		Revocable: true,
+	}, encryptedBallotSchema{
+		Ciphertext: ballots[2].Ciphertext,
+	})
+	assertNilError(t, err)
+	copied, err := wait(ctx)
+	assertNilError(t, err)
+
+	if _, err := apps[0].calculateResults(ctx, v.UID, tallyOptions{}); err == nil {
+		t.Error("calculated results without the decryption transcript")
+	}
+	if _, err := apps[3].decryptTally(ctx, v, tallier); err == nil {
+		t.Error("decrypted ballots before the voting end")
+	}
+
+	c.adjustTime(t, time.Hour)
+	c.commit(1)
+
+	if _, err := apps[0].decryptTally(ctx, v, c.accounts[0]); err == nil {
+		t.Error("decrypted ballots with the key of another account")
+	}
+
+	transcript, err := apps[3].decryptTally(ctx, v, tallier)
+	assertNilError(t, err)
+	assertEqual(t, "transcript ballots", len(transcript.Ballots), 5)
+
+	r, err := apps[0].calculateResults(ctx, v.UID, tallyOptions{
+		Transcript: transcript,
+	})
+	assertNilError(t, err)
+
+	assertEqual(t, "attestations", r.Attestations, 5)
+	assertEqual(t, "ballots", r.Ballots, 3)
+	assertEqual(t, "superseded", len(r.Superseded), 1)
+	assertEqual(t, "undecryptable", len(r.Undecryptable), 1)
+	assertEqual(t, "undecryptable ballot", r.Undecryptable[0].UID, copied.UID)
+	assertEqual(t, "preferences", r.Preferences, [][]int{
+		{0, 2},
+		{1, 0},
+	})
+
+	transcript.Ballots = transcript.Ballots[1:]
+	if _, err := apps[0].calculateResults(ctx, v.UID, tallyOptions{
+		Transcript: transcript,
+	}); err == nil {
+		t.Error("calculated results with a ballot missing in the transcript")
+	}
+}
+
+func TestCalculateResults_homomorphic(t *testing.T) {
+	c := newTestChain(t, 5)
+
+	ctx := context.Background()
+
+	apps := make([]*app, 0, len(c.accounts))
+	for _, account := range c.accounts {
+		apps = append(apps, c.newApp(t, account))
+	}
+	tallier := c.accounts[4]
+
+	now, err := apps[0].chainTime(ctx)
+	assertNilError(t, err)
+
+	v := createTestVoting(t, apps[0], &voting{
+		Title:            "Board election",
+		Choices:          []string{"Alice", "Bob", "Carol"},
+		End:              now.Add(time.Hour),
+		Delegation:       true,
+		TallierPublicKey: crypto.CompressPubkey(&tallier.PublicKey),
+		HomomorphicTally: true,
+	})
+	assertEqual(t, "homomorphic tally", v.HomomorphicTally, true)
+
+	ballot, err := parseRanking("1,2", len(v.Choices))
+	assertNilError(t, err)
+	if _, _, err := apps[0].submitBallot(ctx, v, ballot); err == nil {
+		t.Error("submitted a ballot without all choices ranked")
+	}
+
+	_, wait, err := apps[3].delegate(ctx, apps[1].client.Address(), v.UID)
+	assertNilError(t, err)
+	_, err = wait(ctx)
+	assertNilError(t, err)
+
+	castTestBallot(t, apps[0], v, "1,2,3")
+	castTestBallot(t, apps[1], v, "2,1,3")
+	castTestBallot(t, apps[2], v, "3,2,1")
+
+	// ciphertexts and proofs copied from another voter are not counted
+	attested, err := apps[4].client.EAS.GetAttestation(ctx, castTestBallot(t, apps[2], v, "3,2,1"))
+	assertNilError(t, err)
+	var s pairwiseBallotSchema
+	assertNilError(t, attested.ScanValues(&s))
+	_, wait, err = apps[4].client.EAS.Attest(ctx, apps[4].config.schema(pairwiseBallotSchemaName).UID, &eas.AttestOptions{
+		Recipient: votingRecipient(v.UID),
+		RefUID:    v.UID,
+		Revocable: true,
+	}, s)
+	assertNilError(t, err)
+	copied, err := wait(ctx)
+	assertNilError(t, err)
+
+	if _, err := apps[0].calculateResults(ctx, v.UID, tallyOptions{}); err == nil {
+		t.Error("calculated results without the decryption transcript")
+	}
+	if _, err := apps[4].decryptTally(ctx, v, tallier); err == nil {
+		t.Error("decrypted the aggregate before the voting end")
+	}
+
+	c.adjustTime(t, time.Hour)
+	c.commit(1)
+
+	transcript, err := apps[4].decryptTally(ctx, v, tallier)
+	assertNilError(t, err)
+	assertEqual(t, "transcript ballots", len(transcript.Ballots), 0)
+	assertEqual(t, "transcript aggregate", len(transcript.Aggregate), 12)
+
+	r, err := apps[0].calculateResults(ctx, v.UID, tallyOptions{
+		Transcript: transcript,
+	})
+	assertNilError(t, err)
+
+	assertEqual(t, "ballots", r.Ballots, 3)
+	assertEqual(t, "weight", r.Weight, 4)
+	assertEqual(t, "undecryptable", len(r.Undecryptable), 1)
+	assertEqual(t, "undecryptable ballot", r.Undecryptable[0].UID, copied.UID)
+	assertEqual(t, "preferences", r.Preferences, [][]int{
+		{0, 1, 2},
+		{2, 0, 2},
+		{1, 1, 0},
+	})
+	assertEqual(t, "weighted preferences", r.WeightedPreferences, [][]int{
+		{0, 1, 3},
+		{3, 0, 3},
+		{1, 1, 0},
+	})
+	assertEqual(t, "winner", r.Results[0].Choice, "Bob")
+
+	tampered := *transcript
+	tampered.Aggregate = append([]decryptedCiphertext(nil), transcript.Aggregate...)
+	tampered.Aggregate[0], tampered.Aggregate[1] = tampered.Aggregate[1], tampered.Aggregate[0]
+	if _, err := apps[0].calculateResults(ctx, v.UID, tallyOptions{
+		Transcript: &tampered,
+	}); err == nil {
+		t.Error("calculated results with a tampered decryption transcript")
+	}
+}
+
+type membershipTestSchema struct {
+	Role string `abi:"role"`
+}
+
+// createTestVoting attests the voting and returns it as it is decoded from the
+// attestation.
+func createTestVoting(t testing.TB, a *app, v *voting) *voting {
+	t.Helper()
+
+	ctx := context.Background()
+
+	assertNilError(t, v.validate())
+
+	_, wait, err := a.createVoting(ctx, v)
+	assertNilError(t, err)
+	r, err := wait(ctx)
+	assertNilError(t, err)
+
+	created, err := a.getVoting(ctx, r.UID)
+	assertNilError(t, err)
+	assertEqual(t, "title", created.Title, v.Title)
+	assertEqual(t, "choices", created.Choices, v.Choices)
+	return created
+}
+
+func castTestBallot(t testing.TB, a *app, v *voting, ranking string) eas.UID {
+	t.Helper()
+
+	ctx := context.Background()
+
+	ballot, err := parseRanking(ranking, len(v.Choices))
+	assertNilError(t, err)
+
+	_, wait, err := a.submitBallot(ctx, v, ballot)
+	assertNilError(t, err)
+	r, err := wait(ctx)
+	assertNilError(t, err)
+	return r.UID
+}
+
+// attestTestBallot attests the ballot without any checks that submitBallot
+// does before attesting.
+func attestTestBallot(t testing.TB, a *app, v *voting, ballot ballotSchema) eas.UID {
+	t.Helper()
+
+	ctx := context.Background()
+
+	_, wait, err := a.client.EAS.Attest(ctx, a.config.BallotSchemaUID, &eas.AttestOptions{
+		Recipient: votingRecipient(v.UID),
+		RefUID:    v.UID,
+		Revocable: true,
+	}, ballot)
+	assertNilError(t, err)
+	r, err := wait(ctx)
+	assertNilError(t, err)
+	return r.UID
+}
+
+func revokeTestAttestation(t testing.TB, a *app, schemaUID, uid eas.UID) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	_, wait, err := a.client.EAS.Revoke(ctx, schemaUID, uid, nil)
+	assertNilError(t, err)
+	_, err = wait(ctx)
+	assertNilError(t, err)
+}
+
+func resultsChoices(r *votingResults) []string {
+	choices := make([]string, 0, len(r.Results))
+	for _, r := range r.Results {
+		choices = append(choices, r.Choice)
+	}
+	return choices
+}
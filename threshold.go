@@ -0,0 +1,919 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"resenje.org/eas"
+)
+
+// The tallier key of encrypted ballots can be generated jointly by trustees
+// with the Pedersen distributed key generation, where every trustee deals
+// shares of its own secret with the Feldman verifiable secret sharing. The
+// private key is the sum of secrets of qualified dealers and it is never
+// known to anyone, while every trustee holds the sum of shares it received,
+// which is its share of the private key. Any threshold number of trustees
+// can compute partial decryptions of ballot shared secrets with their key
+// shares, which are combined with Lagrange interpolation.
+
+// keyShareEncryptionDomain separates keys of encrypted key shares from keys
+// of encrypted ballots.
+const keyShareEncryptionDomain = "SchulzeOnEAS key share encryption"
+
+// keyCeremony is a key ceremony attestation.
+type keyCeremony struct {
+	UID      eas.UID
+	Attester common.Address
+	// Public keys of trustees in the order of their indexes that start from
+	// one.
+	TrusteePublicKeys []*ecdsa.PublicKey
+	Threshold         int
+	DealingEnd        time.Time
+	ComplaintEnd      time.Time
+}
+
+func (c *keyCeremony) validate() error {
+	n := len(c.TrusteePublicKeys)
+	if n < 2 {
+		return errors.New("at least two trustees are required")
+	}
+	if n > math.MaxUint16 {
+		return fmt.Errorf("at most %v trustees are supported", math.MaxUint16)
+	}
+	if c.Threshold < 1 || c.Threshold > n {
+		return fmt.Errorf("threshold must be between 1 and %v", n)
+	}
+	seen := make(map[common.Address]struct{}, n)
+	for _, k := range c.TrusteePublicKeys {
+		address := crypto.PubkeyToAddress(*k)
+		if _, ok := seen[address]; ok {
+			return fmt.Errorf("trustee %s is listed more than once", address)
+		}
+		seen[address] = struct{}{}
+	}
+	if c.DealingEnd.IsZero() {
+		return errors.New("dealing end time is required")
+	}
+	if !c.ComplaintEnd.After(c.DealingEnd) {
+		return errors.New("complaint end time must be after the dealing end time")
+	}
+	return nil
+}
+
+// trusteeIndex returns the index of the trustee with the address, starting
+// from one, or zero if the address is not a trustee.
+func (c *keyCeremony) trusteeIndex(address common.Address) int {
+	for i, k := range c.TrusteePublicKeys {
+		if crypto.PubkeyToAddress(*k) == address {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// parseTrusteePublicKeys parses comma separated hex encoded compressed public
+// keys.
+func parseTrusteePublicKeys(s string) ([]*ecdsa.PublicKey, error) {
+	var keys []*ecdsa.PublicKey
+	for _, k := range strings.Split(s, ",") {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		data, err := hex.DecodeString(strings.TrimPrefix(k, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid trustee public key %q", k)
+		}
+		key, err := crypto.DecompressPubkey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trustee public key %q", k)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// keyCeremonyRecipient returns the address that is set as the recipient of
+// dealings and complaints of the key ceremony, so that they can be filtered
+// by the indexed recipient topic of the Attested event.
+func keyCeremonyRecipient(ceremonyUID eas.UID) common.Address {
+	return common.BytesToAddress(crypto.Keccak256([]byte("SchulzeOnEAS key ceremony"), ceremonyUID[:]))
+}
+
+func (a *app) createKeyCeremony(ctx context.Context, c *keyCeremony) (*types.Transaction, eas.WaitTx[eas.EASAttested], error) {
+	if a.config.schema(keyCeremonySchemaName).UID.IsZero() {
+		return nil, nil, errors.New("configuration does not support key ceremonies")
+	}
+	if err := c.validate(); err != nil {
+		return nil, nil, err
+	}
+	s := keyCeremonySchema{
+		Threshold:        uint16(c.Threshold),
+		DealingEndTime:   unixTimestamp(c.DealingEnd),
+		ComplaintEndTime: unixTimestamp(c.ComplaintEnd),
+	}
+	for _, k := range c.TrusteePublicKeys {
+		s.TrusteePublicKeys = append(s.TrusteePublicKeys, crypto.CompressPubkey(k))
+	}
+	return a.client.EAS.Attest(ctx, a.config.schema(keyCeremonySchemaName).UID, &eas.AttestOptions{
+		RefUID: a.configUID,
+	}, s)
+}
+
+func (a *app) getKeyCeremony(ctx context.Context, ceremonyUID eas.UID) (*keyCeremony, error) {
+	attestation, err := a.client.EAS.GetAttestation(ctx, ceremonyUID)
+	if err != nil {
+		return nil, err
+	}
+	if attestation.Schema.IsZero() {
+		return nil, fmt.Errorf("key ceremony %s not found", ceremonyUID)
+	}
+	if attestation.Schema != a.config.schema(keyCeremonySchemaName).UID {
+		return nil, fmt.Errorf("attestation %s is not a key ceremony", ceremonyUID)
+	}
+	var s keyCeremonySchema
+	if err := attestation.ScanValues(&s); err != nil {
+		return nil, err
+	}
+	c := &keyCeremony{
+		UID:          attestation.UID,
+		Attester:     attestation.Attester,
+		Threshold:    int(s.Threshold),
+		DealingEnd:   unixTime(s.DealingEndTime),
+		ComplaintEnd: unixTime(s.ComplaintEndTime),
+	}
+	for _, k := range s.TrusteePublicKeys {
+		key, err := crypto.DecompressPubkey(k)
+		if err != nil {
+			return nil, fmt.Errorf("key ceremony %s trustee public key: %w", ceremonyUID, err)
+		}
+		c.TrusteePublicKeys = append(c.TrusteePublicKeys, key)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("key ceremony %s: %w", ceremonyUID, err)
+	}
+	return c, nil
+}
+
+// keyCeremonyPublicKey returns the compressed tallier public key of the final
+// key ceremony.
+func (a *app) keyCeremonyPublicKey(ctx context.Context, ceremonyUID eas.UID) ([]byte, error) {
+	c, err := a.getKeyCeremony(ctx, ceremonyUID)
+	if err != nil {
+		return nil, err
+	}
+	s, err := a.getKeyCeremonyState(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if !s.Final {
+		return nil, fmt.Errorf("key of key ceremony %s is not final", c.UID)
+	}
+	return crypto.CompressPubkey(s.publicKey()), nil
+}
+
+// newKeyDealing generates a random polynomial of the degree one less than
+// the threshold and returns commitments of its coefficients and its values
+// at trustee indexes encrypted to trustee public keys. The polynomial is not
+// stored, as trustees need only their shares.
+func newKeyDealing(c *keyCeremony, dealer common.Address) (keyDealingSchema, error) {
+	var s keyDealingSchema
+	coefficients := make([]*big.Int, 0, c.Threshold)
+	for range c.Threshold {
+		k, err := crypto.GenerateKey()
+		if err != nil {
+			return s, err
+		}
+		coefficients = append(coefficients, k.D)
+		s.Commitments = append(s.Commitments, crypto.CompressPubkey(&k.PublicKey))
+	}
+	for i, trustee := range c.TrusteePublicKeys {
+		share := evaluatePolynomial(coefficients, i+1)
+		ciphertext, err := sealECIES(trustee, keyShareEncryptionDomain, keySharePlaintext(c.UID, dealer, i+1, share))
+		if err != nil {
+			return s, err
+		}
+		s.EncryptedShares = append(s.EncryptedShares, ciphertext)
+	}
+	return s, nil
+}
+
+// keySharePlaintext returns the key ceremony UID, the dealer address, the
+// trustee index as a big endian uint16 value and the share as a 32 bytes
+// big endian value. The ceremony, the dealer and the index are included so
+// that the share can not be copied to another dealing.
+func keySharePlaintext(ceremonyUID eas.UID, dealer common.Address, index int, share *big.Int) []byte {
+	data := make([]byte, 0, len(ceremonyUID)+len(dealer)+2+32)
+	data = append(data, ceremonyUID[:]...)
+	data = append(data, dealer[:]...)
+	data = binary.BigEndian.AppendUint16(data, uint16(index))
+	return append(data, share.FillBytes(make([]byte, 32))...)
+}
+
+// parseKeySharePlaintext returns the share from the plaintext if it is for
+// the ceremony, the dealer and the trustee index.
+func parseKeySharePlaintext(data []byte, ceremonyUID eas.UID, dealer common.Address, index int) (*big.Int, error) {
+	prefix := keySharePlaintext(ceremonyUID, dealer, index, new(big.Int))
+	if len(data) != len(prefix) || !bytes.Equal(data[:len(prefix)-32], prefix[:len(prefix)-32]) {
+		return nil, errors.New("share is not for the trustee in the dealing")
+	}
+	share := new(big.Int).SetBytes(data[len(prefix)-32:])
+	if share.Cmp(crypto.S256().Params().N) >= 0 {
+		return nil, errors.New("share is out of range")
+	}
+	return share, nil
+}
+
+// evaluatePolynomial returns the value of the polynomial with the
+// coefficients in the ascending order of powers at x modulo the curve order.
+func evaluatePolynomial(coefficients []*big.Int, x int) *big.Int {
+	n := crypto.S256().Params().N
+	v := new(big.Int)
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		v.Mul(v, big.NewInt(int64(x)))
+		v.Add(v, coefficients[i])
+		v.Mod(v, n)
+	}
+	return v
+}
+
+// evaluateCommitments returns the value of the polynomial at x multiplied
+// by the generator from commitments of its coefficients.
+func evaluateCommitments(commitments []*ecdsa.PublicKey, x int) *ecdsa.PublicKey {
+	var v *ecdsa.PublicKey
+	for i := len(commitments) - 1; i >= 0; i-- {
+		v = addPoints(scalarMult(v, big.NewInt(int64(x))), commitments[i])
+	}
+	return v
+}
+
+// lagrangeCoefficient returns the value of the Lagrange basis polynomial of
+// the index at zero for the indexes, modulo the curve order.
+func lagrangeCoefficient(index int, indexes []int) *big.Int {
+	n := crypto.S256().Params().N
+	numerator := big.NewInt(1)
+	denominator := big.NewInt(1)
+	for _, j := range indexes {
+		if j == index {
+			continue
+		}
+		numerator.Mul(numerator, big.NewInt(int64(j)))
+		numerator.Mod(numerator, n)
+		denominator.Mul(denominator, big.NewInt(int64(j-index)))
+		denominator.Mod(denominator, n)
+	}
+	denominator.ModInverse(denominator, n)
+	numerator.Mul(numerator, denominator)
+	return numerator.Mod(numerator, n)
+}
+
+// sortedIndexes returns trustee indexes that are keys of the map in the
+// ascending order.
+func sortedIndexes[T any](m map[int]T) []int {
+	indexes := make([]int, 0, len(m))
+	for i := range m {
+		indexes = append(indexes, i)
+	}
+	slices.Sort(indexes)
+	return indexes
+}
+
+// combinePartialDecryptions returns the shared secret interpolated from
+// partial decryptions of trustees with the lowest threshold number of
+// indexes.
+func combinePartialDecryptions(partials map[int]*ecdsa.PublicKey, threshold int) *ecdsa.PublicKey {
+	indexes := sortedIndexes(partials)[:threshold]
+	var shared *ecdsa.PublicKey
+	for _, i := range indexes {
+		shared = addPoints(shared, scalarMult(partials[i], lagrangeCoefficient(i, indexes)))
+	}
+	return shared
+}
+
+func (p *decryptionProof) schema() decryptionProofSchema {
+	s := decryptionProofSchema{
+		A: p.A,
+		B: p.B,
+	}
+	copy(s.Z[:], p.Z)
+	return s
+}
+
+func newDecryptionProof(s decryptionProofSchema) *decryptionProof {
+	return &decryptionProof{
+		A: s.A,
+		B: s.B,
+		Z: s.Z[:],
+	}
+}
+
+// keyDealing is a valid dealing attestation of a trustee in the key
+// ceremony.
+type keyDealing struct {
+	UID             eas.UID
+	Dealer          common.Address
+	Time            time.Time
+	Commitments     []*ecdsa.PublicKey
+	EncryptedShares [][]byte
+}
+
+func decodeKeyDealing(c *keyCeremony, attestation *eas.Attestation) (*keyDealing, error) {
+	var s keyDealingSchema
+	if err := attestation.ScanValues(&s); err != nil {
+		return nil, err
+	}
+	if len(s.Commitments) != c.Threshold {
+		return nil, fmt.Errorf("dealing has %v commitments for threshold %v", len(s.Commitments), c.Threshold)
+	}
+	if len(s.EncryptedShares) != len(c.TrusteePublicKeys) {
+		return nil, fmt.Errorf("dealing has %v shares for %v trustees", len(s.EncryptedShares), len(c.TrusteePublicKeys))
+	}
+	d := &keyDealing{
+		UID:             attestation.UID,
+		Dealer:          attestation.Attester,
+		Time:            attestation.Time,
+		EncryptedShares: s.EncryptedShares,
+	}
+	for _, commitment := range s.Commitments {
+		p, err := crypto.DecompressPubkey(commitment)
+		if err != nil {
+			return nil, fmt.Errorf("dealing commitment: %w", err)
+		}
+		d.Commitments = append(d.Commitments, p)
+	}
+	return d, nil
+}
+
+// decryptShare decrypts the share of the trustee with the index using the
+// shared secret and checks it against the commitments.
+func (d *keyDealing) decryptShare(c *keyCeremony, index int, shared *ecdsa.PublicKey) (*big.Int, error) {
+	plaintext, err := openECIES(d.EncryptedShares[index-1], shared, keyShareEncryptionDomain)
+	if err != nil {
+		return nil, err
+	}
+	share, err := parseKeySharePlaintext(plaintext, c.UID, d.Dealer, index)
+	if err != nil {
+		return nil, err
+	}
+	if !equalPoints(scalarBaseMult(share), evaluateCommitments(d.Commitments, index)) {
+		return nil, errors.New("share does not match the dealing commitments")
+	}
+	return share, nil
+}
+
+// isValidComplaint returns true if the complaint of the trustee with the
+// index proves that the share that the trustee received in the dealing is
+// invalid.
+func (d *keyDealing) isValidComplaint(c *keyCeremony, index int, s keyComplaintSchema) bool {
+	ephemeral, err := ephemeralPublicKey(d.EncryptedShares[index-1])
+	if err != nil {
+		return true
+	}
+	shared, err := crypto.DecompressPubkey(s.SharedSecret)
+	if err != nil {
+		return false
+	}
+	if err := newDecryptionProof(s.Proof).verify(c.TrusteePublicKeys[index-1], ephemeral, shared); err != nil {
+		return false
+	}
+	_, err = d.decryptShare(c, index, shared)
+	return err != nil
+}
+
+// keyCeremonyState is the outcome of dealings and complaints in the key
+// ceremony.
+type keyCeremonyState struct {
+	Ceremony *keyCeremony
+	// The first valid dealing of every trustee attested before the dealing
+	// end time, by the trustee index.
+	Dealings map[int]*keyDealing
+	// UIDs of the first valid complaint attested before the complaint end
+	// time about the dealing of the trustee, by the trustee index.
+	Complaints map[int]eas.UID
+	// Indexes of trustees with dealings without valid complaints in the
+	// ascending order.
+	Qualified []int
+	// True if complaints are closed and there are at least the threshold
+	// number of qualified dealings.
+	Final bool
+}
+
+// publicKey returns the tallier public key, which is the sum of the first
+// commitments of qualified dealings.
+func (s *keyCeremonyState) publicKey() *ecdsa.PublicKey {
+	var key *ecdsa.PublicKey
+	for _, i := range s.Qualified {
+		key = addPoints(key, s.Dealings[i].Commitments[0])
+	}
+	return key
+}
+
+// verificationKey returns the public key of the key share of the trustee
+// with the index, which is the sum of its shares from qualified dealings
+// multiplied by the generator.
+func (s *keyCeremonyState) verificationKey(index int) *ecdsa.PublicKey {
+	var key *ecdsa.PublicKey
+	for _, i := range s.Qualified {
+		key = addPoints(key, evaluateCommitments(s.Dealings[i].Commitments, index))
+	}
+	return key
+}
+
+// trusteeShare returns the index of the trustee with the private key and its
+// key share decrypted from qualified dealings.
+func (s *keyCeremonyState) trusteeShare(key *ecdsa.PrivateKey) (int, *big.Int, error) {
+	c := s.Ceremony
+	index := c.trusteeIndex(crypto.PubkeyToAddress(key.PublicKey))
+	if index == 0 {
+		return 0, nil, fmt.Errorf("account is not a trustee of key ceremony %s", c.UID)
+	}
+	share := new(big.Int)
+	for _, i := range s.Qualified {
+		d := s.Dealings[i]
+		ephemeral, err := ephemeralPublicKey(d.EncryptedShares[index-1])
+		if err != nil {
+			return 0, nil, fmt.Errorf("share from trustee %v: %w", i, err)
+		}
+		v, err := d.decryptShare(c, index, scalarMult(ephemeral, key.D))
+		if err != nil {
+			return 0, nil, fmt.Errorf("share from trustee %v: %w", i, err)
+		}
+		share.Add(share, v)
+	}
+	return index, share.Mod(share, crypto.S256().Params().N), nil
+}
+
+// getKeyCeremonyState returns the state of the key ceremony from dealings
+// and complaints on the chain.
+func (a *app) getKeyCeremonyState(ctx context.Context, c *keyCeremony) (*keyCeremonyState, error) {
+	currentBlock, err := a.client.Backend().(ethereum.BlockNumberReader).BlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now, err := a.chainTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	recipients := []common.Address{keyCeremonyRecipient(c.UID)}
+
+	dealings, err := a.scanAttestations(ctx, a.config.schema(keyDealingSchemaName).Block, currentBlock, recipients, a.config.schema(keyDealingSchemaName).UID)
+	if err != nil {
+		return nil, err
+	}
+	complaints, err := a.scanAttestations(ctx, a.config.schema(keyComplaintSchemaName).Block, currentBlock, recipients, a.config.schema(keyComplaintSchemaName).UID)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &keyCeremonyState{
+		Ceremony:   c,
+		Dealings:   make(map[int]*keyDealing),
+		Complaints: make(map[int]eas.UID),
+	}
+	dealers := make(map[eas.UID]int)
+	for _, r := range dealings {
+		if r.attestation.RefUID != c.UID || !r.attestation.Time.Before(c.DealingEnd) {
+			continue
+		}
+		index := c.trusteeIndex(r.event.Attester)
+		if _, ok := s.Dealings[index]; ok || index == 0 {
+			continue
+		}
+		d, err := decodeKeyDealing(c, r.attestation)
+		if err != nil {
+			continue
+		}
+		s.Dealings[index] = d
+		dealers[d.UID] = index
+	}
+	for _, r := range complaints {
+		dealer, ok := dealers[r.attestation.RefUID]
+		if !ok || !r.attestation.Time.Before(c.ComplaintEnd) {
+			continue
+		}
+		if _, ok := s.Complaints[dealer]; ok {
+			continue
+		}
+		index := c.trusteeIndex(r.event.Attester)
+		if index == 0 {
+			continue
+		}
+		var complaint keyComplaintSchema
+		if err := r.attestation.ScanValues(&complaint); err != nil {
+			continue
+		}
+		if s.Dealings[dealer].isValidComplaint(c, index, complaint) {
+			s.Complaints[dealer] = r.attestation.UID
+		}
+	}
+	for _, index := range sortedIndexes(s.Dealings) {
+		if _, ok := s.Complaints[index]; !ok {
+			s.Qualified = append(s.Qualified, index)
+		}
+	}
+	s.Final = !now.Before(c.ComplaintEnd) && len(s.Qualified) >= c.Threshold
+	return s, nil
+}
+
+// dealKeyShares attests the dealing of the account in the key ceremony.
+func (a *app) dealKeyShares(ctx context.Context, c *keyCeremony) (*types.Transaction, eas.WaitTx[eas.EASAttested], error) {
+	if c.trusteeIndex(a.client.Address()) == 0 {
+		return nil, nil, fmt.Errorf("account is not a trustee of key ceremony %s", c.UID)
+	}
+	now, err := a.chainTime(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !now.Before(c.DealingEnd) {
+		return nil, nil, fmt.Errorf("dealing closed at %s", formatTime(c.DealingEnd))
+	}
+	s, err := a.getKeyCeremonyState(ctx, c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := s.Dealings[c.trusteeIndex(a.client.Address())]; ok {
+		return nil, nil, fmt.Errorf("account already dealt in key ceremony %s", c.UID)
+	}
+	dealing, err := newKeyDealing(c, a.client.Address())
+	if err != nil {
+		return nil, nil, err
+	}
+	return a.client.EAS.Attest(ctx, a.config.schema(keyDealingSchemaName).UID, &eas.AttestOptions{
+		Recipient: keyCeremonyRecipient(c.UID),
+		RefUID:    c.UID,
+	}, dealing)
+}
+
+// complainKeyShares checks shares that the trustee with the private key
+// received in dealings of the key ceremony and attests a complaint about
+// every dealing with an invalid share. It returns UIDs of complaints.
+func (a *app) complainKeyShares(ctx context.Context, c *keyCeremony, key *ecdsa.PrivateKey) ([]eas.UID, error) {
+	index := c.trusteeIndex(crypto.PubkeyToAddress(key.PublicKey))
+	if index == 0 {
+		return nil, fmt.Errorf("account is not a trustee of key ceremony %s", c.UID)
+	}
+	now, err := a.chainTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !now.Before(c.ComplaintEnd) {
+		return nil, fmt.Errorf("complaints closed at %s", formatTime(c.ComplaintEnd))
+	}
+	s, err := a.getKeyCeremonyState(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	var uids []eas.UID
+	for _, dealer := range sortedIndexes(s.Dealings) {
+		if _, ok := s.Complaints[dealer]; ok {
+			continue
+		}
+		d := s.Dealings[dealer]
+		var complaint keyComplaintSchema
+		if ephemeral, err := ephemeralPublicKey(d.EncryptedShares[index-1]); err == nil {
+			shared := scalarMult(ephemeral, key.D)
+			if _, err := d.decryptShare(c, index, shared); err == nil {
+				continue
+			}
+			proof, err := proveDecryption(key, ephemeral, shared)
+			if err != nil {
+				return nil, err
+			}
+			complaint.SharedSecret = crypto.CompressPubkey(shared)
+			complaint.Proof = proof.schema()
+		}
+		_, wait, err := a.client.EAS.Attest(ctx, a.config.schema(keyComplaintSchemaName).UID, &eas.AttestOptions{
+			Recipient: keyCeremonyRecipient(c.UID),
+			RefUID:    d.UID,
+		}, complaint)
+		if err != nil {
+			return nil, err
+		}
+		r, err := wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+		uids = append(uids, r.UID)
+	}
+	return uids, nil
+}
+
+// partialDecrypt attests partial decryptions of encrypted ballots of the
+// voting that were attested before the voting end time, or of the aggregate
+// of counted ballots if the voting has homomorphic tallying, with the key
+// share of the trustee with the private key.
+func (a *app) partialDecrypt(ctx context.Context, v *voting, c *keyCeremony, key *ecdsa.PrivateKey) (*types.Transaction, eas.WaitTx[eas.EASAttested], error) {
+	if !v.isEncrypted() {
+		return nil, nil, fmt.Errorf("voting %s does not have encrypted ballots", v.UID)
+	}
+	s, err := a.getKeyCeremonyState(ctx, c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !s.Final {
+		return nil, nil, fmt.Errorf("key of key ceremony %s is not final", c.UID)
+	}
+	if !bytes.Equal(crypto.CompressPubkey(s.publicKey()), v.TallierPublicKey) {
+		return nil, nil, fmt.Errorf("voting %s is not encrypted to the key of key ceremony %s", v.UID, c.UID)
+	}
+	now, err := a.chainTime(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if now.Before(v.End) {
+		return nil, nil, fmt.Errorf("ballots can be decrypted after %s", formatTime(v.End))
+	}
+
+	index, share, err := s.trusteeShare(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	shareKey := &ecdsa.PrivateKey{
+		PublicKey: *s.verificationKey(index),
+		D:         share,
+	}
+
+	if v.HomomorphicTally {
+		count, err := a.countBallots(ctx, v.UID, tallyOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		p := aggregatePartialDecryptionSchema{
+			KeyCeremonyUID: c.UID,
+		}
+		for _, ciphertext := range count.Aggregate.ciphertexts() {
+			if ciphertext.A == nil {
+				p.Shares = append(p.Shares, nil)
+				p.Proofs = append(p.Proofs, decryptionProofSchema{})
+				continue
+			}
+			partial := scalarMult(ciphertext.A, share)
+			proof, err := proveDecryption(shareKey, ciphertext.A, partial)
+			if err != nil {
+				return nil, nil, err
+			}
+			p.Shares = append(p.Shares, crypto.CompressPubkey(partial))
+			p.Proofs = append(p.Proofs, proof.schema())
+		}
+		return a.client.EAS.Attest(ctx, a.config.schema(aggregatePartialDecryptionSchemaName).UID, &eas.AttestOptions{
+			Recipient: votingRecipient(v.UID),
+			RefUID:    v.UID,
+		}, p)
+	}
+
+	ballots, err := a.getEncryptedBallots(ctx, v)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := partialDecryptionSchema{
+		KeyCeremonyUID: c.UID,
+	}
+	for _, b := range ballots {
+		if !b.Time.Before(v.End) {
+			continue
+		}
+		ephemeral, err := ephemeralPublicKey(b.Ciphertext)
+		if err != nil {
+			continue
+		}
+		partial := scalarMult(ephemeral, share)
+		proof, err := proveDecryption(shareKey, ephemeral, partial)
+		if err != nil {
+			return nil, nil, err
+		}
+		p.Decryptions = append(p.Decryptions, ballotPartialDecryption{
+			BallotUID: b.UID,
+			Share:     crypto.CompressPubkey(partial),
+			Proof:     proof.schema(),
+		})
+	}
+	return a.client.EAS.Attest(ctx, a.config.schema(partialDecryptionSchemaName).UID, &eas.AttestOptions{
+		Recipient: votingRecipient(v.UID),
+		RefUID:    v.UID,
+	}, p)
+}
+
+// decryptWithPartialDecryptions returns ballots decrypted with shared
+// secrets combined from partial decryptions of trustees, or nil ballots if
+// they could not be decrypted. Only the first partial decryption of every
+// trustee attested after the voting end time in the key ceremony of the
+// first partial decryption with the voting tallier public key is used, and
+// shares of partial decryptions must be proven against trustee verification
+// keys. Ballots without the threshold number of partial decryptions are not
+// returned.
+func (a *app) decryptWithPartialDecryptions(ctx context.Context, v *voting, ballots []encryptedBallotRecord) (map[eas.UID]ballotSchema, error) {
+	currentBlock, err := a.client.Backend().(ethereum.BlockNumberReader).BlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+	attested, err := a.scanAttestations(ctx, a.config.schema(partialDecryptionSchemaName).Block, currentBlock, []common.Address{votingRecipient(v.UID)}, a.config.schema(partialDecryptionSchemaName).UID)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemerals := make(map[eas.UID]*ecdsa.PublicKey, len(ballots))
+	for _, b := range ballots {
+		if ephemeral, err := ephemeralPublicKey(b.Ciphertext); err == nil {
+			ephemerals[b.UID] = ephemeral
+		}
+	}
+
+	var s *keyCeremonyState
+	trustees := make(map[int]struct{})
+	partials := make(map[eas.UID]map[int]*ecdsa.PublicKey)
+	for _, r := range attested {
+		if r.attestation.RefUID != v.UID || r.attestation.Time.Before(v.End) {
+			continue
+		}
+		var p partialDecryptionSchema
+		if err := r.attestation.ScanValues(&p); err != nil {
+			continue
+		}
+		if s == nil {
+			s, err = a.votingKeyCeremonyState(ctx, v, p.KeyCeremonyUID)
+			if err != nil {
+				return nil, err
+			}
+			if s == nil {
+				continue
+			}
+		}
+		if p.KeyCeremonyUID != s.Ceremony.UID {
+			continue
+		}
+		index := s.Ceremony.trusteeIndex(r.event.Attester)
+		if _, ok := trustees[index]; ok || index == 0 {
+			continue
+		}
+		trustees[index] = struct{}{}
+		verificationKey := s.verificationKey(index)
+		for _, d := range p.Decryptions {
+			ephemeral, ok := ephemerals[d.BallotUID]
+			if !ok {
+				continue
+			}
+			share, err := crypto.DecompressPubkey(d.Share)
+			if err != nil {
+				continue
+			}
+			if err := newDecryptionProof(d.Proof).verify(verificationKey, ephemeral, share); err != nil {
+				continue
+			}
+			if partials[d.BallotUID] == nil {
+				partials[d.BallotUID] = make(map[int]*ecdsa.PublicKey)
+			}
+			partials[d.BallotUID][index] = share
+		}
+	}
+	if s == nil {
+		return nil, fmt.Errorf("voting %s has encrypted ballots that require a decryption transcript or partial decryptions of trustees", v.UID)
+	}
+
+	decrypted := make(map[eas.UID]ballotSchema, len(ballots))
+	for _, b := range ballots {
+		if _, ok := ephemerals[b.UID]; !ok {
+			decrypted[b.UID] = nil
+			continue
+		}
+		p := partials[b.UID]
+		if len(p) < s.Ceremony.Threshold {
+			continue
+		}
+		ballot, err := decryptBallot(b.Ciphertext, combinePartialDecryptions(p, s.Ceremony.Threshold), v.UID, b.Attester)
+		if err != nil {
+			ballot = nil
+		}
+		decrypted[b.UID] = ballot
+	}
+	return decrypted, nil
+}
+
+// votingKeyCeremonyState returns the state of the key ceremony if it is
+// final and its key is the tallier public key of the voting, or nil
+// otherwise.
+func (a *app) votingKeyCeremonyState(ctx context.Context, v *voting, ceremonyUID eas.UID) (*keyCeremonyState, error) {
+	c, err := a.getKeyCeremony(ctx, ceremonyUID)
+	if err != nil {
+		return nil, nil
+	}
+	s, err := a.getKeyCeremonyState(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if !s.Final || !bytes.Equal(crypto.CompressPubkey(s.publicKey()), v.TallierPublicKey) {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// combineAggregatePartialDecryptions returns shared secrets of ciphertexts of
+// the aggregate of counted ballots combined from partial decryptions of
+// trustees. The same partial decryptions are selected as for ballots, and
+// partial decryptions with any proof that is not valid for the aggregate are
+// not used.
+func (a *app) combineAggregatePartialDecryptions(ctx context.Context, v *voting, ciphertexts []elGamalCiphertext) ([]*ecdsa.PublicKey, error) {
+	currentBlock, err := a.client.Backend().(ethereum.BlockNumberReader).BlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+	attested, err := a.scanAttestations(ctx, a.config.schema(aggregatePartialDecryptionSchemaName).Block, currentBlock, []common.Address{votingRecipient(v.UID)}, a.config.schema(aggregatePartialDecryptionSchemaName).UID)
+	if err != nil {
+		return nil, err
+	}
+
+	var s *keyCeremonyState
+	trustees := make(map[int]struct{})
+	partials := make(map[int][]*ecdsa.PublicKey)
+	for _, r := range attested {
+		if r.attestation.RefUID != v.UID || r.attestation.Time.Before(v.End) {
+			continue
+		}
+		var p aggregatePartialDecryptionSchema
+		if err := r.attestation.ScanValues(&p); err != nil {
+			continue
+		}
+		if s == nil {
+			s, err = a.votingKeyCeremonyState(ctx, v, p.KeyCeremonyUID)
+			if err != nil {
+				return nil, err
+			}
+			if s == nil {
+				continue
+			}
+		}
+		if p.KeyCeremonyUID != s.Ceremony.UID {
+			continue
+		}
+		index := s.Ceremony.trusteeIndex(r.event.Attester)
+		if _, ok := trustees[index]; ok || index == 0 {
+			continue
+		}
+		trustees[index] = struct{}{}
+		if shares, ok := verifyAggregatePartialDecryption(p, s.verificationKey(index), ciphertexts); ok {
+			partials[index] = shares
+		}
+	}
+	if s == nil {
+		return nil, fmt.Errorf("voting %s has encrypted ballots that require a decryption transcript or partial decryptions of trustees", v.UID)
+	}
+	if len(partials) < s.Ceremony.Threshold {
+		return nil, fmt.Errorf("aggregate of counted ballots has valid partial decryptions of %v trustees out of %v required", len(partials), s.Ceremony.Threshold)
+	}
+
+	shared := make([]*ecdsa.PublicKey, len(ciphertexts))
+	for i, c := range ciphertexts {
+		if c.A == nil {
+			continue
+		}
+		p := make(map[int]*ecdsa.PublicKey, len(partials))
+		for index, shares := range partials {
+			p[index] = shares[i]
+		}
+		shared[i] = combinePartialDecryptions(p, s.Ceremony.Threshold)
+	}
+	return shared, nil
+}
+
+// verifyAggregatePartialDecryption returns shares of the partial decryption
+// if all of them are proven against the verification key of the trustee for
+// the ciphertexts.
+func verifyAggregatePartialDecryption(p aggregatePartialDecryptionSchema, verificationKey *ecdsa.PublicKey, ciphertexts []elGamalCiphertext) ([]*ecdsa.PublicKey, bool) {
+	if len(p.Shares) != len(ciphertexts) || len(p.Proofs) != len(ciphertexts) {
+		return nil, false
+	}
+	shares := make([]*ecdsa.PublicKey, len(ciphertexts))
+	for i, c := range ciphertexts {
+		if c.A == nil {
+			continue
+		}
+		share, err := crypto.DecompressPubkey(p.Shares[i])
+		if err != nil {
+			return nil, false
+		}
+		if err := newDecryptionProof(p.Proofs[i]).verify(verificationKey, c.A, share); err != nil {
+			return nil, false
+		}
+		shares[i] = share
+	}
+	return shares, true
+}
@@ -0,0 +1,320 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"resenje.org/eas"
+)
+
+func TestCombinePartialDecryptions(t *testing.T) {
+	coefficients := make([]*big.Int, 0, 3)
+	for range 3 {
+		k, err := crypto.GenerateKey()
+		assertNilError(t, err)
+		coefficients = append(coefficients, k.D)
+	}
+	ephemeral, err := crypto.GenerateKey()
+	assertNilError(t, err)
+
+	partials := make(map[int]*ecdsa.PublicKey)
+	for i := 1; i <= 5; i++ {
+		partials[i] = scalarMult(&ephemeral.PublicKey, evaluatePolynomial(coefficients, i))
+	}
+	want := scalarMult(&ephemeral.PublicKey, coefficients[0])
+
+	for _, indexes := range [][]int{
+		{1, 2, 3},
+		{1, 3, 5},
+		{2, 4, 5},
+		{5, 4, 3, 2, 1},
+	} {
+		p := make(map[int]*ecdsa.PublicKey)
+		for _, i := range indexes {
+			p[i] = partials[i]
+		}
+		if !equalPoints(combinePartialDecryptions(p, 3), want) {
+			t.Errorf("indexes %v: combined shared secret does not match", indexes)
+		}
+	}
+
+	if equalPoints(combinePartialDecryptions(map[int]*ecdsa.PublicKey{
+		1: partials[1],
+		2: partials[2],
+	}, 2), want) {
+		t.Error("combined the shared secret with less than threshold partial decryptions")
+	}
+}
+
+func TestKeyDealing_decryptShare(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 0, 3)
+	c := &keyCeremony{
+		UID:       eas.HexDecodeUID("0x01"),
+		Threshold: 2,
+	}
+	for range 3 {
+		k, err := crypto.GenerateKey()
+		assertNilError(t, err)
+		keys = append(keys, k)
+		c.TrusteePublicKeys = append(c.TrusteePublicKeys, &k.PublicKey)
+	}
+	dealer := crypto.PubkeyToAddress(keys[0].PublicKey)
+
+	s, err := newKeyDealing(c, dealer)
+	assertNilError(t, err)
+	d := &keyDealing{
+		Dealer:          dealer,
+		EncryptedShares: s.EncryptedShares,
+	}
+	for _, commitment := range s.Commitments {
+		p, err := crypto.DecompressPubkey(commitment)
+		assertNilError(t, err)
+		d.Commitments = append(d.Commitments, p)
+	}
+
+	sharedSecret := func(index int, key *ecdsa.PrivateKey) *ecdsa.PublicKey {
+		ephemeral, err := ephemeralPublicKey(d.EncryptedShares[index-1])
+		assertNilError(t, err)
+		return scalarMult(ephemeral, key.D)
+	}
+
+	for i, k := range keys {
+		_, err := d.decryptShare(c, i+1, sharedSecret(i+1, k))
+		assertNilError(t, err)
+	}
+
+	if _, err := d.decryptShare(c, 1, sharedSecret(1, keys[1])); err == nil {
+		t.Error("decrypted the share with the key of another trustee")
+	}
+
+	other := &keyCeremony{
+		UID:               eas.HexDecodeUID("0x02"),
+		TrusteePublicKeys: c.TrusteePublicKeys,
+		Threshold:         c.Threshold,
+	}
+	if _, err := d.decryptShare(other, 1, sharedSecret(1, keys[0])); err == nil {
+		t.Error("decrypted the share for another key ceremony")
+	}
+
+	// a share that does not match commitments
+	ciphertext, err := sealECIES(c.TrusteePublicKeys[1], keyShareEncryptionDomain, keySharePlaintext(c.UID, dealer, 2, big.NewInt(1)))
+	assertNilError(t, err)
+	d.EncryptedShares[1] = ciphertext
+	shared := sharedSecret(2, keys[1])
+	if _, err := d.decryptShare(c, 2, shared); err == nil {
+		t.Error("decrypted the share that does not match commitments")
+	}
+
+	proof, err := proveDecryption(keys[1], scalarMult(shared, big.NewInt(1)), shared)
+	assertNilError(t, err)
+	if d.isValidComplaint(c, 2, keyComplaintSchema{
+		SharedSecret: crypto.CompressPubkey(shared),
+		Proof:        proof.schema(),
+	}) {
+		t.Error("complaint with an invalid proof is valid")
+	}
+	ephemeral, err := ephemeralPublicKey(d.EncryptedShares[1])
+	assertNilError(t, err)
+	proof, err = proveDecryption(keys[1], ephemeral, shared)
+	assertNilError(t, err)
+	if !d.isValidComplaint(c, 2, keyComplaintSchema{
+		SharedSecret: crypto.CompressPubkey(shared),
+		Proof:        proof.schema(),
+	}) {
+		t.Error("complaint about the invalid share is not valid")
+	}
+	shared = sharedSecret(3, keys[2])
+	ephemeral, err = ephemeralPublicKey(d.EncryptedShares[2])
+	assertNilError(t, err)
+	proof, err = proveDecryption(keys[2], ephemeral, shared)
+	assertNilError(t, err)
+	if d.isValidComplaint(c, 3, keyComplaintSchema{
+		SharedSecret: crypto.CompressPubkey(shared),
+		Proof:        proof.schema(),
+	}) {
+		t.Error("complaint about the valid share is valid")
+	}
+}
+
+func TestCalculateResults_threshold(t *testing.T) {
+	c := newTestChain(t, 3)
+
+	ctx := context.Background()
+
+	apps := make([]*app, 0, len(c.accounts))
+	for _, account := range c.accounts {
+		apps = append(apps, c.newApp(t, account))
+	}
+
+	now, err := apps[0].chainTime(ctx)
+	assertNilError(t, err)
+
+	ceremony := &keyCeremony{
+		Threshold:    2,
+		DealingEnd:   now.Add(time.Hour),
+		ComplaintEnd: now.Add(2 * time.Hour),
+	}
+	for _, account := range c.accounts {
+		ceremony.TrusteePublicKeys = append(ceremony.TrusteePublicKeys, &account.PublicKey)
+	}
+	_, wait, err := apps[0].createKeyCeremony(ctx, ceremony)
+	assertNilError(t, err)
+	r, err := wait(ctx)
+	assertNilError(t, err)
+	ceremony, err = apps[0].getKeyCeremony(ctx, r.UID)
+	assertNilError(t, err)
+	assertEqual(t, "threshold", ceremony.Threshold, 2)
+
+	// the first trustee deals an invalid share to the second trustee
+	dealer := apps[0].client.Address()
+	dealing, err := newKeyDealing(ceremony, dealer)
+	assertNilError(t, err)
+	dealing.EncryptedShares[1], err = sealECIES(ceremony.TrusteePublicKeys[1], keyShareEncryptionDomain, keySharePlaintext(ceremony.UID, dealer, 2, big.NewInt(1)))
+	assertNilError(t, err)
+	_, wait, err = apps[0].client.EAS.Attest(ctx, apps[0].config.schema(keyDealingSchemaName).UID, &eas.AttestOptions{
+		Recipient: keyCeremonyRecipient(ceremony.UID),
+		RefUID:    ceremony.UID,
+	}, dealing)
+	assertNilError(t, err)
+	_, err = wait(ctx)
+	assertNilError(t, err)
+
+	for _, a := range apps[1:] {
+		_, wait, err := a.dealKeyShares(ctx, ceremony)
+		assertNilError(t, err)
+		_, err = wait(ctx)
+		assertNilError(t, err)
+	}
+	if _, _, err := apps[1].dealKeyShares(ctx, ceremony); err == nil {
+		t.Error("dealt key shares twice")
+	}
+	if _, err := apps[0].keyCeremonyPublicKey(ctx, ceremony.UID); err == nil {
+		t.Error("got the key of the key ceremony before the complaint end")
+	}
+
+	c.adjustTime(t, time.Hour)
+	c.commit(1)
+
+	for i, a := range apps {
+		uids, err := a.complainKeyShares(ctx, ceremony, c.accounts[i])
+		assertNilError(t, err)
+		want := 0
+		if i == 1 {
+			want = 1
+		}
+		assertEqual(t, "complaints", len(uids), want)
+	}
+
+	c.adjustTime(t, time.Hour)
+	c.commit(1)
+
+	s, err := apps[0].getKeyCeremonyState(ctx, ceremony)
+	assertNilError(t, err)
+	assertEqual(t, "final", s.Final, true)
+	assertEqual(t, "qualified", s.Qualified, []int{2, 3})
+	assertEqual(t, "disqualified", len(s.Complaints), 1)
+	for i, account := range c.accounts {
+		index, share, err := s.trusteeShare(account)
+		assertNilError(t, err)
+		assertEqual(t, "index", index, i+1)
+		if !equalPoints(scalarBaseMult(share), s.verificationKey(index)) {
+			t.Errorf("trustee %v share does not match its verification key", index)
+		}
+	}
+
+	key, err := apps[0].keyCeremonyPublicKey(ctx, ceremony.UID)
+	assertNilError(t, err)
+
+	now, err = apps[0].chainTime(ctx)
+	assertNilError(t, err)
+
+	v := createTestVoting(t, apps[0], &voting{
+		Title:            "Board election",
+		Choices:          []string{"Alice", "Bob"},
+		End:              now.Add(time.Hour),
+		TallierPublicKey: key,
+	})
+
+	castTestBallot(t, apps[0], v, "2,1")
+	castTestBallot(t, apps[1], v, "1,2")
+	castTestBallot(t, apps[2], v, "1,2")
+
+	if _, _, err := apps[0].partialDecrypt(ctx, v, ceremony, c.accounts[0]); err == nil {
+		t.Error("partially decrypted ballots before the voting end")
+	}
+
+	c.adjustTime(t, time.Hour)
+	c.commit(1)
+
+	if _, err := apps[0].calculateResults(ctx, v.UID, tallyOptions{}); err == nil {
+		t.Error("calculated results without partial decryptions")
+	}
+
+	for _, i := range []int{0, 2} {
+		_, wait, err := apps[i].partialDecrypt(ctx, v, ceremony, c.accounts[i])
+		assertNilError(t, err)
+		_, err = wait(ctx)
+		assertNilError(t, err)
+	}
+
+	results, err := apps[0].calculateResults(ctx, v.UID, tallyOptions{})
+	assertNilError(t, err)
+
+	assertEqual(t, "ballots", results.Ballots, 3)
+	assertEqual(t, "undecryptable", len(results.Undecryptable), 0)
+	assertEqual(t, "preferences", results.Preferences, [][]int{
+		{0, 2},
+		{1, 0},
+	})
+
+	now, err = apps[0].chainTime(ctx)
+	assertNilError(t, err)
+
+	v = createTestVoting(t, apps[0], &voting{
+		Title:            "Board election",
+		Choices:          []string{"Alice", "Bob", "Carol"},
+		End:              now.Add(time.Hour),
+		TallierPublicKey: key,
+		HomomorphicTally: true,
+	})
+
+	castTestBallot(t, apps[0], v, "3,1,2")
+	castTestBallot(t, apps[1], v, "1,2,3")
+	castTestBallot(t, apps[2], v, "2,1,3")
+
+	c.adjustTime(t, time.Hour)
+	c.commit(1)
+
+	_, wait, err = apps[1].partialDecrypt(ctx, v, ceremony, c.accounts[1])
+	assertNilError(t, err)
+	_, err = wait(ctx)
+	assertNilError(t, err)
+
+	if _, err := apps[0].calculateResults(ctx, v.UID, tallyOptions{}); err == nil {
+		t.Error("calculated results with partial decryptions of less than threshold trustees")
+	}
+
+	_, wait, err = apps[2].partialDecrypt(ctx, v, ceremony, c.accounts[2])
+	assertNilError(t, err)
+	_, err = wait(ctx)
+	assertNilError(t, err)
+
+	results, err = apps[0].calculateResults(ctx, v.UID, tallyOptions{})
+	assertNilError(t, err)
+
+	assertEqual(t, "ballots", results.Ballots, 3)
+	assertEqual(t, "preferences", results.Preferences, [][]int{
+		{0, 1, 2},
+		{2, 0, 3},
+		{1, 0, 0},
+	})
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"resenje.org/eas"
+)
+
+func voteCommand() error {
+	cli := flag.NewFlagSet("schulzeoneas vote", flag.ExitOnError)
+
+	options := newAppOptions(cli)
+	account := newAccountOptions(cli)
+	votingFlag := cli.String("voting", "", "UID of the voting")
+	allowlistFlag := cli.String("allowlist", "", "File with addresses of the voting allowlist, one per line")
+	rankingFlag := cli.String("ranking", "", "Comma separated ranks for voting choices in their order, lower rank is preferred and empty rank leaves the choice unranked")
+	offchainFlag := cli.String("offchain", "", "Write the ballot signed as an off-chain attestation to this file instead of attesting it")
+	relayFlag := cli.String("relay", "", "Write the ballot signed for a relayer to this file instead of attesting it")
+
+	if err := cli.Parse(os.Args[2:]); err != nil {
+		log.Println(err)
+		cli.Usage()
+	}
+
+	if *votingFlag == "" {
+		return errors.New("voting UID is required")
+	}
+	if !isHexUID(*votingFlag) {
+		return fmt.Errorf("invalid voting UID %q", *votingFlag)
+	}
+	votingUID := eas.HexDecodeUID(*votingFlag)
+
+	a, err := options.newApp()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	key, err := account.key(a)
+	if err != nil {
+		return err
+	}
+	if err := a.setClient(ctx, key); err != nil {
+		return err
+	}
+
+	log.Println("Wallet address:", a.client.Address())
+
+	voting, err := a.getVoting(ctx, votingUID)
+	if err != nil {
+		return err
+	}
+
+	if *allowlistFlag != "" {
+		if err := a.importAllowlist(ctx, voting, *allowlistFlag); err != nil {
+			return err
+		}
+	}
+
+	ballot, err := parseRanking(*rankingFlag, len(voting.Choices))
+	if err != nil {
+		return err
+	}
+
+	if *offchainFlag != "" {
+		o, err := a.signOffchainBallot(ctx, voting, ballot, key)
+		if err != nil {
+			return err
+		}
+		if err := writeOffchainAttestation(*offchainFlag, o); err != nil {
+			return err
+		}
+		fmt.Println(o.Sig.UID)
+		return nil
+	}
+
+	if *relayFlag != "" {
+		r, err := a.signRelayRequest(ctx, voting, ballot, key)
+		if err != nil {
+			return err
+		}
+		return writeRelayRequest(*relayFlag, r)
+	}
+
+	tx, wait, err := a.submitBallot(ctx, voting, ballot)
+	if err != nil {
+		return err
+	}
+	log.Println("Waiting ballot attestation:", tx.Hash())
+	r, err := wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(r.UID)
+
+	if voting.CommitReveal {
+		log.Println("Reveal the secret ballot with the reveal command after", formatTime(voting.End))
+	}
+
+	return nil
+}
+
+// parseRanking constructs a ballot from comma separated ranks where each rank
+// is for the choice with the same index in the voting.
+func parseRanking(s string, choicesCount int) (ballotSchema, error) {
+	ranks := strings.Split(s, ",")
+	if len(ranks) > choicesCount {
+		return nil, fmt.Errorf("ranking has %v ranks for %v choices", len(ranks), choicesCount)
+	}
+	var ballot ballotSchema
+	for i, r := range ranks {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		rank, err := strconv.Atoi(r)
+		if err != nil {
+			return nil, fmt.Errorf("rank for choice %v: %w", i+1, err)
+		}
+		if rank <= 0 || rank > math.MaxUint16 {
+			return nil, fmt.Errorf("rank for choice %v is out of range", i+1)
+		}
+		ballot = append(ballot, ballotRanking{
+			ChoiceIndex: uint16(i),
+			Rank:        uint16(rank),
+		})
+	}
+	if len(ballot) == 0 {
+		return nil, errors.New("ranking has no ranked choices")
+	}
+	return ballot, nil
+}
+
+// isHexUID returns true if the string is a hex encoded UID with an optional
+// 0x prefix.
+func isHexUID(s string) bool {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "0x")
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
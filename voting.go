@@ -7,28 +7,33 @@ package main
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"errors"
 	"fmt"
 	"math"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum"
-	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 
 	"resenje.org/eas"
-	"resenje.org/schulze"
 )
 
-func (a *app) setClient(ctx context.Context, key *keystore.Key) error {
-	pk := key.PrivateKey
-	c, err := eas.NewClient(ctx, a.ethereumEndpoint, pk, a.easContractAddress, nil)
+func (a *app) setClient(ctx context.Context, pk *ecdsa.PrivateKey) error {
+	c, easContractAddress, err := connectEAS(ctx, a.backend, a.ethereumEndpoint, a.chainID, a.easContractAddress, pk)
 	if err != nil {
 		return err
 	}
 	a.client = c
+	a.easContractAddress = easContractAddress
+	a.key = pk
 	if a.config == nil {
 		if err := a.getConfiguration(ctx); err != nil {
 			return err
@@ -37,25 +42,348 @@ func (a *app) setClient(ctx context.Context, key *keystore.Key) error {
 	return nil
 }
 
-func (a *app) getConfiguration(ctx context.Context) error {
-	attestation, err := a.client.EAS.GetAttestation(ctx, a.configUID)
+// setReadOnlyClient sets the client with a generated key for commands that
+// only read data from the chain and do not require an account.
+func (a *app) setReadOnlyClient(ctx context.Context) error {
+	pk, err := crypto.GenerateKey()
 	if err != nil {
 		return err
 	}
-	var config configSchema
-	if err := attestation.ScanValues(&config); err != nil {
+	return a.setClient(ctx, pk)
+}
+
+func (a *app) getConfiguration(ctx context.Context) error {
+	if a.configUID.IsZero() {
+		return fmt.Errorf("network %s has no known config uid, set it with the -uid flag", a.network)
+	}
+	config, err := fetchConfiguration(ctx, a.client, a.configUID)
+	if err != nil {
 		return err
 	}
-	a.config = &config
+	a.config = config
+	return nil
+}
+
+// fetchConfiguration returns the configuration from the config attestation.
+func fetchConfiguration(ctx context.Context, client *eas.Client, configUID eas.UID) (*configSchema, error) {
+	attestation, err := client.EAS.GetAttestation(ctx, configUID)
+	if err != nil {
+		return nil, err
+	}
+	if attestation.UID != configUID {
+		return nil, fmt.Errorf("config attestation %s not found", configUID)
+	}
+	return scanConfigSchema(attestation)
+}
+
+// voting is a voting attestation decoded from any of the supported voting
+// schemas.
+type voting struct {
+	UID         eas.UID        `json:"uid"`
+	Attester    common.Address `json:"attester"`
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Choices     []string       `json:"choices"`
+	// Zero Start or End time leaves the voting window open on that side.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Merkle root of addresses that are eligible to vote, zero if any
+	// address can vote.
+	AllowlistRoot common.Hash `json:"allowlistRoot"`
+	// Schema of membership attestations that attesters must hold from any of
+	// the issuers to be eligible to vote, zero if no membership is required.
+	MembershipSchemaUID eas.UID          `json:"membershipSchema"`
+	MembershipIssuers   []common.Address `json:"membershipIssuers"`
+	// Block in which the membership schema was registered, from which
+	// membership attestations are scanned.
+	MembershipSchemaBlock uint64 `json:"membershipSchemaBlock"`
+	// Token whose balances at the weight block are weights of ballots, zero
+	// if every ballot is counted once.
+	WeightToken common.Address `json:"weightToken"`
+	WeightBlock uint64         `json:"weightBlock"`
+	// Votes of accounts that did not vote are counted with the ballots of
+	// their delegates if delegation is enabled.
+	Delegation bool `json:"delegation"`
+	// Ballots are secret until they are revealed after the voting end time
+	// and before the reveal end time if the commit-reveal mode is enabled.
+	// Zero RevealEnd time does not limit the reveal window.
+	CommitReveal bool      `json:"commitReveal"`
+	RevealEnd    time.Time `json:"revealEnd"`
+	// Compressed public key of the tallier that ballots are encrypted to,
+	// empty if ballots are not encrypted.
+	TallierPublicKey hexutil.Bytes `json:"tallierPublicKey,omitempty"`
+	// Encrypted ballots are pairwise preferences that are summed before they
+	// are decrypted if homomorphic tallying is enabled, so that no ranking is
+	// ever decrypted.
+	HomomorphicTally bool `json:"homomorphicTally,omitempty"`
+}
+
+func (v *voting) isWeighted() bool {
+	return v.WeightToken != (common.Address{})
+}
+
+func (v *voting) isEncrypted() bool {
+	return len(v.TallierPublicKey) > 0
+}
+
+func (v *voting) validate() error {
+	if strings.TrimSpace(v.Title) == "" {
+		return errors.New("title is required")
+	}
+	if len(v.Choices) < 2 {
+		return errors.New("at least two choices are required")
+	}
+	for i, c := range v.Choices {
+		if strings.TrimSpace(c) == "" {
+			return fmt.Errorf("choice %v cannot be empty", i+1)
+		}
+	}
+	if !v.Start.IsZero() && !v.End.IsZero() && !v.End.After(v.Start) {
+		return errors.New("end time must be after start time")
+	}
+	if v.MembershipSchemaUID.IsZero() != (len(v.MembershipIssuers) == 0) {
+		return errors.New("membership requires both the schema and at least one issuer")
+	}
+	if v.isWeighted() != (v.WeightBlock > 0) {
+		return errors.New("weighting requires both the token and the snapshot block")
+	}
+	if v.CommitReveal && v.End.IsZero() {
+		return errors.New("secret ballots require the voting end time")
+	}
+	if !v.RevealEnd.IsZero() && (!v.CommitReveal || !v.RevealEnd.After(v.End)) {
+		return errors.New("reveal end time must be after the end time of a voting with secret ballots")
+	}
+	if v.isEncrypted() {
+		if v.CommitReveal {
+			return errors.New("secret ballots can not be both committed and encrypted")
+		}
+		if v.End.IsZero() {
+			return errors.New("encrypted ballots require the voting end time")
+		}
+		if _, err := crypto.DecompressPubkey(v.TallierPublicKey); err != nil {
+			return fmt.Errorf("tallier public key: %w", err)
+		}
+	}
+	if v.HomomorphicTally {
+		if !v.isEncrypted() {
+			return errors.New("homomorphic tallying requires the tallier public key")
+		}
+		if len(v.Choices) > maxHomomorphicChoices {
+			return fmt.Errorf("homomorphic tallying supports at most %v choices", maxHomomorphicChoices)
+		}
+	}
+	return nil
+}
+
+// checkTime returns an error if ballots are not accepted at the provided
+// time. The start time is inclusive and the end time is exclusive.
+func (v *voting) checkTime(t time.Time) error {
+	if !v.Start.IsZero() && t.Before(v.Start) {
+		return fmt.Errorf("voting opens at %s", formatTime(v.Start))
+	}
+	if !v.End.IsZero() && !t.Before(v.End) {
+		return fmt.Errorf("voting closed at %s", formatTime(v.End))
+	}
+	return nil
+}
+
+// checkRevealTime returns an error if ballots can not be revealed at the
+// provided time. The voting end time is inclusive and the reveal end time is
+// exclusive.
+func (v *voting) checkRevealTime(t time.Time) error {
+	if t.Before(v.End) {
+		return fmt.Errorf("ballots can be revealed after %s", formatTime(v.End))
+	}
+	if !v.RevealEnd.IsZero() && !t.Before(v.RevealEnd) {
+		return fmt.Errorf("ballots could be revealed until %s", formatTime(v.RevealEnd))
+	}
 	return nil
 }
 
+func (v *voting) window() string {
+	switch {
+	case !v.Start.IsZero() && !v.End.IsZero():
+		return "from " + formatTime(v.Start) + " until " + formatTime(v.End)
+	case !v.Start.IsZero():
+		return "from " + formatTime(v.Start)
+	case !v.End.IsZero():
+		return "until " + formatTime(v.End)
+	}
+	return ""
+}
+
+// timeLayout is used for entering and displaying voting times in the local
+// time zone.
+const timeLayout = "2006-01-02 15:04"
+
+func formatTime(t time.Time) string {
+	return t.Local().Format(timeLayout + " MST")
+}
+
+func parseTime(s string) (time.Time, error) {
+	if strings.TrimSpace(s) == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation(timeLayout, strings.TrimSpace(s), time.Local)
+}
+
+// parseAddresses parses comma separated hex addresses.
+func parseAddresses(s string) ([]common.Address, error) {
+	var addresses []common.Address
+	for _, a := range strings.Split(s, ",") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		if !common.IsHexAddress(a) {
+			return nil, fmt.Errorf("invalid address %q", a)
+		}
+		addresses = append(addresses, common.HexToAddress(a))
+	}
+	return addresses, nil
+}
+
+// getVoting returns the voting from the local index or decodes its
+// attestation and stores it in the index.
+func (a *app) getVoting(ctx context.Context, votingUID eas.UID) (*voting, error) {
+	index, err := a.openIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := index.Votings[votingUID]; ok {
+		return v, nil
+	}
+	attestation, err := a.client.EAS.GetAttestation(ctx, votingUID)
+	if err != nil {
+		return nil, err
+	}
+	v, err := a.decodeVoting(attestation)
+	if err != nil {
+		return nil, err
+	}
+	if err := index.addVoting(v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (a *app) decodeVoting(attestation *eas.Attestation) (*voting, error) {
+	v := &voting{
+		UID:      attestation.UID,
+		Attester: attestation.Attester,
+	}
+	switch {
+	case attestation.Schema.IsZero():
+		return nil, fmt.Errorf("voting %s not found", attestation.UID)
+	case attestation.Schema == a.config.VotingSchemaUID:
+		var s votingSchema
+		if err := attestation.ScanValues(&s); err != nil {
+			return nil, err
+		}
+		v.Title = s.Title
+		v.Choices = s.Choices
+	case attestation.Schema == a.config.schema(votingV2SchemaName).UID:
+		var s votingSchemaV2
+		if err := attestation.ScanValues(&s); err != nil {
+			return nil, err
+		}
+		v.Title = s.Title
+		v.Description = s.Description
+		v.Choices = s.Choices
+		v.Start = unixTime(s.StartTime)
+		v.End = unixTime(s.EndTime)
+	case attestation.Schema == a.config.schema(votingV3SchemaName).UID:
+		var s votingSchemaV3
+		if err := attestation.ScanValues(&s); err != nil {
+			return nil, err
+		}
+		v.Title = s.Title
+		v.Description = s.Description
+		v.Choices = s.Choices
+		v.Start = unixTime(s.StartTime)
+		v.End = unixTime(s.EndTime)
+		if err := decodeVotingOptions(v, s.Options); err != nil {
+			return nil, fmt.Errorf("voting %s: %w", attestation.UID, err)
+		}
+	default:
+		return nil, fmt.Errorf("attestation %s is not a voting", attestation.UID)
+	}
+	return v, nil
+}
+
+// chainTime returns the time of the latest block as an approximation of the
+// time that a new attestation would get.
+func (a *app) chainTime(ctx context.Context) (time.Time, error) {
+	header, err := a.client.Backend().HeaderByNumber(ctx, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(header.Time), 0), nil
+}
+
 func (a *app) newCreateVotingForm(previous tview.Primitive) tview.Primitive {
 	form := tview.NewForm()
 	var title string
 	form.AddInputField("Title", "", 40, nil, func(text string) {
 		title = text
 	})
+	var description, start, end string
+	if !a.config.schema(votingV2SchemaName).UID.IsZero() {
+		form.AddTextArea("Description", "", 40, 4, 0, func(text string) {
+			description = text
+		})
+		form.AddInputField("Opens", "", 16, nil, func(text string) {
+			start = text
+		})
+		form.AddInputField("Closes", "", 16, nil, func(text string) {
+			end = text
+		})
+	}
+	var (
+		allowlistFile                       string
+		membershipSchema, membershipIssuers string
+		weightToken, weightBlock            string
+		delegation, commitReveal            bool
+		revealEnd                           string
+		tallierPublicKey                    string
+		homomorphicTally                    bool
+	)
+	if !a.config.schema(votingV3SchemaName).UID.IsZero() {
+		form.AddInputField("Allowlist file", "", 40, nil, func(text string) {
+			allowlistFile = text
+		})
+		form.AddInputField("Membership schema", "", 67, nil, func(text string) {
+			membershipSchema = text
+		})
+		form.AddInputField("Membership issuers", "", 67, nil, func(text string) {
+			membershipIssuers = text
+		})
+		form.AddInputField("Weight token", "", 42, nil, func(text string) {
+			weightToken = text
+		})
+		form.AddInputField("Snapshot block", "", 12, func(textToCheck string, lastChar rune) bool {
+			_, err := strconv.ParseUint(textToCheck, 10, 64)
+			return textToCheck == "" || err == nil
+		}, func(text string) {
+			weightBlock = text
+		})
+		form.AddCheckbox("Delegation", false, func(checked bool) {
+			delegation = checked
+		})
+		form.AddCheckbox("Secret ballots", false, func(checked bool) {
+			commitReveal = checked
+		})
+		form.AddInputField("Reveal closes", "", 16, nil, func(text string) {
+			revealEnd = text
+		})
+		form.AddInputField("Tallier public key", "", 68, nil, func(text string) {
+			tallierPublicKey = text
+		})
+		form.AddCheckbox("Homomorphic tally", false, func(checked bool) {
+			homomorphicTally = checked
+		})
+	}
+	choicesIndex := form.GetFormItemCount()
 	choices := make([]string, 2)
 	form.AddInputField("Choice 1", "", 40, nil, func(text string) {
 		choices[0] = text
@@ -73,30 +401,80 @@ func (a *app) newCreateVotingForm(previous tview.Primitive) tview.Primitive {
 	form.AddButton("Remove choice", func() {
 		if len(choices) > 2 {
 			choices = choices[:len(choices)-1]
-			form.RemoveFormItem(len(choices) + 1)
+			form.RemoveFormItem(choicesIndex + len(choices))
 			a.render(form)
-			a.SetFocus(form.GetFormItem(len(choices) - 1))
+			a.SetFocus(form.GetFormItem(choicesIndex + len(choices) - 2))
 		}
 	})
 	form.AddButton("Create", func() {
-		if strings.TrimSpace(title) == "" {
-			a.render(a.newMessage(form, "Title is required"))
+		startTime, err := parseTime(start)
+		if err != nil {
+			a.render(a.newMessage(form, "Opens time must be in format "+timeLayout))
+			return
+		}
+		endTime, err := parseTime(end)
+		if err != nil {
+			a.render(a.newMessage(form, "Closes time must be in format "+timeLayout))
+			return
+		}
+		revealEndTime, err := parseTime(revealEnd)
+		if err != nil {
+			a.render(a.newMessage(form, "Reveal closes time must be in format "+timeLayout))
 			return
 		}
-		for i, c := range choices {
-			if strings.TrimSpace(c) == "" {
-				a.render(a.newMessage(form, fmt.Sprintf("Choice %v cannot be empty", i+1)))
+		issuers, err := parseAddresses(membershipIssuers)
+		if err != nil {
+			a.render(a.newMessage(form, "Error: membership issuers: "+err.Error()))
+			return
+		}
+		v := &voting{
+			Title:               title,
+			Description:         description,
+			Choices:             choices,
+			Start:               startTime,
+			End:                 endTime,
+			MembershipSchemaUID: eas.HexDecodeUID(membershipSchema),
+			MembershipIssuers:   issuers,
+			Delegation:          delegation,
+			CommitReveal:        commitReveal,
+			RevealEnd:           revealEndTime,
+			HomomorphicTally:    homomorphicTally,
+		}
+		if strings.TrimSpace(tallierPublicKey) != "" {
+			v.TallierPublicKey, err = parseTallierPublicKey(tallierPublicKey)
+			if err != nil {
+				a.render(a.newMessage(form, "Error: "+err.Error()))
+				return
+			}
+		}
+		if weightToken != "" {
+			if !common.IsHexAddress(weightToken) {
+				a.render(a.newMessage(form, "Error: invalid weight token address"))
+				return
+			}
+			v.WeightToken = common.HexToAddress(weightToken)
+		}
+		if weightBlock != "" {
+			v.WeightBlock, _ = strconv.ParseUint(weightBlock, 10, 64)
+		}
+		if err := v.validate(); err != nil {
+			a.render(a.newMessage(form, "Error: "+err.Error()))
+			return
+		}
+		if allowlistFile != "" {
+			addresses, err := readAllowlist(allowlistFile)
+			if err != nil {
+				a.render(a.newMessage(form, "Error: "+err.Error()))
+				return
+			}
+			v.AllowlistRoot, err = a.addAllowlist(context.Background(), addresses)
+			if err != nil {
+				a.render(a.newMessage(form, "Error: "+err.Error()))
 				return
 			}
 		}
 
-		tx, wait, err := a.client.EAS.Attest(context.Background(), a.config.VotingSchemaUID, &eas.AttestOptions{
-			RefUID:    a.configUID,
-			Revocable: true,
-		}, votingSchema{
-			Title:   title,
-			Choices: choices,
-		})
+		tx, wait, err := a.createVoting(context.Background(), v)
 		if err != nil {
 			a.render(a.newMessage(form, "Error: "+err.Error()))
 			return
@@ -117,19 +495,89 @@ func (a *app) newCreateVotingForm(previous tview.Primitive) tview.Primitive {
 	return form
 }
 
+// createVoting attests the voting with the newest voting schema that the
+// configuration supports.
+func (a *app) createVoting(ctx context.Context, v *voting) (*types.Transaction, eas.WaitTx[eas.EASAttested], error) {
+	o := &eas.AttestOptions{
+		RefUID:    a.configUID,
+		Revocable: true,
+	}
+	if !v.MembershipSchemaUID.IsZero() && v.MembershipSchemaBlock == 0 {
+		currentBlock, err := a.client.Backend().(ethereum.BlockNumberReader).BlockNumber(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		v.MembershipSchemaBlock, err = a.schemaBlock(ctx, v.MembershipSchemaUID, currentBlock)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	options, err := encodeVotingOptions(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	if votingV3 := a.config.schema(votingV3SchemaName); !votingV3.UID.IsZero() {
+		for _, option := range options {
+			for _, name := range votingOptionSchemas[option.Name] {
+				if a.config.schema(name).UID.IsZero() {
+					return nil, nil, fmt.Errorf("configuration does not support voting option %s", option.Name)
+				}
+			}
+		}
+		return a.client.EAS.Attest(ctx, votingV3.UID, o, votingSchemaV3{
+			Title:       v.Title,
+			Description: v.Description,
+			Choices:     v.Choices,
+			StartTime:   unixTimestamp(v.Start),
+			EndTime:     unixTimestamp(v.End),
+			Options:     options,
+		})
+	}
+	if len(options) > 0 {
+		return nil, nil, fmt.Errorf("configuration does not support voting option %s", options[0].Name)
+	}
+	votingV2 := a.config.schema(votingV2SchemaName)
+	if votingV2.UID.IsZero() {
+		if v.Description != "" || !v.Start.IsZero() || !v.End.IsZero() {
+			return nil, nil, errors.New("configuration does not support voting description and time window")
+		}
+		return a.client.EAS.Attest(ctx, a.config.VotingSchemaUID, o, votingSchema{
+			Title:   v.Title,
+			Choices: v.Choices,
+		})
+	}
+	return a.client.EAS.Attest(ctx, votingV2.UID, o, votingSchemaV2{
+		Title:       v.Title,
+		Description: v.Description,
+		Choices:     v.Choices,
+		StartTime:   unixTimestamp(v.Start),
+		EndTime:     unixTimestamp(v.End),
+	})
+}
+
 func (a *app) newOpenBallotForm(previous tview.Primitive) tview.Primitive {
 	form := tview.NewForm()
 	var votingUID eas.UID
 	form.AddInputField("Voting", "", 67, nil, func(text string) {
 		votingUID = eas.HexDecodeUID(text)
 	})
+	var allowlistFile string
+	form.AddInputField("Allowlist file", "", 40, nil, func(text string) {
+		allowlistFile = text
+	})
 	form.AddButton("Open ballot", func() {
-		voting, err := a.client.EAS.GetAttestation(context.Background(), votingUID)
-		if err != nil {
-			a.render(a.newMessage(form, "Error: "+err.Error()))
-			return
-		}
-		a.render(a.newSubmitBallotForm(previous, votingUID, voting))
+		a.renderAsync(form, fmt.Sprintf("Opening ballot for\n %s", votingUID), func() (tview.Primitive, error) {
+			voting, err := a.getVoting(context.Background(), votingUID)
+			if err != nil {
+				return nil, err
+			}
+			if allowlistFile != "" {
+				if err := a.importAllowlist(context.Background(), voting, allowlistFile); err != nil {
+					return nil, err
+				}
+			}
+			return a.newSubmitBallotForm(previous, voting), nil
+		})
 	})
 	form.AddButton("Cancel", func() {
 		a.render(previous)
@@ -138,12 +586,29 @@ func (a *app) newOpenBallotForm(previous tview.Primitive) tview.Primitive {
 	return form
 }
 
-func (a *app) newSubmitBallotForm(previous tview.Primitive, votingUID eas.UID, attestation *eas.Attestation) tview.Primitive {
+func (a *app) newSubmitBallotForm(previous tview.Primitive, voting *voting) tview.Primitive {
 	form := tview.NewForm()
-	var voting votingSchema
-	if err := attestation.ScanValues(&voting); err != nil {
-		return a.newMessage(form, "Error: "+err.Error())
+	form.AddTextView("Voting", voting.Title, 0, 1, false, false)
+	if voting.Description != "" {
+		form.AddTextView("Description", voting.Description, 0, 4, false, true)
+	}
+	if w := voting.window(); w != "" {
+		form.AddTextView("Open", w, 0, 1, false, false)
 	}
+	// eligibility is checked in the background, as it may scan memberships,
+	// and the result is shown when it is known
+	form.AddTextView("Eligibility", "Checking...", 0, 2, false, false)
+	eligibility := form.GetFormItem(form.GetFormItemCount() - 1).(*tview.TextView)
+	address := a.client.Address()
+	go func() {
+		text := "Eligible"
+		if err := a.checkEligibility(context.Background(), voting, address); err != nil {
+			text = "Warning: " + err.Error()
+		}
+		a.QueueUpdateDraw(func() {
+			eligibility.SetText(text)
+		})
+	}()
 	ballot := make(map[uint16]uint16)
 	for i, choice := range voting.Choices {
 		form.AddInputField(choice, "", 2, func(textToCheck string, lastChar rune) bool {
@@ -163,7 +628,7 @@ func (a *app) newSubmitBallotForm(previous tview.Primitive, votingUID eas.UID, a
 			ballot[uint16(i)] = uint16(v)
 		})
 	}
-	form.AddButton("Vote", func() {
+	ranking := func() ballotSchema {
 		var bs ballotSchema
 		for index, rank := range ballot {
 			bs = append(bs, ballotRanking{
@@ -171,10 +636,10 @@ func (a *app) newSubmitBallotForm(previous tview.Primitive, votingUID eas.UID, a
 				Rank:        rank,
 			})
 		}
-		tx, wait, err := a.client.EAS.Attest(context.Background(), a.config.BallotSchemaUID, &eas.AttestOptions{
-			RefUID:    votingUID,
-			Revocable: true,
-		}, bs)
+		return bs
+	}
+	form.AddButton("Vote", func() {
+		tx, wait, err := a.submitBallot(context.Background(), voting, ranking())
 		if err != nil {
 			a.render(a.newMessage(form, "Error: "+err.Error()))
 			return
@@ -184,16 +649,115 @@ func (a *app) newSubmitBallotForm(previous tview.Primitive, votingUID eas.UID, a
 			if err != nil {
 				return nil, err
 			}
+			if voting.isEncrypted() {
+				return a.newMessage(previous, "Submitted encrypted ballot with UID\n"+r.UID.String()), nil
+			}
+			if voting.CommitReveal {
+				return a.newMessage(previous, "Submitted secret ballot with UID\n"+r.UID.String()+"\nReveal it after "+formatTime(voting.End)), nil
+			}
 			return a.newMessage(previous, "Submitted ballot with UID\n"+r.UID.String()), nil
 		})
 	})
+	if voting.checkOffchain() == nil {
+		form.AddButton("Sign off-chain", func() {
+			a.render(a.newSignBallotForm(form, voting, ranking(), false))
+		})
+		form.AddButton("Sign for relayer", func() {
+			a.render(a.newSignBallotForm(form, voting, ranking(), true))
+		})
+	}
+	form.AddButton("Cancel", func() {
+		a.render(previous)
+	})
+	form.SetBorder(true).SetTitle(" Ballot " + voting.UID.String() + " ").SetTitleAlign(tview.AlignLeft)
+	return form
+}
+
+// newSignBallotForm signs the ballot as an off-chain attestation or, if relay
+// is true, as a request for a relayer and saves it to a file.
+func (a *app) newSignBallotForm(previous tview.Primitive, voting *voting, ballot ballotSchema, relay bool) tview.Primitive {
+	form := tview.NewForm()
+	filename := "ballot-" + a.client.Address().Hex() + ".json"
+	title := " Sign off-chain ballot "
+	if relay {
+		filename = "relay-" + a.client.Address().Hex() + ".json"
+		title = " Sign ballot for relayer "
+	}
+	form.AddInputField("File", filename, 60, nil, func(text string) {
+		filename = text
+	})
+	var password string
+	form.AddPasswordField("Password", "", 16, '*', func(text string) {
+		password = text
+	})
+	form.AddButton("Sign", func() {
+		a.renderAsync(form, "Signing ballot...", func() (tview.Primitive, error) {
+			key, err := a.accountKey(a.client.Address(), password)
+			if err != nil {
+				return nil, err
+			}
+			if relay {
+				r, err := a.signRelayRequest(context.Background(), voting, ballot, key)
+				if err != nil {
+					return nil, err
+				}
+				if err := writeRelayRequest(filename, r); err != nil {
+					return nil, err
+				}
+				return a.newMessage(nil, "Signed ballot for relayer saved to\n"+filename), nil
+			}
+			o, err := a.signOffchainBallot(context.Background(), voting, ballot, key)
+			if err != nil {
+				return nil, err
+			}
+			if err := writeOffchainAttestation(filename, o); err != nil {
+				return nil, err
+			}
+			return a.newMessage(nil, "Signed off-chain ballot with UID\n"+o.Sig.UID.String()+"\nsaved to "+filename), nil
+		})
+	})
 	form.AddButton("Cancel", func() {
 		a.render(previous)
 	})
-	form.SetBorder(true).SetTitle(" Ballot " + attestation.UID.String() + " ").SetTitleAlign(tview.AlignLeft)
+	form.SetBorder(true).SetTitle(title).SetTitleAlign(tview.AlignLeft)
 	return form
 }
 
+func (a *app) submitBallot(ctx context.Context, v *voting, ballot ballotSchema) (*types.Transaction, eas.WaitTx[eas.EASAttested], error) {
+	now, err := a.chainTime(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := v.checkTime(now); err != nil {
+		return nil, nil, err
+	}
+	if err := a.checkEligibility(ctx, v, a.client.Address()); err != nil && !errors.Is(err, errAllowlistUnavailable) {
+		return nil, nil, err
+	}
+	if v.CommitReveal {
+		return a.commitBallot(ctx, v, ballot)
+	}
+	if v.HomomorphicTally {
+		return a.attestPairwiseBallot(ctx, v, ballot)
+	}
+	if v.isEncrypted() {
+		return a.attestEncryptedBallot(ctx, v, ballot)
+	}
+	return a.client.EAS.Attest(ctx, a.config.BallotSchemaUID, &eas.AttestOptions{
+		Recipient: votingRecipient(v.UID),
+		RefUID:    v.UID,
+		Revocable: true,
+	}, ballot)
+}
+
+// votingRecipient returns the address that is set as the recipient of the
+// voting ballots, so that they can be filtered by the indexed recipient topic
+// of the Attested event. It is derived from the voting UID and it is not
+// expected that anyone has its private key.
+func votingRecipient(votingUID eas.UID) common.Address {
+	return common.BytesToAddress(crypto.Keccak256([]byte("SchulzeOnEAS voting"), votingUID[:]))
+}
+
 func (a *app) newOpenSubmittedBallotForm(previous tview.Primitive) tview.Primitive {
 	form := tview.NewForm()
 	var ballotUID eas.UID
@@ -206,11 +770,19 @@ func (a *app) newOpenSubmittedBallotForm(previous tview.Primitive) tview.Primiti
 			a.render(a.newMessage(form, "Error: "+err.Error()))
 			return
 		}
-		voting, err := a.client.EAS.GetAttestation(context.Background(), ballot.RefUID)
+		if ballot.Schema != a.config.BallotSchemaUID {
+			a.render(a.newMessage(form, "Attestation "+ballotUID.String()+" is not a ballot"))
+			return
+		}
+		voting, err := a.getVoting(context.Background(), ballot.RefUID)
 		if err != nil {
 			a.render(a.newMessage(form, "Error: "+err.Error()))
 			return
 		}
+		if voting.isEncrypted() {
+			a.render(a.newMessage(form, "Ballot is encrypted to the tallier of the voting"))
+			return
+		}
 		a.render(a.newSubmittedBallotTable(previous, voting, ballot))
 	})
 	form.AddButton("Cancel", func() {
@@ -220,13 +792,9 @@ func (a *app) newOpenSubmittedBallotForm(previous tview.Primitive) tview.Primiti
 	return form
 }
 
-func (a *app) newSubmittedBallotTable(previous tview.Primitive, v *eas.Attestation, b *eas.Attestation) tview.Primitive {
+func (a *app) newSubmittedBallotTable(previous tview.Primitive, voting *voting, b *eas.Attestation) tview.Primitive {
 	table := tview.NewTable()
 	table.SetBorders(true)
-	var voting votingSchema
-	if err := v.ScanValues(&voting); err != nil {
-		return a.newMessage(previous, "Error: "+err.Error())
-	}
 	var ballot ballotSchema
 	if err := b.ScanValues(&ballot); err != nil {
 		return a.newMessage(previous, "Error: "+err.Error())
@@ -250,7 +818,7 @@ func (a *app) newSubmittedBallotTable(previous tview.Primitive, v *eas.Attestati
 			a.render(previous)
 		})
 		form.AddButton("Change vote", func() {
-			a.render(a.newSubmitBallotForm(previous, b.RefUID, v))
+			a.render(a.newSubmitBallotForm(previous, voting))
 		})
 		form.SetBorder(true).SetTitle(" Ballot " + b.UID.String() + " ").SetTitleAlign(tview.AlignLeft)
 		a.render(form)
@@ -267,76 +835,58 @@ func (a *app) newOpenVotingResultsForm(previous tview.Primitive) tview.Primitive
 	form.AddInputField("Voting", "", 67, nil, func(text string) {
 		votingUID = eas.HexDecodeUID(text)
 	})
+	var allowlistFile string
+	form.AddInputField("Allowlist file", "", 40, nil, func(text string) {
+		allowlistFile = text
+	})
+	var until, untilBlock string
+	form.AddInputField("Until", "", 16, nil, func(text string) {
+		until = text
+	})
+	form.AddInputField("Until block", "", 12, func(textToCheck string, lastChar rune) bool {
+		_, err := strconv.ParseUint(textToCheck, 10, 64)
+		return textToCheck == "" || err == nil
+	}, func(text string) {
+		untilBlock = text
+	})
+	var transcriptFile string
+	form.AddInputField("Transcript file", "", 40, nil, func(text string) {
+		transcriptFile = text
+	})
 	form.AddButton("Calculate results", func() {
+		untilTime, err := parseTime(until)
+		if err != nil {
+			a.render(a.newMessage(form, "Until time must be in format "+timeLayout))
+			return
+		}
+		o := tallyOptions{
+			Until: untilTime,
+		}
+		if untilBlock != "" {
+			o.UntilBlock, _ = strconv.ParseUint(untilBlock, 10, 64)
+		}
 		a.renderAsync(form, fmt.Sprintf("Calculating results for\n %s", votingUID), func() (tview.Primitive, error) {
-			v, err := a.client.EAS.GetAttestation(context.Background(), votingUID)
-			if err != nil {
-				return nil, err
-			}
-			var voting votingSchema
-			if err := v.ScanValues(&voting); err != nil {
-				return nil, err
-			}
-			ballots := make(map[common.Address]ballotSchema)
-			currentBlock, err := a.client.Backend().(ethereum.BlockNumberReader).BlockNumber(context.Background())
-			if err != nil {
-				return nil, err
-			}
-			for i := a.config.VotingSchemaBlock; i < currentBlock; i += 1000 {
-				if err := func() error {
-					it, err := a.client.EAS.FilterAttested(context.Background(), i, eas.Ptr(i+1000), nil, nil, []eas.UID{a.config.BallotSchemaUID})
-					if err != nil {
-						return err
-					}
-					defer it.Close()
-
-					for it.Next() {
-						r := it.Value()
-						b, err := a.client.EAS.GetAttestation(context.Background(), r.UID)
-						if err != nil {
-							return err
-						}
-						if b.RefUID != votingUID {
-							continue
-						}
-						var ballot ballotSchema
-						if err := b.ScanValues(&ballot); err != nil {
-							return err
-						}
-						ballots[r.Attester] = ballot
-					}
-
-					return nil
-				}(); err != nil {
+			if transcriptFile != "" {
+				t, err := readDecryptionTranscript(transcriptFile)
+				if err != nil {
 					return nil, err
 				}
+				o.Transcript = t
 			}
-			choices := make([]uint16, 0, len(voting.Choices))
-			for i := range voting.Choices {
-				choices = append(choices, uint16(i))
-			}
-			sch := schulze.NewVoting(choices)
-			for _, ballot := range ballots {
-				b := make(schulze.Ballot[uint16])
-				for _, r := range ballot {
-					b[r.ChoiceIndex] = int(r.Rank)
+			if allowlistFile != "" {
+				voting, err := a.getVoting(context.Background(), votingUID)
+				if err != nil {
+					return nil, err
 				}
-				if _, err := sch.Vote(b); err != nil {
+				if err := a.importAllowlist(context.Background(), voting, allowlistFile); err != nil {
 					return nil, err
 				}
 			}
-			results, _, _ := sch.Compute()
-			finalResults := make([]schulze.Result[string], 0, len(results))
-			for _, r := range results {
-				finalResults = append(finalResults, schulze.Result[string]{
-					Choice:    voting.Choices[int(r.Choice)],
-					Index:     r.Index,
-					Wins:      r.Wins,
-					Strength:  r.Strength,
-					Advantage: r.Advantage,
-				})
+			r, err := a.calculateResults(context.Background(), votingUID, o)
+			if err != nil {
+				return nil, err
 			}
-			return a.newVotingResultsTable(previous, votingUID, finalResults), nil
+			return a.newVotingResultsTable(previous, r), nil
 		})
 	})
 	form.AddButton("Cancel", func() {
@@ -346,26 +896,175 @@ func (a *app) newOpenVotingResultsForm(previous tview.Primitive) tview.Primitive
 	return form
 }
 
-func (a *app) newVotingResultsTable(previous tview.Primitive, votingUID eas.UID, results []schulze.Result[string]) tview.Primitive {
+func (a *app) newVotingResultsTable(previous tview.Primitive, results *votingResults) tview.Primitive {
 	table := tview.NewTable()
 	table.SetBorders(true)
 	table.SetCell(0, 0, tview.NewTableCell("Choice"))
 	table.SetCell(0, 1, tview.NewTableCell("Wins"))
-	for i, r := range results {
+	for i, r := range results.Results {
 		table.SetCell(i+1, 0, tview.NewTableCell(r.Choice))
 		table.SetCell(i+1, 1, tview.NewTableCell(strconv.FormatUint(uint64(r.Wins), 10)))
 	}
+	row := len(results.Results) + 1
+	addTotal := func(name string, value int) {
+		table.SetCell(row, 0, tview.NewTableCell(name))
+		table.SetCell(row, 1, tview.NewTableCell(strconv.Itoa(value)))
+		row++
+	}
+	addTotal("Ballots", results.Ballots)
+	if results.WeightedPreferences != nil {
+		addTotal("Weight", results.Weight)
+	}
+	if len(results.ZeroWeight) > 0 {
+		addTotal("Zero weight ballots", len(results.ZeroWeight))
+	}
+	addTotal("Attestations", results.Attestations)
+	addTotal("Superseded", len(results.Superseded))
+	addTotal("Not counted", results.notCounted())
 
 	table.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		form := tview.NewForm()
 		form.AddButton("OK", func() {
 			a.render(previous)
 		})
-		form.SetBorder(true).SetTitle(" Voting " + votingUID.String() + " ").SetTitleAlign(tview.AlignLeft)
+		form.AddButton("Pairwise preferences", func() {
+			a.render(a.newVotingMatrixTable(table, results, " Pairwise preferences ", results.Preferences))
+		})
+		if results.WeightedPreferences != nil {
+			form.AddButton("Weighted preferences", func() {
+				a.render(a.newVotingMatrixTable(table, results, " Weighted pairwise preferences ", results.WeightedPreferences))
+			})
+		}
+		if len(results.Delegations) > 0 || len(results.DelegationCycles) > 0 {
+			form.AddButton("Delegations", func() {
+				a.render(a.newDelegationsTable(table, results))
+			})
+		}
+		form.AddButton("Strongest paths", func() {
+			a.render(a.newVotingMatrixTable(table, results, " Strongest paths ", results.Strengths))
+		})
+		if len(results.Ineligible) > 0 || len(results.Early) > 0 || len(results.Late) > 0 || len(results.Revoked) > 0 || len(results.Unrevealed) > 0 || len(results.Undecryptable) > 0 || len(results.Invalid) > 0 || len(results.Superseded) > 0 {
+			form.AddButton("Not counted ballots", func() {
+				a.render(a.newNotCountedBallotsTable(table, results))
+			})
+		}
+		form.SetBorder(true).SetTitle(" Voting " + results.VotingUID.String() + " ").SetTitleAlign(tview.AlignLeft)
 		a.render(form)
 		return nil
 	})
 
-	table.SetBorder(true).SetTitle(" Voting " + votingUID.String() + " ").SetTitleAlign(tview.AlignLeft)
+	table.SetBorder(true).SetTitle(" Voting " + results.VotingUID.String() + " ").SetTitleAlign(tview.AlignLeft)
+	return table
+}
+
+// newVotingMatrixTable shows a matrix of values for every pair of choices
+// where the value in the row of one choice is highlighted if it is greater
+// than the value in the row of the other choice.
+func (a *app) newVotingMatrixTable(previous tview.Primitive, results *votingResults, title string, matrix [][]int) tview.Primitive {
+	table := tview.NewTable()
+	table.SetBorders(true)
+	table.SetFixed(1, 1)
+	choices := results.Voting.Choices
+	for i, choice := range choices {
+		table.SetCell(0, i+1, tview.NewTableCell(choice).SetAlign(tview.AlignCenter))
+		table.SetCell(i+1, 0, tview.NewTableCell(choice))
+	}
+	for i := range choices {
+		for j := range choices {
+			if i == j {
+				table.SetCell(i+1, j+1, tview.NewTableCell("-").SetAlign(tview.AlignCenter))
+				continue
+			}
+			cell := tview.NewTableCell(strconv.Itoa(matrix[i][j])).SetAlign(tview.AlignRight)
+			if matrix[i][j] > matrix[j][i] {
+				cell.SetTextColor(tcell.ColorGreen).SetAttributes(tcell.AttrBold)
+			}
+			table.SetCell(i+1, j+1, cell)
+		}
+	}
+
+	table.SetDoneFunc(func(key tcell.Key) {
+		a.render(previous)
+	})
+
+	table.SetBorder(true).SetTitle(title).SetTitleAlign(tview.AlignLeft)
+	return table
+}
+
+// newDelegationsTable shows the weight that every ballot carried through
+// delegation and the delegation cycles that were not counted.
+func (a *app) newDelegationsTable(previous tview.Primitive, results *votingResults) tview.Primitive {
+	table := tview.NewTable()
+	table.SetBorders(true)
+	table.SetFixed(1, 0)
+	table.SetCell(0, 0, tview.NewTableCell("Attester"))
+	table.SetCell(0, 1, tview.NewTableCell("Weight"))
+	table.SetCell(0, 2, tview.NewTableCell("Delegated weight"))
+	table.SetCell(0, 3, tview.NewTableCell("Delegators"))
+	row := 1
+	for _, d := range results.Delegations {
+		table.SetCell(row, 0, tview.NewTableCell(d.Attester.String()))
+		table.SetCell(row, 1, tview.NewTableCell(strconv.Itoa(d.Weight)).SetAlign(tview.AlignRight))
+		table.SetCell(row, 2, tview.NewTableCell(strconv.Itoa(d.DelegatedWeight)).SetAlign(tview.AlignRight))
+		table.SetCell(row, 3, tview.NewTableCell(strconv.Itoa(len(d.Delegators))).SetAlign(tview.AlignRight))
+		row++
+	}
+	for _, c := range results.DelegationCycles {
+		table.SetCell(row, 0, tview.NewTableCell("Cycle: "+formatDelegationCycle(c)))
+		row++
+	}
+
+	table.SetDoneFunc(func(key tcell.Key) {
+		a.render(previous)
+	})
+
+	table.SetBorder(true).SetTitle(" Delegations ").SetTitleAlign(tview.AlignLeft)
+	return table
+}
+
+func (a *app) newNotCountedBallotsTable(previous tview.Primitive, results *votingResults) tview.Primitive {
+	table := tview.NewTable()
+	table.SetBorders(true)
+	table.SetFixed(1, 0)
+	table.SetCell(0, 0, tview.NewTableCell("Reason"))
+	table.SetCell(0, 1, tview.NewTableCell("Attester"))
+	table.SetCell(0, 2, tview.NewTableCell("Time"))
+	table.SetCell(0, 3, tview.NewTableCell("Block"))
+	invalid := make([]ballotRecord, 0, len(results.Invalid))
+	for _, b := range results.Invalid {
+		invalid = append(invalid, b.ballotRecord)
+	}
+	superseded := make([]ballotRecord, 0, len(results.Superseded))
+	for _, b := range results.Superseded {
+		superseded = append(superseded, b.ballotRecord)
+	}
+	row := 1
+	for _, s := range []struct {
+		reason  string
+		records []ballotRecord
+	}{
+		{reason: "Ineligible", records: results.Ineligible},
+		{reason: "Early", records: results.Early},
+		{reason: "Late", records: results.Late},
+		{reason: "Revoked", records: results.Revoked},
+		{reason: "Unrevealed", records: results.Unrevealed},
+		{reason: "Undecryptable", records: results.Undecryptable},
+		{reason: "Invalid", records: invalid},
+		{reason: "Superseded", records: superseded},
+	} {
+		for _, r := range s.records {
+			table.SetCell(row, 0, tview.NewTableCell(s.reason))
+			table.SetCell(row, 1, tview.NewTableCell(r.Attester.String()))
+			table.SetCell(row, 2, tview.NewTableCell(formatTime(r.Time)))
+			table.SetCell(row, 3, tview.NewTableCell(strconv.FormatUint(r.Block, 10)))
+			row++
+		}
+	}
+
+	table.SetDoneFunc(func(key tcell.Key) {
+		a.render(previous)
+	})
+
+	table.SetBorder(true).SetTitle(" Not counted ballots ").SetTitleAlign(tview.AlignLeft)
 	return table
 }
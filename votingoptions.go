@@ -0,0 +1,160 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"resenje.org/eas"
+)
+
+// Names of options of the votingSchemaV3.
+const (
+	allowlistOption        = "allowlist"
+	membershipOption       = "membership"
+	weightOption           = "weight"
+	delegationOption       = "delegation"
+	commitRevealOption     = "commitReveal"
+	encryptionOption       = "encryption"
+	homomorphicTallyOption = "homomorphicTally"
+)
+
+// votingOptionArguments are abi types of values of voting options. Options
+// without arguments enable the feature only by being present.
+var votingOptionArguments = map[string]abi.Arguments{
+	allowlistOption: {
+		{Name: "root", Type: mustNewABIType("bytes32", nil)},
+	},
+	membershipOption: {
+		{Name: "schemaUID", Type: mustNewABIType("bytes32", nil)},
+		{Name: "issuers", Type: mustNewABIType("address[]", nil)},
+		{Name: "schemaBlock", Type: mustNewABIType("uint64", nil)},
+	},
+	weightOption: {
+		{Name: "token", Type: mustNewABIType("address", nil)},
+		{Name: "block", Type: mustNewABIType("uint64", nil)},
+	},
+	delegationOption: nil,
+	commitRevealOption: {
+		{Name: "revealEndTime", Type: mustNewABIType("uint64", nil)},
+	},
+	encryptionOption: {
+		{Name: "tallierPublicKey", Type: mustNewABIType("bytes", nil)},
+	},
+	homomorphicTallyOption: nil,
+}
+
+func mustNewABIType(t string, components []abi.ArgumentMarshaling) abi.Type {
+	typ, err := abi.NewType(t, "", components)
+	if err != nil {
+		panic(err)
+	}
+	return typ
+}
+
+// votingOptionSchemas are schemas that the config must have for votings
+// with the option.
+var votingOptionSchemas = map[string][]string{
+	delegationOption:       {delegationSchemaName},
+	commitRevealOption:     {commitmentSchemaName, revealSchemaName},
+	encryptionOption:       {encryptedBallotSchemaName},
+	homomorphicTallyOption: {pairwiseBallotSchemaName},
+}
+
+// encodeVotingOptions returns options of features that are enabled in the
+// voting.
+func encodeVotingOptions(v *voting) ([]votingOption, error) {
+	var options []votingOption
+	add := func(name string, values ...any) error {
+		value, err := votingOptionArguments[name].Pack(values...)
+		if err != nil {
+			return fmt.Errorf("encode voting option %s: %w", name, err)
+		}
+		options = append(options, votingOption{Name: name, Value: value})
+		return nil
+	}
+	if v.AllowlistRoot != (common.Hash{}) {
+		if err := add(allowlistOption, [32]byte(v.AllowlistRoot)); err != nil {
+			return nil, err
+		}
+	}
+	if !v.MembershipSchemaUID.IsZero() {
+		if err := add(membershipOption, [32]byte(v.MembershipSchemaUID), v.MembershipIssuers, v.MembershipSchemaBlock); err != nil {
+			return nil, err
+		}
+	}
+	if v.isWeighted() {
+		if err := add(weightOption, v.WeightToken, v.WeightBlock); err != nil {
+			return nil, err
+		}
+	}
+	if v.Delegation {
+		if err := add(delegationOption); err != nil {
+			return nil, err
+		}
+	}
+	if v.CommitReveal {
+		if err := add(commitRevealOption, unixTimestamp(v.RevealEnd)); err != nil {
+			return nil, err
+		}
+	}
+	if v.isEncrypted() {
+		if err := add(encryptionOption, []byte(v.TallierPublicKey)); err != nil {
+			return nil, err
+		}
+	}
+	if v.HomomorphicTally {
+		if err := add(homomorphicTallyOption); err != nil {
+			return nil, err
+		}
+	}
+	return options, nil
+}
+
+// decodeVotingOptions enables features of the voting from its options. Votings
+// with unknown or repeated options are rejected, as they could not be counted
+// by their rules.
+func decodeVotingOptions(v *voting, options []votingOption) error {
+	seen := make(map[string]struct{}, len(options))
+	for _, o := range options {
+		arguments, ok := votingOptionArguments[o.Name]
+		if !ok {
+			return fmt.Errorf("unsupported voting option %q", o.Name)
+		}
+		if _, ok := seen[o.Name]; ok {
+			return fmt.Errorf("repeated voting option %q", o.Name)
+		}
+		seen[o.Name] = struct{}{}
+
+		values, err := arguments.Unpack(o.Value)
+		if err != nil {
+			return fmt.Errorf("decode voting option %s: %w", o.Name, err)
+		}
+		switch o.Name {
+		case allowlistOption:
+			v.AllowlistRoot = values[0].([32]byte)
+		case membershipOption:
+			v.MembershipSchemaUID = eas.UID(values[0].([32]byte))
+			v.MembershipIssuers = values[1].([]common.Address)
+			v.MembershipSchemaBlock = values[2].(uint64)
+		case weightOption:
+			v.WeightToken = values[0].(common.Address)
+			v.WeightBlock = values[1].(uint64)
+		case delegationOption:
+			v.Delegation = true
+		case commitRevealOption:
+			v.CommitReveal = true
+			v.RevealEnd = unixTime(values[0].(uint64))
+		case encryptionOption:
+			v.TallierPublicKey = values[0].([]byte)
+		case homomorphicTallyOption:
+			v.HomomorphicTally = true
+		}
+	}
+	return nil
+}
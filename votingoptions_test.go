@@ -0,0 +1,74 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"resenje.org/eas"
+)
+
+func TestVotingOptions(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assertNilError(t, err)
+
+	want := &voting{
+		AllowlistRoot:         common.HexToHash("0x01"),
+		MembershipSchemaUID:   eas.HexDecodeUID("0x02"),
+		MembershipIssuers:     []common.Address{common.HexToAddress("0x03"), common.HexToAddress("0x04")},
+		MembershipSchemaBlock: 8,
+		WeightToken:           common.HexToAddress("0x05"),
+		WeightBlock:           6,
+		Delegation:            true,
+		CommitReveal:          true,
+		RevealEnd:             time.Unix(7, 0),
+		TallierPublicKey:      crypto.CompressPubkey(&key.PublicKey),
+		HomomorphicTally:      true,
+	}
+
+	options, err := encodeVotingOptions(want)
+	assertNilError(t, err)
+	assertEqual(t, "options", len(options), len(votingOptionArguments))
+
+	got := new(voting)
+	assertNilError(t, decodeVotingOptions(got, options))
+	assertEqual(t, "voting", got, want)
+
+	options, err = encodeVotingOptions(&voting{})
+	assertNilError(t, err)
+	assertEqual(t, "options", len(options), 0)
+
+	if err := decodeVotingOptions(new(voting), []votingOption{{Name: "quorum"}}); err == nil {
+		t.Error("decoded unsupported option")
+	}
+	options, err = encodeVotingOptions(want)
+	assertNilError(t, err)
+	if err := decodeVotingOptions(new(voting), append(options, options[0])); err == nil {
+		t.Error("decoded repeated option")
+	}
+	if err := decodeVotingOptions(new(voting), []votingOption{{Name: allowlistOption}}); err == nil {
+		t.Error("decoded option without value")
+	}
+}
+
+func TestScanConfigSchema_legacy(t *testing.T) {
+	data := make([]byte, 4*32)
+	data[31] = 1
+	data[63] = 2
+	data[95] = 3
+	data[127] = 4
+
+	config, err := scanConfigSchema(&eas.Attestation{Data: data})
+	assertNilError(t, err)
+	assertEqual(t, "voting schema", config.VotingSchemaUID, eas.HexDecodeUID("0x01"))
+	assertEqual(t, "voting schema block", config.VotingSchemaBlock, uint64(2))
+	assertEqual(t, "ballot schema", config.BallotSchemaUID, eas.HexDecodeUID("0x03"))
+	assertEqual(t, "ballot schema block", config.BallotSchemaBlock, uint64(4))
+	assertEqual(t, "voting v3 schema", config.schema(votingV3SchemaName).UID, eas.UID{})
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// tokenABI contains methods of ERC-20 and ERC-721 tokens that are used for
+// weighting ballots. Method decimals is optional for ERC-20 tokens and it is
+// not defined for ERC-721 tokens.
+var tokenABI = mustParseABI(`[{"inputs":[{"internalType":"address","name":"account","type":"address"}],"name":"balanceOf","outputs":[{"internalType":"uint256","name":"","type":"uint256"}],"stateMutability":"view","type":"function"},{"inputs":[],"name":"decimals","outputs":[{"internalType":"uint8","name":"","type":"uint8"}],"stateMutability":"view","type":"function"}]`)
+
+// getWeights returns balances of the voting weight token at the snapshot block
+// for every address. Balances are in whole token units, using the decimals of
+// the token if it defines them, so that the sum of all weights fits the
+// preferences matrix values. Balances are rounded down, so addresses with
+// less than one whole token have zero weight.
+func (a *app) getWeights(ctx context.Context, v *voting, addresses []common.Address) (map[common.Address]int, error) {
+	currentBlock, err := a.client.Backend().(ethereum.BlockNumberReader).BlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if v.WeightBlock > currentBlock {
+		return nil, fmt.Errorf("snapshot block %v is not mined yet", v.WeightBlock)
+	}
+	block := new(big.Int).SetUint64(v.WeightBlock)
+
+	unit := big.NewInt(1)
+	values, err := a.callToken(ctx, v.WeightToken, block, "decimals")
+	switch {
+	case err == nil:
+		unit.Exp(big.NewInt(10), big.NewInt(int64(values[0].(uint8))), nil)
+	case !isExecutionReverted(err) && !errors.Is(err, errEmptyCallResult):
+		return nil, fmt.Errorf("token decimals: %w", err)
+	}
+
+	inputs := make([][]byte, 0, len(addresses))
+	for _, address := range addresses {
+		data, err := tokenABI.Pack("balanceOf", address)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, data)
+	}
+	results, err := a.callContractBatch(ctx, v.WeightToken, block, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	weights := make(map[common.Address]int, len(addresses))
+	total := new(big.Int)
+	for i, address := range addresses {
+		values, err := unpackTokenResult("balanceOf", results[i])
+		if err != nil {
+			return nil, fmt.Errorf("token balance of %s: %w", address, err)
+		}
+		w := new(big.Int).Quo(values[0].(*big.Int), unit)
+		total.Add(total, w)
+		if total.Cmp(big.NewInt(math.MaxInt)) > 0 {
+			return nil, errors.New("total weight of ballots is too large")
+		}
+		weights[address] = int(w.Int64())
+	}
+	return weights, nil
+}
+
+func (a *app) callToken(ctx context.Context, token common.Address, block *big.Int, method string, args ...any) ([]any, error) {
+	data, err := tokenABI.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
+	result, err := a.client.Backend().CallContract(ctx, ethereum.CallMsg{
+		To:   &token,
+		Data: data,
+	}, block)
+	return unpackTokenResult(method, callResult{Data: result, Err: err})
+}
+
+func unpackTokenResult(method string, r callResult) ([]any, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	if len(r.Data) == 0 {
+		return nil, errEmptyCallResult
+	}
+	return tokenABI.Unpack(method, r.Data)
+}
+
+// errEmptyCallResult is returned when the called contract method does not
+// exist and the contract does not revert the call.
+var errEmptyCallResult = errors.New("empty call result")
+
+func isExecutionReverted(err error) bool {
+	return strings.Contains(err.Error(), "execution reverted")
+}
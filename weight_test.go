@@ -0,0 +1,182 @@
+// Copyright (c) 2024, Janoš Guljaš <janos@resenje.org>
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/asm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestCalculateResults_weight(t *testing.T) {
+	c := newTestChain(t, 5)
+
+	ctx := context.Background()
+
+	token := c.deployTestToken(t, c.accounts[0])
+
+	voters := c.accounts[1:5]
+	for i, balance := range []int64{5, 1, 1, 0} {
+		c.setTestTokenBalance(t, c.accounts[0], token, crypto.PubkeyToAddress(voters[i].PublicKey), balance)
+	}
+	snapshot, err := c.backend.BlockNumber(ctx)
+	assertNilError(t, err)
+
+	// balances after the snapshot block must not change the results
+	c.setTestTokenBalance(t, c.accounts[0], token, crypto.PubkeyToAddress(voters[0].PublicKey), 0)
+	c.setTestTokenBalance(t, c.accounts[0], token, crypto.PubkeyToAddress(voters[3].PublicKey), 100)
+
+	organizer := c.newApp(t, c.accounts[0])
+	v := createTestVoting(t, organizer, &voting{
+		Title:       "Treasury allocation",
+		Choices:     []string{"Grants", "Buyback"},
+		WeightToken: token,
+		WeightBlock: snapshot,
+	})
+	assertEqual(t, "weight token", v.WeightToken, token)
+	assertEqual(t, "weight block", v.WeightBlock, snapshot)
+
+	for i, ranking := range []string{
+		"1,2",
+		"2,1",
+		"2,1",
+		"2,1",
+	} {
+		castTestBallot(t, c.newApp(t, voters[i]), v, ranking)
+	}
+
+	r, err := organizer.calculateResults(ctx, v.UID, tallyOptions{})
+	assertNilError(t, err)
+
+	assertEqual(t, "ballots", r.Ballots, 4)
+	assertEqual(t, "weight", r.Weight, 7)
+	assertEqual(t, "preferences", r.Preferences, [][]int{{0, 1}, {3, 0}})
+	assertEqual(t, "weighted preferences", r.WeightedPreferences, [][]int{{0, 5}, {2, 0}})
+	assertEqual(t, "results", resultsChoices(r), []string{"Grants", "Buyback"})
+	assertEqual(t, "zero weight ballots", len(r.ZeroWeight), 1)
+	assertEqual(t, "zero weight attester", r.ZeroWeight[0].Attester, crypto.PubkeyToAddress(voters[3].PublicKey))
+
+	// balances are fetched with a batch request
+	service := &testEthService{rpc: c.backend.rpc}
+	batched, err := c.newBatchApp(t, c.accounts[0], service).calculateResults(ctx, v.UID, tallyOptions{})
+	assertNilError(t, err)
+	assertEqual(t, "batched weight", batched.Weight, r.Weight)
+	assertEqual(t, "batched weighted preferences", batched.WeightedPreferences, r.WeightedPreferences)
+	if service.calls.Load() < int64(len(voters)) {
+		t.Errorf("got %v batched calls, want at least %v", service.calls.Load(), len(voters))
+	}
+
+	unmined := createTestVoting(t, organizer, &voting{
+		Title:       "Future snapshot",
+		Choices:     []string{"Grants", "Buyback"},
+		WeightToken: token,
+		WeightBlock: snapshot + 1000,
+	})
+	castTestBallot(t, c.newApp(t, voters[0]), unmined, "1,2")
+
+	if _, err := organizer.calculateResults(ctx, unmined.UID, tallyOptions{}); err == nil {
+		t.Error("got no error for a snapshot block that is not mined")
+	}
+}
+
+// testTokenRuntime is the runtime code of a minimal token with balanceOf and
+// decimals methods. Any other call with an address and a value arguments sets
+// the balance of the address to the value, without any access control.
+const testTokenRuntime = `
+	PUSH 0
+	CALLDATALOAD
+	PUSH 0xe0
+	SHR
+	DUP1
+	PUSH 0x70a08231
+	EQ
+	JUMPI @balanceOf
+	PUSH 0x313ce567
+	EQ
+	JUMPI @decimals
+	PUSH 0x24
+	CALLDATALOAD
+	PUSH 0x04
+	CALLDATALOAD
+	SSTORE
+	STOP
+balanceOf:
+	PUSH 0x04
+	CALLDATALOAD
+	SLOAD
+	PUSH 0
+	MSTORE
+	PUSH 0x20
+	PUSH 0
+	RETURN
+decimals:
+	PUSH 18
+	PUSH 0
+	MSTORE
+	PUSH 0x20
+	PUSH 0
+	RETURN
+`
+
+// deployTestToken deploys the token with testTokenRuntime code.
+func (c *testChain) deployTestToken(t testing.TB, account *ecdsa.PrivateKey) common.Address {
+	t.Helper()
+
+	compiler := asm.NewCompiler(false)
+	compiler.Feed(asm.Lex([]byte(testTokenRuntime), false))
+	h, errs := compiler.Compile()
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	runtime, err := hex.DecodeString(h)
+	assertNilError(t, err)
+
+	// init code copies the runtime code to memory and returns it
+	code := append([]byte{
+		0x60, byte(len(runtime)), // PUSH1 runtime length
+		0x80,       // DUP1
+		0x60, 0x0b, // PUSH1 runtime offset
+		0x60, 0x00, // PUSH1 0
+		0x39,       // CODECOPY
+		0x60, 0x00, // PUSH1 0
+		0xf3, // RETURN
+	}, runtime...)
+
+	address, _, _, err := bind.DeployContract(c.transactOpts(t, account), abi.ABI{}, code, c.backend)
+	assertNilError(t, err)
+	return address
+}
+
+// setTestTokenBalance sets the balance of the address in whole token units.
+func (c *testChain) setTestTokenBalance(t testing.TB, account *ecdsa.PrivateKey, token, address common.Address, balance int64) {
+	t.Helper()
+
+	value := new(big.Int).Mul(big.NewInt(balance), new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil))
+	data := append(crypto.Keccak256([]byte("setBalance(address,uint256)"))[:4], common.LeftPadBytes(address[:], 32)...)
+	data = append(data, common.LeftPadBytes(value.Bytes(), 32)...)
+
+	contract := bind.NewBoundContract(token, abi.ABI{}, c.backend, c.backend, c.backend)
+	_, err := contract.RawTransact(c.transactOpts(t, account), data)
+	assertNilError(t, err)
+}
+
+func (c *testChain) transactOpts(t testing.TB, account *ecdsa.PrivateKey) *bind.TransactOpts {
+	t.Helper()
+
+	chainID, err := c.backend.ChainID(context.Background())
+	assertNilError(t, err)
+	opts, err := bind.NewKeyedTransactorWithChainID(account, chainID)
+	assertNilError(t, err)
+	return opts
+}